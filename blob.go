@@ -0,0 +1,77 @@
+package permgit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// readBlobContent reads the content of the blob with the hash from the storer.
+func readBlobContent(s storer.EncodedObjectStorer, hash plumbing.Hash) ([]byte, error) {
+	blob, err := object.GetBlob(s, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain blob %s: %w", hash, err)
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+
+	return content, nil
+}
+
+// blobReader returns a function opening the blob in the storer for reading.
+func blobReader(s storer.EncodedObjectStorer, hash plumbing.Hash) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		blob, err := object.GetBlob(s, hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain blob %s: %w", hash, err)
+		}
+
+		return blob.Reader()
+	}
+}
+
+// saveBlob writes the content as a blob into the storer.
+func saveBlob(s storer.EncodedObjectStorer, content []byte) (plumbing.Hash, error) {
+	return saveBlobFrom(s, bytes.NewReader(content), int64(len(content)))
+}
+
+// saveBlobFrom writes the content read from r, which must have the size, as a blob into the storer.
+func saveBlobFrom(s storer.EncodedObjectStorer, r io.Reader, size int64) (plumbing.Hash, error) {
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(size)
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to obtain writer for blob: %w", err)
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return plumbing.ZeroHash, fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to write blob: %w", err)
+	}
+	if n != size {
+		return plumbing.ZeroHash, fmt.Errorf("blob has %d bytes, expecting %d", n, size)
+	}
+
+	hash, err := s.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to save blob: %w", err)
+	}
+
+	return hash, nil
+}
@@ -0,0 +1,178 @@
+package permgit
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// SizePolicy decides what to do with the blobs larger than the limit of [BlobSizeLimit].
+type SizePolicy string
+
+const (
+	SizePolicy_Drop SizePolicy = "drop" // drop the file from the filtered tree.
+	SizePolicy_Fail SizePolicy = "fail" // fail the filtering with [BlobTooLargeError].
+	SizePolicy_LFS  SizePolicy = "lfs"  // replace the file with a Git LFS pointer, and write the content into the [LFSStore].
+)
+
+// BlobTooLargeError is returned when a blob is larger than the limit with [SizePolicy_Fail].
+type BlobTooLargeError struct {
+	Path    string
+	Hash    plumbing.Hash
+	Size    int64
+	MaxSize int64
+}
+
+func (e *BlobTooLargeError) Error() string {
+	return fmt.Sprintf("%s (blob %s) has %d bytes, larger than the limit %d", e.Path, e.Hash, e.Size, e.MaxSize)
+}
+
+// BlobSizeLimit applies the [SizePolicy] to the blobs larger than MaxSize when filtering.
+//
+// With [SizePolicy_LFS], the Git LFS pointer files are not tracked by .gitattributes in the filtered tree,
+// add "filter=lfs diff=lfs merge=lfs -text" for the paths to .gitattributes in the filtered repo to check them out with git lfs.
+type BlobSizeLimit struct {
+	maxSize  int64
+	policy   SizePolicy
+	lfsStore *LFSStore
+
+	// pointers caches the hashes of the pointer files of the converted blobs.
+	pointers map[plumbing.Hash]plumbing.Hash
+}
+
+// NewBlobSizeLimit creates a new [BlobSizeLimit]. The lfs store is required for [SizePolicy_LFS], and ignored otherwise.
+func NewBlobSizeLimit(maxSize int64, policy SizePolicy, lfsStore *LFSStore) (*BlobSizeLimit, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("size limit must be positive: %d", maxSize)
+	}
+
+	switch policy {
+	case SizePolicy_Drop, SizePolicy_Fail:
+	case SizePolicy_LFS:
+		if lfsStore == nil {
+			return nil, fmt.Errorf("lfs store is required for size policy %s", policy)
+		}
+	default:
+		return nil, fmt.Errorf("unknown size policy: %s", policy)
+	}
+
+	return &BlobSizeLimit{
+		maxSize:  maxSize,
+		policy:   policy,
+		lfsStore: lfsStore,
+		pointers: make(map[plumbing.Hash]plumbing.Hash),
+	}, nil
+}
+
+// MaxSize returns the size limit.
+func (l *BlobSizeLimit) MaxSize() int64 {
+	return l.maxSize
+}
+
+// Policy returns the policy for the blobs larger than the limit.
+func (l *BlobSizeLimit) Policy() SizePolicy {
+	return l.policy
+}
+
+// LFSStore returns the store of the Git LFS objects, which is nil unless the policy is [SizePolicy_LFS].
+func (l *BlobSizeLimit) LFSStore() *LFSStore {
+	return l.lfsStore
+}
+
+// limit applies the policy to the blob at the path, and writes the pointer file into the target if the blob is converted.
+// It returns the hash of the file to include in the filtered tree, and false if the file is dropped.
+// Nil limit returns the blob as is.
+func (l *BlobSizeLimit) limit(target storer.EncodedObjectStorer, paths []string, hash plumbing.Hash, size int64, open func() (io.ReadCloser, error)) (plumbing.Hash, bool, error) {
+	if !l.exceeds(size) {
+		return hash, true, nil
+	}
+
+	fullpath := pathsToFullPath(paths)
+
+	switch l.policy {
+	case SizePolicy_Drop:
+		logger.Info("drop large file", "path", fullpath, "blob", hash, "size", size)
+		return plumbing.ZeroHash, false, nil
+	case SizePolicy_Fail:
+		return plumbing.ZeroHash, false, &BlobTooLargeError{Path: fullpath, Hash: hash, Size: size, MaxSize: l.maxSize}
+	}
+
+	if pointerhash, found := l.pointers[hash]; found {
+		return pointerhash, true, nil
+	}
+
+	logger.Info("convert large file to lfs pointer", "path", fullpath, "blob", hash, "size", size)
+	r, err := open()
+	if err != nil {
+		return plumbing.ZeroHash, false, fmt.Errorf("failed to read %s: %w", fullpath, err)
+	}
+	defer r.Close()
+
+	pointer, err := l.lfsStore.Put(r)
+	if err != nil {
+		return plumbing.ZeroHash, false, fmt.Errorf("failed to write %s into lfs store: %w", fullpath, err)
+	}
+
+	pointerhash, err := saveBlob(target, pointer.Bytes())
+	if err != nil {
+		return plumbing.ZeroHash, false, fmt.Errorf("failed to write lfs pointer for %s: %w", fullpath, err)
+	}
+	l.pointers[hash] = pointerhash
+
+	return pointerhash, true, nil
+}
+
+// exceeds checks if a blob of the size is larger than the limit. Nil limit has no limit.
+func (l *BlobSizeLimit) exceeds(size int64) bool {
+	return l != nil && size > l.maxSize
+}
+
+// matchesPointer checks if the blob in the target is the content of the Git LFS pointer file in the source.
+func (l *BlobSizeLimit) matchesPointer(source storer.EncodedObjectStorer, pointerhash plumbing.Hash, target storer.EncodedObjectStorer, blobhash plumbing.Hash) (bool, error) {
+	if l == nil || l.policy != SizePolicy_LFS {
+		return false, nil
+	}
+
+	pointer, err := readLFSPointer(source, pointerhash)
+	if err != nil || pointer == nil {
+		return false, err
+	}
+	blobpointer, err := lfsPointerOf(target, blobhash)
+	if err != nil {
+		return false, err
+	}
+
+	return *pointer == *blobpointer, nil
+}
+
+// restore writes the content of the Git LFS pointer file in the source into the target if the object is in the lfs store.
+// It returns false if the blob is not a pointer or the object is not in the store, and the pointer file should be kept as is.
+func (l *BlobSizeLimit) restore(source storer.EncodedObjectStorer, pointerhash plumbing.Hash, target storer.EncodedObjectStorer) (plumbing.Hash, bool, error) {
+	if l == nil || l.policy != SizePolicy_LFS {
+		return plumbing.ZeroHash, false, nil
+	}
+
+	pointer, err := readLFSPointer(source, pointerhash)
+	if err != nil || pointer == nil {
+		return plumbing.ZeroHash, false, err
+	}
+	if !l.lfsStore.Has(pointer) {
+		logger.Warn("lfs object not in store, keep the pointer", "oid", pointer.OID, "pointer", pointerhash)
+		return plumbing.ZeroHash, false, nil
+	}
+
+	r, err := l.lfsStore.Open(pointer)
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+	defer r.Close()
+
+	hash, err := saveBlobFrom(target, r, pointer.Size)
+	if err != nil {
+		return plumbing.ZeroHash, false, fmt.Errorf("failed to restore lfs object %s: %w", pointer.OID, err)
+	}
+
+	return hash, true, nil
+}
@@ -0,0 +1,269 @@
+package permgit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// BlobTransformer transforms the content of a file, for example to redact internal information before the file is published.
+// The transformation must be deterministic.
+type BlobTransformer interface {
+	Transform(paths []string, content []byte) ([]byte, error)
+}
+
+// RegexReplaceTransformer replaces the matches of the regular expression with the replacement,
+// see [regexp.Regexp.ReplaceAll] for the syntax of the replacement.
+type RegexReplaceTransformer struct {
+	regexp      *regexp.Regexp
+	replacement []byte
+}
+
+var _ BlobTransformer = (*RegexReplaceTransformer)(nil)
+
+// NewRegexReplaceTransformer creates a new [RegexReplaceTransformer].
+func NewRegexReplaceTransformer(pattern string, replacement string) (*RegexReplaceTransformer, error) {
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile regex %s: %w", pattern, err)
+	}
+
+	return &RegexReplaceTransformer{
+		regexp:      r,
+		replacement: []byte(replacement),
+	}, nil
+}
+
+func (t *RegexReplaceTransformer) Transform(paths []string, content []byte) ([]byte, error) {
+	return t.regexp.ReplaceAll(content, t.replacement), nil
+}
+
+// MarkerBlockTransformer removes the blocks of lines delimited by the begin and end markers.
+// A block starts with the line containing the begin marker and ends with the line containing the end marker, both lines are removed.
+// A block without the end marker is an error.
+type MarkerBlockTransformer struct {
+	begin []byte
+	end   []byte
+}
+
+var _ BlobTransformer = (*MarkerBlockTransformer)(nil)
+
+// NewMarkerBlockTransformer creates a new [MarkerBlockTransformer].
+func NewMarkerBlockTransformer(begin string, end string) (*MarkerBlockTransformer, error) {
+	if begin == "" || end == "" {
+		return nil, fmt.Errorf("begin and end markers cannot be empty")
+	}
+
+	return &MarkerBlockTransformer{
+		begin: []byte(begin),
+		end:   []byte(end),
+	}, nil
+}
+
+func (t *MarkerBlockTransformer) Transform(paths []string, content []byte) ([]byte, error) {
+	if !bytes.Contains(content, t.begin) {
+		return content, nil
+	}
+
+	result := make([]byte, 0, len(content))
+	inblock := false
+	beginline := 0
+	for i, line := range bytes.SplitAfter(content, []byte("\n")) {
+		switch {
+		case !inblock && bytes.Contains(line, t.begin):
+			inblock = true
+			beginline = i + 1
+			// the end marker can be on the same line after the begin marker.
+			if idx := bytes.Index(line, t.begin); bytes.Contains(line[idx+len(t.begin):], t.end) {
+				inblock = false
+			}
+		case inblock && bytes.Contains(line, t.end):
+			inblock = false
+		case !inblock:
+			result = append(result, line...)
+		}
+	}
+
+	if inblock {
+		return nil, fmt.Errorf("block started at line %d in %s is not closed by %s", beginline, pathsToFullPath(paths), t.end)
+	}
+
+	return result, nil
+}
+
+// BlobTransformRule applies the transformer to the files included by the filter.
+type BlobTransformRule struct {
+	Filter      Filter
+	Transformer BlobTransformer
+}
+
+// BlobTransformers applies a list of [BlobTransformRule] in order, and caches the transformed blobs by the source blob hash.
+// The cache is not concurrent safe.
+type BlobTransformers struct {
+	rules []BlobTransformRule
+
+	cache map[string]plumbing.Hash
+}
+
+// NewBlobTransformers creates a new [BlobTransformers].
+func NewBlobTransformers(rules ...BlobTransformRule) *BlobTransformers {
+	return &BlobTransformers{
+		rules: rules,
+		cache: make(map[string]plumbing.Hash),
+	}
+}
+
+// matchRules returns the indices of the rules applying to the file.
+func (t *BlobTransformers) matchRules(paths []string) []int {
+	var r []int
+	for i, rule := range t.rules {
+		if rule.Filter.Filter(paths, false).IsIn() {
+			r = append(r, i)
+		}
+	}
+
+	return r
+}
+
+// Applies checks if any rule applies to the file.
+func (t *BlobTransformers) Applies(paths []string) bool {
+	return t != nil && len(t.matchRules(paths)) > 0
+}
+
+// MayApplyUnder checks if any rule may apply to files under the directory.
+func (t *BlobTransformers) MayApplyUnder(dirpaths []string) bool {
+	if t == nil {
+		return false
+	}
+	for _, rule := range t.rules {
+		if rule.Filter.Filter(dirpaths, true) != FilterResult_Out {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transform transforms the blob with the hash at the path, the content of the blob is obtained by read,
+// and the transformed blob is saved into the target storer.
+// The returned bool is false if no rule applies to the file.
+func (t *BlobTransformers) transform(
+	targetStorer storer.EncodedObjectStorer,
+	paths []string,
+	hash plumbing.Hash,
+	read func() ([]byte, error),
+) (plumbing.Hash, bool, error) {
+	if t == nil {
+		return hash, false, nil
+	}
+
+	matched := t.matchRules(paths)
+	if len(matched) == 0 {
+		return hash, false, nil
+	}
+
+	var key strings.Builder
+	key.WriteString(hash.String())
+	for _, i := range matched {
+		key.WriteByte(':')
+		key.WriteString(strconv.Itoa(i))
+	}
+
+	if newhash, found := t.cache[key.String()]; found && targetStorer.HasEncodedObject(newhash) == nil {
+		return newhash, true, nil
+	}
+
+	content, err := read()
+	if err != nil {
+		return plumbing.ZeroHash, true, fmt.Errorf("failed to read %s: %w", pathsToFullPath(paths), err)
+	}
+
+	for _, i := range matched {
+		content, err = t.rules[i].Transformer.Transform(paths, content)
+		if err != nil {
+			return plumbing.ZeroHash, true, fmt.Errorf("failed to transform %s with rule %d: %w", pathsToFullPath(paths), i, err)
+		}
+	}
+
+	newhash, err := saveBlob(targetStorer, content)
+	if err != nil {
+		return plumbing.ZeroHash, true, err
+	}
+
+	logger.Debug("transformed blob", "path", pathsToFullPath(paths), "from", hash, "to", newhash)
+
+	t.cache[key.String()] = newhash
+
+	return newhash, true, nil
+}
+
+// BlobTransformRuleConfig is the json representation of a [BlobTransformRule].
+//
+// Type is one of
+//   - regex: replaces the matches of Regex with Replacement, see [NewRegexReplaceTransformer].
+//   - marker: removes the blocks between Begin and End, see [NewMarkerBlockTransformer].
+type BlobTransformRuleConfig struct {
+	// Patterns are the patterns of the files the rule applies to, see [NewOrFilterForPatterns].
+	Patterns []string `json:"patterns"`
+	Type     string   `json:"type"`
+
+	Regex       string `json:"regex,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+
+	Begin string `json:"begin,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+// BlobTransformRule creates the [BlobTransformRule] from the config.
+func (c *BlobTransformRuleConfig) BlobTransformRule() (*BlobTransformRule, error) {
+	if len(c.Patterns) == 0 {
+		return nil, fmt.Errorf("patterns cannot be empty")
+	}
+	filter, err := NewOrFilterForPatterns(c.Patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	var transformer BlobTransformer
+	switch c.Type {
+	case "regex":
+		transformer, err = NewRegexReplaceTransformer(c.Regex, c.Replacement)
+	case "marker":
+		transformer, err = NewMarkerBlockTransformer(c.Begin, c.End)
+	default:
+		err = fmt.Errorf("unknown transform type: %q", c.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &BlobTransformRule{
+		Filter:      filter,
+		Transformer: transformer,
+	}, nil
+}
+
+// LoadBlobTransformers parses a json array of [BlobTransformRuleConfig] into [BlobTransformers].
+func LoadBlobTransformers(content []byte) (*BlobTransformers, error) {
+	var configs []BlobTransformRuleConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse transform rules: %w", err)
+	}
+
+	rules := make([]BlobTransformRule, 0, len(configs))
+	for i := range configs {
+		rule, err := configs[i].BlobTransformRule()
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform rule %d: %w", i, err)
+		}
+		rules = append(rules, *rule)
+	}
+
+	return NewBlobTransformers(rules...), nil
+}
@@ -0,0 +1,99 @@
+package permgit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+const testTransformRules = `[
+	{"patterns": ["conf/"], "type": "regex", "regex": "[a-z]+\\.internal\\.example\\.com", "replacement": "example.com"},
+	{"patterns": ["src/**/*.go"], "type": "marker", "begin": "// INTERNAL:BEGIN", "end": "// INTERNAL:END"}
+]`
+
+func TestFilterTreeWithOptions_blobTransformers(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	orig := buildTestTree(t, s, map[string]string{
+		"conf/hosts.txt": "db.internal.example.com\n",
+		"src/main.go":    "package main\n// INTERNAL:BEGIN\nconst secret = 1\n// INTERNAL:END\nfunc main() {}\n",
+		"src/README":     "// INTERNAL:BEGIN\n",
+		"priv/x":         "x\n",
+	})
+
+	filter, err := permgit.NewOrFilterForPatterns("conf/", "src/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transformers, err := permgit.LoadBlobTransformers([]byte(testTransformRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filtered, err := permgit.FilterTreeWithOptions(ctx, orig, nil, s, filter, &permgit.FilterOptions{BlobTransformers: transformers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err = object.GetTree(s, filtered.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := readTestTree(t, filtered)
+	want := map[string]string{
+		"conf/hosts.txt": "example.com\n",
+		"src/main.go":    "package main\nfunc main() {}\n",
+		"src/README":     "// INTERNAL:BEGIN\n",
+	}
+	if len(got) != len(want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("file %s: want %q, got %q", k, v, got[k])
+		}
+	}
+
+	filteredNew := buildTestTree(t, s, map[string]string{
+		"conf/hosts.txt": "example.com\nexample.org\n",
+		"src/main.go":    "package main\nfunc main() {}\n",
+		"src/README":     "readme\n",
+	})
+
+	expandopts := &permgit.ExpandOptions{BlobTransformers: transformers}
+	_, err = permgit.ExpandTreeWithOptions(ctx, s, filtered, filteredNew, orig, s, filter, expandopts)
+	var transformedErr *permgit.TransformedFileError
+	if !errors.As(err, &transformedErr) || transformedErr.Path != "conf/hosts.txt" {
+		t.Fatalf("expecting transformed file error for conf/hosts.txt, got %v", err)
+	}
+
+	// README is not changed by the transformers, so it can be expanded.
+	filteredNew = buildTestTree(t, s, map[string]string{
+		"conf/hosts.txt": "example.com\n",
+		"src/main.go":    "package main\nfunc main() {}\n",
+		"src/README":     "readme\n",
+	})
+	expanded, err := permgit.ExpandTreeWithOptions(ctx, s, filtered, filteredNew, orig, s, filter, expandopts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestTree(t, expanded); got["src/README"] != "readme\n" || got["src/main.go"] != "package main\n// INTERNAL:BEGIN\nconst secret = 1\n// INTERNAL:END\nfunc main() {}\n" {
+		t.Errorf("unexpected expanded tree: %v", got)
+	}
+}
+
+func TestMarkerBlockTransformer_unclosed(t *testing.T) {
+	transformer, err := permgit.NewMarkerBlockTransformer("BEGIN", "END")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transformer.Transform([]string{"a"}, []byte("a\nBEGIN\nb\n")); err == nil {
+		t.Errorf("expecting error for unclosed block")
+	}
+}
@@ -0,0 +1,161 @@
+// diff-git-filter shows what changes between two filters on a tree or a commit history.
+//
+// The new filter is set by the same flags as filter-git-hist, and the old filter is one of
+// a pattern file, a serialized filter, or the filter recorded in a filtered repo by filter-git-hist with record-filter.
+//
+// For each commit whose filtered tree would change, the paths newly included are printed with +, and
+// the paths newly excluded are printed with -.
+//
+// With a tree instead of a commit history, the filters depending on the commit, such as a filter schedule, are rejected.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/spf13/cobra"
+
+	"github.com/fardream/permgit"
+	"github.com/fardream/permgit/cmd"
+)
+
+func main() {
+	newCmd().Execute()
+}
+
+type Cmd struct {
+	*cobra.Command
+
+	dir  string
+	tree string
+
+	oldPatternFile string
+	oldFilter      string
+	oldFilterRepo  string
+
+	outfilename string
+
+	cmd.HistCmd
+	cmd.FilterCmd
+	cmd.LogCmd
+}
+
+const longDescription = `diff-git-filter shows what changes between two filters on a tree or a commit history.
+
+The new filter is set by the same flags as filter-git-hist, and the old filter is one of
+a pattern file, a serialized filter, or the filter recorded in a filtered repo by filter-git-hist with record-filter.
+
+For each commit whose filtered tree would change, the paths newly included are printed with +, and
+the paths newly excluded are printed with -.
+
+With a tree instead of a commit history, the filters depending on the commit, such as a filter schedule, are rejected.
+` + "\n" + cmd.PatternDescription
+
+func newCmd() *Cmd {
+	c := &Cmd{
+		Command: &cobra.Command{
+			Use:   "diff-git-filter",
+			Short: "show what changes between two filters on a tree or a commit history",
+			Long:  longDescription,
+			Args:  cobra.NoArgs,
+		},
+	}
+
+	c.Run = c.run
+
+	c.SetupFilterCobra(c.Command, false)
+	c.Flags().StringVarP(&c.dir, "dir", "i", c.dir, "input directory containing original git repo")
+	c.MarkFlagRequired("dir")
+	c.MarkFlagDirname("dir")
+
+	c.Flags().StringVar(&c.oldPatternFile, "old-pattern-file", c.oldPatternFile, "a .gitignore like file for the patterns of the old filter")
+	c.MarkFlagFilename("old-pattern-file")
+	c.Flags().StringVar(&c.oldFilter, "old-filter", c.oldFilter, "the old filter serialized by permgit.MarshalFilter")
+	c.Flags().StringVar(&c.oldFilterRepo, "old-filter-repo", c.oldFilterRepo, "a filtered repo with the old filter recorded by filter-git-hist with record-filter")
+	c.MarkFlagDirname("old-filter-repo")
+	c.MarkFlagsOneRequired("old-pattern-file", "old-filter", "old-filter-repo")
+	c.MarkFlagsMutuallyExclusive("old-pattern-file", "old-filter", "old-filter-repo")
+
+	c.Flags().StringVarP(&c.tree, "tree", "t", c.tree, "compare the filters on the tree instead of the commit history, rejecting filters depending on the commit")
+	c.Flags().IntVarP(&c.NumCommit, "num-commit", "n", c.NumCommit, "number of commits to seek back")
+	c.Flags().StringVarP(&c.EndCommit, "end-commit", "e", c.EndCommit, "commit hash (default to head)")
+	c.Flags().StringVarP(&c.StartCommit, "start-commit", "s", c.StartCommit, "commit hash to start from, default to empty, and history will seek to root unless restricted by number of commit")
+	c.MarkFlagsMutuallyExclusive("tree", "num-commit")
+	c.MarkFlagsMutuallyExclusive("tree", "end-commit")
+	c.MarkFlagsMutuallyExclusive("tree", "start-commit")
+
+	c.Flags().StringVarP(&c.outfilename, "output", "o", c.outfilename, "output file name, use - or leave empty for stdout")
+	c.MarkFlagFilename("output")
+
+	c.Flags().IntVar(&c.LogLevel, "log-level", c.LogLevel, "log level passing to slog.")
+
+	return c
+}
+
+func (c *Cmd) getOldFilter() permgit.Filter {
+	switch {
+	case c.oldPatternFile != "":
+		content := cmd.GetOrPanic(os.ReadFile(c.oldPatternFile))
+		patterns := cmd.GetOrPanic(permgit.LoadPatternStringFromString(string(content), c.IgnoreUnsupported))
+		return permgit.NewCachedFilter(cmd.GetOrPanic(permgit.NewPatternTrieFilterForPatternsWithOptions(patterns, c.GetPatternFilterOptions())))
+	case c.oldFilter != "":
+		return cmd.GetOrPanic(permgit.UnmarshalFilter(c.oldFilter))
+	default:
+		fs := cmd.NewFileSystem(c.oldFilterRepo, cache.NewObjectLRUDefault())
+		filter, text, err := permgit.LoadRecordedFilter(fs)
+		cmd.OrPanic(err)
+		cmd.Logger().Debug("old filter", "filter", text)
+		return filter
+	}
+}
+
+func printDiff(out io.Writer, diff *permgit.FilterDiff) {
+	for _, v := range diff.Included {
+		fmt.Fprintf(out, "+ %s\n", v)
+	}
+	for _, v := range diff.Excluded {
+		fmt.Fprintf(out, "- %s\n", v)
+	}
+}
+
+func (c *Cmd) run(*cobra.Command, []string) {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	c.InitLog()
+
+	fs := cmd.NewFileSystem(c.dir, cache.NewObjectLRUDefault())
+
+	oldfilter := c.getOldFilter()
+	newfilter := c.GetFilter()
+
+	var out io.WriteCloser
+	if c.outfilename == "" || c.outfilename == "-" {
+		out = os.Stdout
+	} else {
+		out = cmd.GetOrPanic(os.Create(c.outfilename))
+		defer out.Close()
+	}
+
+	if c.tree != "" {
+		tree := cmd.GetOrPanic(object.GetTree(fs, cmd.MustHash(c.tree)))
+		printDiff(out, cmd.GetOrPanic(permgit.DiffFilters(ctx, tree, oldfilter, newfilter)))
+		return
+	}
+
+	hist := c.GetHistory(ctx, fs)
+	diffs := cmd.GetOrPanic(permgit.DiffFiltersForCommits(ctx, hist, oldfilter, newfilter))
+	for _, v := range diffs {
+		fmt.Fprintf(out, "commit %s %s\n", v.Commit.Hash, strings.SplitN(v.Commit.Message, "\n", 2)[0])
+		printDiff(out, &v.FilterDiff)
+	}
+
+	cmd.Logger().Info("filtered trees changed", "commits", len(diffs), "total", len(hist))
+}
@@ -35,6 +35,8 @@ type Cmd struct {
 
 	outfilename string
 
+	checkCollisions bool
+
 	cmd.LogCmd
 }
 
@@ -64,6 +66,8 @@ func newCmd() *Cmd {
 	c.Flags().StringVarP(&c.outfilename, "output", "o", c.outfilename, "output file name, use - or leave empty for stdout")
 	c.MarkFlagFilename("output")
 
+	c.Flags().BoolVar(&c.checkCollisions, "check-collisions", c.checkCollisions, "report the paths colliding under case folding or unicode normalization, as selected by ignore-case and normalize-unicode or both if neither is set, and exit with 1 if any is found")
+
 	c.Flags().IntVar(&c.LogLevel, "log-level", c.LogLevel, "log level passing to slog.")
 
 	return c
@@ -103,7 +107,7 @@ func (c *Cmd) run(*cobra.Command, []string) {
 
 	tree := cmd.GetOrPanic(object.GetTree(fs, hash))
 
-	filter := c.GetFilter()
+	filter := cmd.GetOrPanic(permgit.FilterForTree(ctx, c.GetFilter(), tree))
 
 	var out io.WriteCloser
 	if c.outfilename == "" || c.outfilename == "-" {
@@ -114,4 +118,19 @@ func (c *Cmd) run(*cobra.Command, []string) {
 	}
 
 	permgit.DumpTree(ctx, nil, tree, filter, out)
+
+	if c.checkCollisions {
+		// check the normalizations selected for the patterns, or both if none is selected.
+		var opts *permgit.PatternFilterOptions
+		if c.IgnoreCase || c.NormalizeUnicode {
+			opts = c.GetPatternFilterOptions()
+		}
+		collisions := cmd.GetOrPanic(permgit.FindPathCollisions(ctx, tree, opts))
+		for _, v := range collisions {
+			fmt.Fprintln(os.Stderr, v.String())
+		}
+		if len(collisions) > 0 {
+			os.Exit(1)
+		}
+	}
 }
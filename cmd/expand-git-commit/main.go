@@ -2,21 +2,29 @@
 //
 // The target commit, once filtered down by input filters, should generate exact same tree as the input commit's parent.
 // The generated commit is deterministic, and each run, as long as the parameters stay the same, will be exactly the same.
+// By default the author, committer, and message are copied from the input commit, the committer and message can be overridden,
+// and the generated commit is no longer deterministic if the committer timestamp is set to now.
 //
 // The process will panic if any of the files in change set is filtered out by the input filters.
 //
 // The input/output directory are .git repositories.
 //
 // The generated commit can be set to a branch as defined by the branch name, and can also be optionally set as the head of the repo.
+//
+// With dry run, nothing is written into the output directory, and the differences between the target commit and the would-be commit
+// are printed to stdout, either as a unified diff or as a summary of added/modified/deleted/renamed paths.
 package main
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/spf13/cobra"
 
 	"github.com/fardream/permgit"
@@ -31,6 +39,7 @@ type Cmd struct {
 	*cobra.Command
 
 	cmd.FilterCmd
+	cmd.ExpandCmd
 	inputdir  string
 	outputdir string
 
@@ -39,6 +48,9 @@ type Cmd struct {
 
 	cmd.SetBranchCmd
 
+	dryrun     bool
+	diffformat string
+
 	cmd.LogCmd
 }
 
@@ -46,12 +58,17 @@ const longDescription = `expand-git-commit adds back the changes made in a repo
 
 The target commit, once filtered down by input filters, should generate exact same tree as the input commit's parent.
 The generated commit is deterministic, and each run, as long as the parameters stay the same, will be exactly the same.
+By default the author, committer, and message are copied from the input commit, the committer and message can be overridden,
+and the generated commit is no longer deterministic if the committer timestamp is set to now.
 
 The process will panic if any of the files in change set is filtered out by the input filters.
 
 The input/output directory are .git repositories.
 
 The generated commit can be set to a branch as defined by the branch name, and can also be optionally set as the head of the repo.
+
+With dry run, nothing is written into the output directory, and the differences between the target commit and the would-be commit
+are printed to stdout, either as a unified diff or as a summary of added/modified/deleted/renamed paths.
 ` + "\n" + cmd.PatternDescription
 
 func newCmd() *Cmd {
@@ -65,6 +82,7 @@ func newCmd() *Cmd {
 	}
 
 	c.SetupFilterCobra(c.Command, true)
+	c.SetupExpandCobra(c.Command)
 	c.Flags().StringVarP(&c.inputdir, "input-dir", "i", c.inputdir, "input directory containing filtered git repo")
 	c.MarkFlagRequired("input-dir")
 	c.MarkFlagDirname("input-dir")
@@ -79,6 +97,9 @@ func newCmd() *Cmd {
 	c.Flags().StringVar(&c.Branch, "branch", c.Branch, "branch to set the head to")
 	c.Flags().BoolVar(&c.SetHead, "set-head", c.SetHead, "set the generated commit history as the head")
 
+	c.Flags().BoolVar(&c.dryrun, "dry-run", c.dryrun, "do not write into output directory, and print the differences the generated commit will introduce")
+	c.Flags().StringVar(&c.diffformat, "diff-format", "unified", "format of the differences printed in dry run, unified or summary")
+
 	c.Flags().IntVar(&c.LogLevel, "log-level", c.LogLevel, "log level passing to slog.")
 
 	c.Run = c.run
@@ -104,17 +125,55 @@ func (c *Cmd) run(*cobra.Command, []string) {
 
 	filter := c.GetFilter()
 
-	newcommit := cmd.GetOrPanic(permgit.ExpandCommit(
+	var targetstorer storer.Storer = outputfs
+	if c.dryrun {
+		targetstorer = permgit.NewOverlayStorer(outputfs)
+	}
+
+	newcommit := cmd.GetOrPanic(permgit.ExpandCommitWithOptions(
 		ctx,
 		inputfs,
 		inputparent,
 		inputcommit,
 		targetcommit,
-		outputfs,
+		targetstorer,
 		filter,
+		c.GetExpandOptions(),
 	))
 
 	cmd.Logger().Debug("newcommit", "hash", newcommit.Hash)
 
+	if c.dryrun {
+		c.printDiff(ctx, targetcommit, cmd.GetOrPanic(object.GetTree(targetstorer, newcommit.TreeHash)))
+		return
+	}
+
 	c.SetBrancHead(outputfs, newcommit.Hash)
 }
+
+// printDiff prints the differences between the target commit and the new tree.
+func (c *Cmd) printDiff(ctx context.Context, targetcommit *object.Commit, newtree *object.Tree) {
+	targettree := cmd.GetOrPanic(targetcommit.Tree())
+
+	switch c.diffformat {
+	case "unified":
+		patch := cmd.GetOrPanic(targettree.PatchContext(ctx, newtree))
+		cmd.OrPanic(patch.Encode(os.Stdout))
+	case "summary":
+		changes := cmd.GetOrPanic(object.DiffTreeWithOptions(ctx, targettree, newtree, object.DefaultDiffTreeOptions))
+		for _, change := range changes {
+			switch {
+			case change.From.Name == "":
+				fmt.Printf("A\t%s\n", change.To.Name)
+			case change.To.Name == "":
+				fmt.Printf("D\t%s\n", change.From.Name)
+			case change.From.Name != change.To.Name:
+				fmt.Printf("R\t%s\t%s\n", change.From.Name, change.To.Name)
+			default:
+				fmt.Printf("M\t%s\n", change.To.Name)
+			}
+		}
+	default:
+		cmd.OrPanic(fmt.Errorf("unknown diff format: %s", c.diffformat))
+	}
+}
@@ -0,0 +1,323 @@
+// expand-git-seq expands a sequence of commits in a repo filtered by filter-git-hist onto the unfiltered repo, similar to a rebase.
+//
+// The sequence stops at the first commit that cannot be expanded. The progress is saved in the git directory of the output repo,
+// the conflict can be fixed manually in a worktree and committed on top of the current head, and the sequence continued with
+// the continue subcommand. Alternatively, the conflicting commit can be dropped with skip, or the whole sequence dropped with abort.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/spf13/cobra"
+
+	"github.com/fardream/permgit"
+	"github.com/fardream/permgit/cmd"
+)
+
+func main() {
+	newCmd().Execute()
+}
+
+type Cmd struct {
+	*cobra.Command
+
+	cmd.FilterCmd
+	cmd.ExpandCmd
+	inputdir  string
+	outputdir string
+
+	cmd.SetBranchCmd
+
+	cmd.LogCmd
+}
+
+const longDescription = `expand-git-seq expands a sequence of commits in a repo filtered by filter-git-hist onto the unfiltered repo, similar to a rebase.
+
+The sequence stops at the first commit that cannot be expanded. The progress is saved in the git directory of the output repo,
+the conflict can be fixed manually in a worktree and committed on top of the current head, and the sequence continued with
+the continue subcommand. Alternatively, the conflicting commit can be dropped with skip, or the whole sequence dropped with abort.
+
+The same input/output directories and filters must be provided to all the subcommands.
+
+The branch, if provided, is updated to the head of the sequence after each subcommand.
+` + "\n" + cmd.PatternDescription
+
+func newCmd() *Cmd {
+	c := &Cmd{
+		Command: &cobra.Command{
+			Use:   "expand-git-seq",
+			Short: "add changes in a sequence of filtered commits back to unfiltered repo.",
+			Long:  longDescription,
+			Args:  cobra.NoArgs,
+		},
+	}
+
+	c.AddCommand(
+		newStartCmd(c).Command,
+		newContinueCmd(c).Command,
+		newSkipCmd(c).Command,
+		newAbortCmd(c).Command,
+		newStatusCmd(c).Command,
+	)
+
+	return c
+}
+
+// setupCommonFlags adds the flags shared by all the subcommands.
+func (c *Cmd) setupCommonFlags(sub *cobra.Command, withfilter bool) {
+	if withfilter {
+		c.SetupFilterCobra(sub, true)
+		c.SetupExpandCobra(sub)
+	}
+	sub.Flags().StringVarP(&c.inputdir, "input-dir", "i", c.inputdir, "input directory containing filtered git repo")
+	sub.MarkFlagRequired("input-dir")
+	sub.MarkFlagDirname("input-dir")
+	sub.Flags().StringVarP(&c.outputdir, "output-dir", "o", c.outputdir, "output directory, containing the unfiltered repo.")
+	sub.MarkFlagRequired("output-dir")
+	sub.MarkFlagDirname("output-dir")
+
+	sub.Flags().StringVar(&c.Branch, "branch", c.Branch, "branch to set the head to")
+	sub.Flags().BoolVar(&c.SetHead, "set-head", c.SetHead, "set the generated commit as the head")
+
+	sub.Flags().IntVar(&c.LogLevel, "log-level", c.LogLevel, "log level passing to slog.")
+}
+
+// setup initializes the log and returns the input and output storage.
+func (c *Cmd) setup() (*filesystem.Storage, *filesystem.Storage) {
+	c.InitLog()
+
+	chc := cache.NewObjectLRUDefault()
+
+	return cmd.NewFileSystem(c.inputdir, chc), cmd.NewFileSystem(c.outputdir, chc)
+}
+
+func (c *Cmd) newSequencer(inputfs *filesystem.Storage, outputfs *filesystem.Storage, filter permgit.Filter) *permgit.ExpandSequencer {
+	return permgit.NewExpandSequencer(outputfs.Filesystem(), inputfs, outputfs, filter, c.GetExpandOptions())
+}
+
+// handleResult sets the branch to the head of the sequence, and reports the conflict if there is one.
+func (c *Cmd) handleResult(outputfs *filesystem.Storage, head plumbing.Hash, err error) {
+	var conflict *permgit.ExpandConflictError
+	if errors.As(err, &conflict) {
+		c.SetBrancHead(outputfs, head)
+		fmt.Fprintf(os.Stderr, "%s\n\nfix the conflict by committing on top of %s, then run continue, skip, or abort.\n", conflict.Error(), head)
+		os.Exit(1)
+	}
+	cmd.OrPanic(err)
+
+	cmd.Logger().Info("expand sequence finished", "head", head)
+	c.SetBrancHead(outputfs, head)
+}
+
+type startCmd struct {
+	*cobra.Command
+	parent *Cmd
+
+	cmd.HistCmd
+	targetCommit string
+}
+
+func newStartCmd(parent *Cmd) *startCmd {
+	c := &startCmd{
+		Command: &cobra.Command{
+			Use:   "start",
+			Short: "start expanding a sequence of filtered commits onto the target commit.",
+			Args:  cobra.NoArgs,
+		},
+		parent: parent,
+	}
+
+	parent.setupCommonFlags(c.Command, true)
+	c.Flags().IntVarP(&c.NumCommit, "num-commit", "n", c.NumCommit, "number of commits to seek back in the filtered repo")
+	c.Flags().StringVarP(&c.EndCommit, "end-commit", "e", c.EndCommit, "last commit in the filtered repo to expand (default to head)")
+	c.Flags().StringVarP(&c.StartCommit, "start-commit", "s", c.StartCommit, "first commit in the filtered repo to expand")
+	c.Flags().StringVarP(&c.targetCommit, "target-commit", "t", c.targetCommit, "target commit, changes will be applied to this commit.")
+	c.MarkFlagRequired("target-commit")
+
+	c.Run = c.run
+
+	return c
+}
+
+func (c *startCmd) run(*cobra.Command, []string) {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	inputfs, outputfs := c.parent.setup()
+
+	hist := c.GetHistory(ctx, inputfs)
+	targetcommit := cmd.GetOrPanic(object.GetCommit(outputfs, cmd.MustHash(c.targetCommit)))
+
+	seq := c.parent.newSequencer(inputfs, outputfs, c.parent.GetFilter())
+
+	head, err := seq.Start(ctx, hist, targetcommit)
+	c.parent.handleResult(outputfs, head, err)
+}
+
+type continueCmd struct {
+	*cobra.Command
+	parent *Cmd
+
+	resolvedCommit string
+}
+
+func newContinueCmd(parent *Cmd) *continueCmd {
+	c := &continueCmd{
+		Command: &cobra.Command{
+			Use:   "continue",
+			Short: "continue the sequence after the conflict is fixed.",
+			Long:  "continue the sequence after the conflict is fixed.\nThe fix is the resolved commit, or the commit the branch points to if resolved commit is not provided.",
+			Args:  cobra.NoArgs,
+		},
+		parent: parent,
+	}
+
+	parent.setupCommonFlags(c.Command, true)
+	c.Flags().StringVarP(&c.resolvedCommit, "resolved-commit", "r", c.resolvedCommit, "commit containing the fix for the conflict, its parent must be the current head of the sequence")
+
+	c.Run = c.run
+
+	return c
+}
+
+func (c *continueCmd) run(*cobra.Command, []string) {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	inputfs, outputfs := c.parent.setup()
+
+	seq := c.parent.newSequencer(inputfs, outputfs, c.parent.GetFilter())
+	state := cmd.GetOrPanic(seq.State())
+
+	var resolved *object.Commit
+	switch {
+	case c.resolvedCommit != "":
+		resolved = cmd.GetOrPanic(object.GetCommit(outputfs, cmd.MustHash(c.resolvedCommit)))
+	case state.Conflict != nil && c.parent.Branch != "":
+		ref := cmd.GetOrPanic(outputfs.Reference(plumbing.NewBranchReferenceName(c.parent.Branch)))
+		resolved = cmd.GetOrPanic(object.GetCommit(outputfs, ref.Hash()))
+	}
+
+	head, err := seq.Continue(ctx, resolved)
+	c.parent.handleResult(outputfs, head, err)
+}
+
+type skipCmd struct {
+	*cobra.Command
+	parent *Cmd
+}
+
+func newSkipCmd(parent *Cmd) *skipCmd {
+	c := &skipCmd{
+		Command: &cobra.Command{
+			Use:   "skip",
+			Short: "skip the conflicting commit and continue the sequence.",
+			Args:  cobra.NoArgs,
+		},
+		parent: parent,
+	}
+
+	parent.setupCommonFlags(c.Command, true)
+
+	c.Run = c.run
+
+	return c
+}
+
+func (c *skipCmd) run(*cobra.Command, []string) {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	inputfs, outputfs := c.parent.setup()
+
+	seq := c.parent.newSequencer(inputfs, outputfs, c.parent.GetFilter())
+	head, err := seq.Skip(ctx)
+	c.parent.handleResult(outputfs, head, err)
+}
+
+type abortCmd struct {
+	*cobra.Command
+	parent *Cmd
+}
+
+func newAbortCmd(parent *Cmd) *abortCmd {
+	c := &abortCmd{
+		Command: &cobra.Command{
+			Use:   "abort",
+			Short: "abort the sequence, and reset the branch to the commit the sequence started from.",
+			Args:  cobra.NoArgs,
+		},
+		parent: parent,
+	}
+
+	parent.setupCommonFlags(c.Command, false)
+
+	c.Run = c.run
+
+	return c
+}
+
+func (c *abortCmd) run(*cobra.Command, []string) {
+	inputfs, outputfs := c.parent.setup()
+
+	seq := c.parent.newSequencer(inputfs, outputfs, nil)
+
+	onto := cmd.GetOrPanic(seq.Abort())
+
+	c.parent.SetBrancHead(outputfs, onto)
+}
+
+type statusCmd struct {
+	*cobra.Command
+	parent *Cmd
+}
+
+func newStatusCmd(parent *Cmd) *statusCmd {
+	c := &statusCmd{
+		Command: &cobra.Command{
+			Use:   "status",
+			Short: "print the state of the sequence.",
+			Args:  cobra.NoArgs,
+		},
+		parent: parent,
+	}
+
+	parent.setupCommonFlags(c.Command, false)
+
+	c.Run = c.run
+
+	return c
+}
+
+func (c *statusCmd) run(*cobra.Command, []string) {
+	inputfs, outputfs := c.parent.setup()
+
+	seq := c.parent.newSequencer(inputfs, outputfs, nil)
+	state := cmd.GetOrPanic(seq.State())
+
+	fmt.Printf("onto:    %s\n", state.Onto)
+	fmt.Printf("head:    %s\n", state.Head)
+	for _, v := range state.Done {
+		switch {
+		case v.Skipped:
+			fmt.Printf("skipped: %s\n", v.Filtered)
+		default:
+			fmt.Printf("done:    %s -> %s\n", v.Filtered, v.Generated)
+		}
+	}
+	if state.Conflict != nil {
+		fmt.Printf("conflict: %s\n    %s\n", state.Conflict.Filtered, state.Conflict.Error)
+	}
+	for _, v := range state.Pending {
+		fmt.Printf("pending: %s\n", v)
+	}
+}
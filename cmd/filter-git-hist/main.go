@@ -2,12 +2,25 @@
 //
 // The generated history is deterministic, and each run, as long as the parameters stay the same, will be exactly the same.
 //
-// The input commit history must be linear, there must not be submodules (they will be silently ignored), and
-// GPG signature will also be dropped. The output blobs/trees/commits will be written to a different/output directory.
+// The input commit history must be linear, submodules are silently ignored unless kept by keep-submodules, and
+// GPG signature will also be dropped, and the generated commits are re-signed if sign-key is set. The output blobs/trees/commits will be written to a different/output directory.
 // Input/output are directly read/written from the .git folder of git repositories. For output, an empty .git is sufficient.
 //
 // The generated commit history can be set to a branch as defined by branch name parameter, and can also be optionally
 // set as the head of the repo.
+//
+// With scan-secrets, the files included by the filter are scanned for secrets, and if any is found, a report of
+// the commits, paths and rules is printed and the branch is not set.
+//
+// With max-blob-size, the files larger than the limit are dropped, fail the filtering, or converted to git lfs pointers
+// with the objects written to lfs-dir, depending on size-policy.
+//
+// With rules-file, each commit is filtered by the pattern file at the path in its own tree, such as .permgit,
+// and the patterns are only used for the commits without it. The rules file itself is included or excluded by rules-file-policy.
+//
+// With record-filter, the serialized filter is written as a blob into the output repo and refs/permgit/filter points at it,
+// followed by the options changing the output, such as the size limit, and the sha256 of the transform rules, message rules, and mailmap,
+// so the published history can be reproduced with the same filter, options, and files. The sign key is not recorded.
 package main
 
 import (
@@ -36,23 +49,42 @@ type Cmd struct {
 	inputdir  string
 	outputdir string
 	overwrite bool
+
+	recordFilter bool
+
 	cmd.HistCmd
 
 	cmd.SetBranchCmd
 	cmd.LogCmd
 	cmd.FilterCmd
+	cmd.FilterOptionsCmd
+	cmd.MailmapCmd
+	cmd.SignCmd
 }
 
 const longDescription = `filter-git-hist is a more robust but limited git-filter-branch.
 
 The generated history is deterministic, and each run, as long as the parameters stay the same, will be exactly the same.
 
-The input commit history must be linear, there must not be submodules (they will be silently ignored), and
-GPG signature will also be dropped. The output blobs/trees/commits will be written to a different/output directory.
+The input commit history must be linear, submodules are silently ignored unless kept by keep-submodules, and
+GPG signature will also be dropped, and the generated commits are re-signed if sign-key is set. The output blobs/trees/commits will be written to a different/output directory.
 Input/output are directly read/written from the .git folder of git repositories. For output, an empty .git is sufficient.
 
 The generated commit history can be set to a branch as defined by branch name parameter, and can also be optionally
 set as the head of the repo.
+
+With scan-secrets, the files included by the filter are scanned for secrets, and if any is found, a report of
+the commits, paths and rules is printed and the branch is not set.
+
+With max-blob-size, the files larger than the limit are dropped, fail the filtering, or converted to git lfs pointers
+with the objects written to lfs-dir, depending on size-policy.
+
+With rules-file, each commit is filtered by the pattern file at the path in its own tree, such as .permgit,
+and the patterns are only used for the commits without it. The rules file itself is included or excluded by rules-file-policy.
+
+With record-filter, the serialized filter is written as a blob into the output repo and refs/permgit/filter points at it,
+followed by the options changing the output, such as the size limit, and the sha256 of the transform rules, message rules, and mailmap,
+so the published history can be reproduced with the same filter, options, and files. The sign key is not recorded.
 ` + "\n" + cmd.PatternDescription
 
 func newCmd() *Cmd {
@@ -66,6 +98,9 @@ func newCmd() *Cmd {
 	}
 
 	c.SetupFilterCobra(c.Command, true)
+	c.SetupFilterOptionsCobra(c.Command)
+	c.SetupMailmapCobra(c.Command)
+	c.SetupSignCobra(c.Command)
 	c.Flags().StringVarP(&c.inputdir, "input-dir", "i", c.inputdir, "input directory containing original git repo")
 	c.MarkFlagRequired("input-dir")
 	c.MarkFlagDirname("input-dir")
@@ -80,6 +115,8 @@ func newCmd() *Cmd {
 	c.Flags().StringVar(&c.Branch, "branch", c.Branch, "branch to set the head to")
 	c.Flags().BoolVar(&c.SetHead, "set-head", c.SetHead, "set the generated commit history as the head")
 
+	c.Flags().BoolVar(&c.recordFilter, "record-filter", c.recordFilter, "record the serialized filter and the options changing the output in the output repo at refs/permgit/filter")
+
 	c.Flags().IntVar(&c.LogLevel, "log-level", c.LogLevel, "log level passing to slog.")
 
 	c.Run = c.run
@@ -115,9 +152,30 @@ func (c *Cmd) run(*cobra.Command, []string) {
 	hist := c.GetHistory(ctx, inputfs)
 
 	orfilter := c.GetFilter()
+	var recorded []permgit.RecordedOption
+	if c.recordFilter {
+		// fail before filtering if the filter cannot be recorded.
+		cmd.GetOrPanic(permgit.MarshalFilter(orfilter))
+		recorded = append(c.GetRecordedFilterOptions(), c.GetRecordedMailmapOptions()...)
+	}
 	outputfs := newOutputDir(c.outputdir, c.overwrite, chc)
 
-	newhist := cmd.GetOrPanic(permgit.FilterLinearHistory(ctx, hist, outputfs, orfilter))
+	opts := c.GetFilterOptions()
+	opts.Mailmap = c.GetMailmap()
+	opts.Signer = c.GetSigner()
+
+	newhist, err := permgit.FilterLinearHistoryWithOptions(ctx, hist, outputfs, orfilter, opts)
+	var secretsErr *permgit.SecretsFoundError
+	if errors.As(err, &secretsErr) {
+		fmt.Fprintln(os.Stderr, secretsErr.Error())
+		os.Exit(1)
+	}
+	cmd.OrPanic(err)
 
 	c.SetBrancHeadFromHistory(outputfs, newhist)
+
+	if c.recordFilter {
+		hash := cmd.GetOrPanic(permgit.RecordFilterWithOptions(outputfs, orfilter, recorded))
+		cmd.Logger().Info("recorded filter", "ref", permgit.FilterRefName, "blob", hash)
+	}
 }
@@ -3,15 +3,21 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/storage/filesystem"
@@ -166,6 +172,14 @@ type FilterCmd struct {
 	Patterns          []string
 	PatternFile       string
 	IgnoreUnsupported bool
+	ExcludeModes      []string
+	ExcludeAttrs      []string
+	IncludeAttrs      []string
+	FilterSchedule    string
+	RulesFile         string
+	RulesFilePolicy   string
+	IgnoreCase        bool
+	NormalizeUnicode  bool
 
 	IsRequired bool
 }
@@ -175,12 +189,26 @@ func (c *FilterCmd) SetupFilterCobra(cmd *cobra.Command, required bool) {
 	cmd.Flags().StringVar(&c.PatternFile, "pattern-file", c.PatternFile, "a .gitignore like file for patterns")
 	cmd.MarkFlagFilename("pattern-file")
 	cmd.Flags().BoolVar(&c.IgnoreUnsupported, "allow-unsupported-pattern", c.IgnoreUnsupported, "allow the parser to ignore patterns supported like !")
+	cmd.Flags().BoolVar(&c.IgnoreCase, "ignore-case", c.IgnoreCase, "match the patterns case-insensitively")
+	cmd.Flags().BoolVar(&c.NormalizeUnicode, "normalize-unicode", c.NormalizeUnicode, "match the patterns after converting the patterns and paths to unicode NFC")
+	cmd.Flags().StringSliceVar(&c.ExcludeModes, "exclude-mode", c.ExcludeModes, "exclude the files with the modes, any of regular, executable, symlink, and submodule")
+	cmd.Flags().StringArrayVar(&c.ExcludeAttrs, "exclude-attr", c.ExcludeAttrs, "exclude the paths with the git attribute in the .gitattributes of each commit, as attr, -attr, or attr=value, for example export-ignore")
+	cmd.Flags().StringArrayVar(&c.IncludeAttrs, "include-attr", c.IncludeAttrs, "include the paths with the git attribute in the .gitattributes of each commit, as attr, -attr, or attr=value, for example publish=true")
+	cmd.Flags().StringVar(&c.FilterSchedule, "filter-schedule", c.FilterSchedule, "a json file of the filters used from commits or dates, the patterns are the initial filter, see permgit.FilterScheduleEntryConfig")
+	cmd.MarkFlagFilename("filter-schedule")
+	cmd.Flags().StringVar(&c.RulesFile, "rules-file", c.RulesFile, "filter each commit by the pattern file at the path in its own tree, for example .permgit, the patterns are used for the commits without it")
+	cmd.Flags().StringVar(&c.RulesFilePolicy, "rules-file-policy", string(permgit.RulesFilePolicy_Filter), "whether the rules file itself is included, one of filter, include, or exclude")
 	if required {
-		cmd.MarkFlagsOneRequired("pattern-file", "pattern")
+		cmd.MarkFlagsOneRequired("pattern-file", "pattern", "rules-file")
 		c.IsRequired = true
 	}
 }
 
+// GetPatternFilterOptions returns the options to compile the patterns with.
+func (c *FilterCmd) GetPatternFilterOptions() *permgit.PatternFilterOptions {
+	return &permgit.PatternFilterOptions{CaseInsensitive: c.IgnoreCase, NormalizeUnicode: c.NormalizeUnicode}
+}
+
 func (c *FilterCmd) GetFilter() permgit.Filter {
 	filelines := c.Patterns[:]
 	if c.PatternFile != "" {
@@ -188,11 +216,390 @@ func (c *FilterCmd) GetFilter() permgit.Filter {
 		filelines = append(filelines, GetOrPanic(permgit.LoadPatternStringFromString(string(content), c.IgnoreUnsupported))...)
 	}
 
-	if !c.IsRequired && len(filelines) == 0 {
-		return permgit.NewTrueFilter()
+	var filter permgit.Filter
+	if !c.IsRequired && c.RulesFile == "" && len(filelines) == 0 {
+		filter = permgit.NewTrueFilter()
+	} else {
+		filter = permgit.NewCachedFilter(GetOrPanic(permgit.NewPatternTrieFilterForPatternsWithOptions(filelines, c.GetPatternFilterOptions())))
+	}
+
+	if c.RulesFile != "" {
+		filter = GetOrPanic(permgit.NewRulesFileFilter(c.RulesFile, filter, permgit.RulesFilePolicy(c.RulesFilePolicy), c.IgnoreUnsupported, c.GetPatternFilterOptions()))
+	}
+
+	if c.FilterSchedule != "" {
+		content := GetOrPanic(os.ReadFile(c.FilterSchedule))
+		filter = GetOrPanic(permgit.LoadFilterSchedule(content, filter, c.GetPatternFilterOptions()))
+	}
+
+	if len(c.ExcludeModes) > 0 {
+		modes := make([]filemode.FileMode, 0, len(c.ExcludeModes))
+		for _, m := range c.ExcludeModes {
+			modes = append(modes, GetOrPanic(permgit.ParseFileMode(m)))
+		}
+		filter = permgit.NewAndEntryFilter(filter, permgit.NewModeFilter(modes...))
+	}
+
+	if len(c.ExcludeAttrs) == 0 && len(c.IncludeAttrs) == 0 {
+		return filter
+	}
+
+	// attribute rules are checked in order, exclusion takes precedence.
+	rules := make([]permgit.GitAttributeRule, 0, len(c.ExcludeAttrs)+len(c.IncludeAttrs))
+	for _, v := range c.ExcludeAttrs {
+		rules = append(rules, GetOrPanic(permgit.ParseGitAttributeRule(v, false)))
+	}
+	for _, v := range c.IncludeAttrs {
+		rules = append(rules, GetOrPanic(permgit.ParseGitAttributeRule(v, true)))
+	}
+
+	return permgit.NewGitAttributesFilter(filter, rules...)
+}
+
+// FilterOptionsCmd contains the options for filtering commits.
+type FilterOptionsCmd struct {
+	KeepSubmodules  bool
+	SubmoduleURLMap map[string]string
+	TransformRules  string
+
+	ScanSecrets     bool
+	SecretRules     string
+	SecretAllowlist string
+
+	MessageRules  string
+	SymlinkPolicy string
+
+	SizeLimitCmd
+}
+
+func (c *FilterOptionsCmd) SetupFilterOptionsCobra(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&c.KeepSubmodules, "keep-submodules", c.KeepSubmodules, "keep the submodules included by the filter, and the matching sections of .gitmodules")
+	cmd.Flags().StringToStringVar(&c.SubmoduleURLMap, "submodule-url", c.SubmoduleURLMap, "rewrite the url of kept submodules in .gitmodules, in the format of original-url=new-url")
+	cmd.Flags().StringVar(&c.TransformRules, "transform-rules", c.TransformRules, "a json file of rules to transform the content of files, see permgit.BlobTransformRuleConfig")
+	cmd.MarkFlagFilename("transform-rules", "json")
+	cmd.Flags().BoolVar(&c.ScanSecrets, "scan-secrets", c.ScanSecrets, "scan the files included by the filter for secrets with the default rules, and fail if any is found")
+	cmd.Flags().StringVar(&c.SecretRules, "secret-rules", c.SecretRules, "a json file of additional secret rules, see permgit.SecretRuleConfig, implies scan-secrets")
+	cmd.MarkFlagFilename("secret-rules", "json")
+	cmd.Flags().StringVar(&c.SecretAllowlist, "secret-allowlist", c.SecretAllowlist, "an allowlist file for false positives of the secret scanner, see permgit.LoadSecretAllowlist")
+	cmd.MarkFlagFilename("secret-allowlist")
+	cmd.Flags().StringVar(&c.MessageRules, "message-rules", c.MessageRules, "a json file of rules to rewrite the commit messages, see permgit.MessageRulesConfig")
+	cmd.MarkFlagFilename("message-rules", "json")
+	cmd.Flags().StringVar(&c.SymlinkPolicy, "symlink-policy", c.SymlinkPolicy, "validate the symlinks escaping the repo or pointing at excluded paths, one of warn, drop, or fail")
+	c.SetupSizeLimitCobra(cmd)
+}
+
+// getSecretScanner creates the secret scanner, nil is returned if scanning is not enabled.
+func (c *FilterOptionsCmd) getSecretScanner() *permgit.SecretScanner {
+	if !c.ScanSecrets && c.SecretRules == "" {
+		if c.SecretAllowlist != "" {
+			OrPanic(fmt.Errorf("secret allowlist requires scan secrets"))
+		}
+		return nil
+	}
+
+	rules := permgit.DefaultSecretRules()
+	if c.SecretRules != "" {
+		rules = append(rules, GetOrPanic(permgit.LoadSecretRules(GetOrPanic(os.ReadFile(c.SecretRules))))...)
+	}
+
+	var allowlist *permgit.SecretAllowlist
+	if c.SecretAllowlist != "" {
+		allowlist = GetOrPanic(permgit.LoadSecretAllowlist(string(GetOrPanic(os.ReadFile(c.SecretAllowlist)))))
+	}
+
+	return permgit.NewSecretScanner(rules, allowlist)
+}
+
+// getMessageRewriter loads the message rules, nil is returned if the file name is empty.
+func (c *FilterOptionsCmd) getMessageRewriter() *permgit.MessageRewriter {
+	if c.MessageRules == "" {
+		return nil
+	}
+
+	return GetOrPanic(permgit.LoadMessageRewriter(GetOrPanic(os.ReadFile(c.MessageRules))))
+}
+
+// loadBlobTransformers loads the transform rules from the json file, nil is returned if the file name is empty.
+func loadBlobTransformers(filename string) *permgit.BlobTransformers {
+	if filename == "" {
+		return nil
+	}
+
+	return GetOrPanic(permgit.LoadBlobTransformers(GetOrPanic(os.ReadFile(filename))))
+}
+
+func (c *FilterOptionsCmd) GetFilterOptions() *permgit.FilterOptions {
+	if len(c.SubmoduleURLMap) > 0 && !c.KeepSubmodules {
+		OrPanic(fmt.Errorf("submodule url requires keep submodules"))
+	}
+	switch permgit.SymlinkPolicy(c.SymlinkPolicy) {
+	case "", permgit.SymlinkPolicy_Warn, permgit.SymlinkPolicy_Drop, permgit.SymlinkPolicy_Fail:
+	default:
+		OrPanic(fmt.Errorf("unknown symlink policy: %s", c.SymlinkPolicy))
+	}
+
+	return &permgit.FilterOptions{
+		KeepSubmodules:   c.KeepSubmodules,
+		SubmoduleURLMap:  c.SubmoduleURLMap,
+		BlobTransformers: loadBlobTransformers(c.TransformRules),
+		SecretScanner:    c.getSecretScanner(),
+		MessageRewriter:  c.getMessageRewriter(),
+		BlobSizeLimit:    c.GetBlobSizeLimit(),
+		SymlinkPolicy:    permgit.SymlinkPolicy(c.SymlinkPolicy),
+	}
+}
+
+// fileSHA256 returns the sha256 of the content of the file, prefixed by sha256:.
+func fileSHA256(filename string) string {
+	sum := sha256.Sum256(GetOrPanic(os.ReadFile(filename)))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// GetRecordedFilterOptions returns the options changing the filtered repo to be recorded next to the filter,
+// the rules files are recorded by their content hashes. The secret scanner doesn't change the filtered repo, and is not recorded.
+func (c *FilterOptionsCmd) GetRecordedFilterOptions() []permgit.RecordedOption {
+	var r []permgit.RecordedOption
+	if c.KeepSubmodules {
+		r = append(r, permgit.RecordedOption{Name: "keep-submodules", Value: "true"})
+	}
+	urls := make([]string, 0, len(c.SubmoduleURLMap))
+	for from := range c.SubmoduleURLMap {
+		urls = append(urls, from)
+	}
+	slices.Sort(urls)
+	for _, from := range urls {
+		r = append(r, permgit.RecordedOption{Name: "submodule-url", Value: from + "=" + c.SubmoduleURLMap[from]})
+	}
+	if c.TransformRules != "" {
+		r = append(r, permgit.RecordedOption{Name: "transform-rules", Value: fileSHA256(c.TransformRules)})
+	}
+	if c.MessageRules != "" {
+		r = append(r, permgit.RecordedOption{Name: "message-rules", Value: fileSHA256(c.MessageRules)})
+	}
+	if c.SymlinkPolicy != "" {
+		r = append(r, permgit.RecordedOption{Name: "symlink-policy", Value: c.SymlinkPolicy})
+	}
+	if c.MaxBlobSize != "" {
+		r = append(r,
+			permgit.RecordedOption{Name: "max-blob-size", Value: c.MaxBlobSize},
+			permgit.RecordedOption{Name: "size-policy", Value: c.SizePolicy},
+		)
+	}
+
+	return r
+}
+
+// SizeLimitCmd contains the options for the files larger than the size limit.
+type SizeLimitCmd struct {
+	MaxBlobSize string
+	SizePolicy  string
+	LFSDir      string
+}
+
+func (c *SizeLimitCmd) SetupSizeLimitCobra(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&c.MaxBlobSize, "max-blob-size", c.MaxBlobSize, "size limit of the files, in bytes or with a K, M, or G suffix, for example 100M")
+	cmd.Flags().StringVar(&c.SizePolicy, "size-policy", string(permgit.SizePolicy_Fail), "policy for the files larger than max-blob-size, one of drop, fail, or lfs")
+	cmd.Flags().StringVar(&c.LFSDir, "lfs-dir", c.LFSDir, "local git lfs object store for the lfs size policy, normally the .git/lfs directory of the filtered repo")
+	cmd.MarkFlagDirname("lfs-dir")
+}
+
+// parseSize parses the size in bytes, with an optional K, M, or G suffix for the powers of 1024.
+func parseSize(size string) (int64, error) {
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(size, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(size, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(size, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		size = size[:len(size)-1]
+	}
+
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %s: %w", size, err)
+	}
+
+	return n * multiplier, nil
+}
+
+// GetBlobSizeLimit creates the size limit, nil is returned if max blob size is not set.
+func (c *SizeLimitCmd) GetBlobSizeLimit() *permgit.BlobSizeLimit {
+	if c.MaxBlobSize == "" {
+		if c.LFSDir != "" {
+			OrPanic(fmt.Errorf("lfs dir requires max blob size"))
+		}
+		return nil
+	}
+
+	policy := permgit.SizePolicy(c.SizePolicy)
+	var store *permgit.LFSStore
+	if policy == permgit.SizePolicy_LFS {
+		if c.LFSDir == "" {
+			OrPanic(fmt.Errorf("lfs dir is required for size policy %s", policy))
+		}
+		absdir := GetOrPanic(filepath.Abs(c.LFSDir))
+		store = permgit.NewLFSStore(osfs.New(absdir))
+	}
+
+	return GetOrPanic(permgit.NewBlobSizeLimit(GetOrPanic(parseSize(c.MaxBlobSize)), policy, store))
+}
+
+// MailmapCmd contains the options for mapping the identities of authors and committers.
+type MailmapCmd struct {
+	MailmapFile   string
+	Anonymize     bool
+	AnonymizeSalt string
+}
+
+func (c *MailmapCmd) SetupMailmapCobra(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&c.MailmapFile, "mailmap", c.MailmapFile, "a .mailmap file to map the authors and committers")
+	cmd.MarkFlagFilename("mailmap")
+	cmd.Flags().BoolVar(&c.Anonymize, "anonymize", c.Anonymize, "replace the authors and committers not in the mailmap with deterministic anonymous identities")
+	cmd.Flags().StringVar(&c.AnonymizeSalt, "anonymize-salt", c.AnonymizeSalt, "salt mixed into the anonymous identities")
+}
+
+// GetMailmap loads the mailmap, nil is returned if neither mailmap nor anonymize is set.
+func (c *MailmapCmd) GetMailmap() *permgit.Mailmap {
+	if c.MailmapFile == "" && !c.Anonymize {
+		return nil
+	}
+
+	m := permgit.NewMailmap()
+	if c.MailmapFile != "" {
+		m = GetOrPanic(permgit.ParseMailmap(string(GetOrPanic(os.ReadFile(c.MailmapFile)))))
+	}
+	m.Anonymize = c.Anonymize
+	m.AnonymizeSalt = c.AnonymizeSalt
+
+	return m
+}
+
+// GetRecordedMailmapOptions returns the mailmap options to be recorded next to the filter.
+// The mailmap is recorded by its content hash, and the salt is hashed too so the anonymous identities cannot be reversed from it.
+func (c *MailmapCmd) GetRecordedMailmapOptions() []permgit.RecordedOption {
+	var r []permgit.RecordedOption
+	if c.MailmapFile != "" {
+		r = append(r, permgit.RecordedOption{Name: "mailmap", Value: fileSHA256(c.MailmapFile)})
+	}
+	if c.Anonymize {
+		r = append(r, permgit.RecordedOption{Name: "anonymize", Value: "true"})
+		if c.AnonymizeSalt != "" {
+			sum := sha256.Sum256([]byte(c.AnonymizeSalt))
+			r = append(r, permgit.RecordedOption{Name: "anonymize-salt", Value: "sha256:" + hex.EncodeToString(sum[:])})
+		}
+	}
+
+	return r
+}
+
+// SignKeyPassphraseEnv is the environment variable for the passphrase of the signing key.
+const SignKeyPassphraseEnv = "PERMGIT_SIGN_KEY_PASSPHRASE"
+
+// SignCmd contains the options for signing the generated commits.
+type SignCmd struct {
+	SignKey string
+}
+
+func (c *SignCmd) SetupSignCobra(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&c.SignKey, "sign-key", c.SignKey, "openpgp or ssh private key file to sign the generated commits, the passphrase is read from "+SignKeyPassphraseEnv)
+	cmd.MarkFlagFilename("sign-key")
+}
+
+// GetSigner loads the signing key, nil is returned if sign key is not set.
+func (c *SignCmd) GetSigner() permgit.Signer {
+	if c.SignKey == "" {
+		return nil
+	}
+
+	return GetOrPanic(permgit.LoadSigner(GetOrPanic(os.ReadFile(c.SignKey)), []byte(os.Getenv(SignKeyPassphraseEnv))))
+}
+
+// ExpandCmd contains the options for expanding filtered commits.
+type ExpandCmd struct {
+	RenameScore    uint
+	DetectCopies   bool
+	KeepSubmodules bool
+	TransformRules string
+	MailmapFile    string
+
+	CommitterName      string
+	CommitterEmail     string
+	CommitterTimestamp string
+	CommitterTime      string
+
+	MessageTemplate string
+	Trailers        []string
+
+	SignCmd
+	SizeLimitCmd
+}
+
+func (c *ExpandCmd) SetupExpandCobra(cmd *cobra.Command) {
+	cmd.Flags().UintVar(&c.RenameScore, "rename-score", permgit.DefaultRenameScore, "similarity threshold (0-100) to detect renames in the filtered commit, 0 disables rename detection")
+	cmd.Flags().BoolVar(&c.DetectCopies, "detect-copies", c.DetectCopies, "detect files copied from other files in the filtered commit")
+	cmd.Flags().BoolVar(&c.KeepSubmodules, "keep-submodules", c.KeepSubmodules, "the filtered repo is generated with keep-submodules, merge changes to .gitmodules into the target")
+	cmd.Flags().StringVar(&c.TransformRules, "transform-rules", c.TransformRules, "the json file of transform rules used to generate the filtered repo, changes to transformed files are refused")
+	cmd.MarkFlagFilename("transform-rules", "json")
+
+	cmd.Flags().StringVar(&c.MailmapFile, "mailmap", c.MailmapFile, "the .mailmap file used to generate the filtered repo, the identities are mapped back by its inverse")
+	cmd.MarkFlagFilename("mailmap")
+
+	cmd.Flags().StringVar(&c.CommitterName, "committer-name", c.CommitterName, "override the committer name of the generated commit")
+	cmd.Flags().StringVar(&c.CommitterEmail, "committer-email", c.CommitterEmail, "override the committer email of the generated commit")
+	cmd.MarkFlagsRequiredTogether("committer-name", "committer-email")
+	cmd.Flags().StringVar(&c.CommitterTimestamp, "committer-timestamp", string(permgit.TimestampPolicy_Keep), "committer timestamp of the generated commit, one of keep, now, or fixed")
+	cmd.Flags().StringVar(&c.CommitterTime, "committer-time", c.CommitterTime, "committer time in RFC3339 format when committer timestamp is fixed")
+	c.SetupSignCobra(cmd)
+	c.SetupSizeLimitCobra(cmd)
+
+	cmd.Flags().StringVar(&c.MessageTemplate, "message-template", c.MessageTemplate, "go text/template for the message of the generated commit, for example '{{.Message}}', see permgit.ExpandMessageData for the fields")
+	cmd.Flags().StringArrayVar(&c.Trailers, "trailer", c.Trailers, "trailers added to the message of the generated commit, also a go text/template, for example 'Upstream-Commit: {{.FilteredCommit}}'")
+}
+
+func (c *ExpandCmd) GetExpandOptions() *permgit.ExpandOptions {
+	if c.RenameScore > 100 {
+		OrPanic(fmt.Errorf("rename score %d is larger than 100", c.RenameScore))
+	}
+
+	opts := &permgit.ExpandOptions{
+		RenameScore:        c.RenameScore,
+		DetectCopies:       c.DetectCopies,
+		KeepSubmodules:     c.KeepSubmodules,
+		BlobTransformers:   loadBlobTransformers(c.TransformRules),
+		CommitterTimestamp: permgit.TimestampPolicy(c.CommitterTimestamp),
+		MessageTemplate:    c.MessageTemplate,
+		Trailers:           c.Trailers,
+		Signer:             c.GetSigner(),
+		BlobSizeLimit:      c.GetBlobSizeLimit(),
+	}
+
+	if c.MailmapFile != "" {
+		opts.Mailmap = GetOrPanic(permgit.ParseMailmap(string(GetOrPanic(os.ReadFile(c.MailmapFile))))).Inverse()
+	}
+
+	if c.CommitterName != "" || c.CommitterEmail != "" {
+		opts.Committer = &object.Signature{
+			Name:  c.CommitterName,
+			Email: c.CommitterEmail,
+		}
+	}
+
+	switch opts.CommitterTimestamp {
+	case "", permgit.TimestampPolicy_Keep, permgit.TimestampPolicy_Now:
+	case permgit.TimestampPolicy_Fixed:
+		if c.CommitterTime == "" {
+			OrPanic(fmt.Errorf("committer time is required for fixed committer timestamp"))
+		}
+		opts.CommitterTime = GetOrPanic(time.Parse(time.RFC3339, c.CommitterTime))
+	default:
+		OrPanic(fmt.Errorf("unknown committer timestamp: %s", c.CommitterTimestamp))
 	}
 
-	return GetOrPanic(permgit.NewOrFilterForPatterns(filelines...))
+	return opts
 }
 
 const PatternDescription = `supported patterns for filtering:
@@ -3,6 +3,9 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
 	"os/signal"
 	"syscall"
 
@@ -25,14 +28,29 @@ type Cmd struct {
 
 	cmd.SetBranchCmd
 	cmd.LogCmd
+	cmd.MailmapCmd
+	cmd.SignCmd
+
+	keyring          string
+	abortOn          []string
+	signatureTrailer string
+	rewriteFrom      string
 }
 
+const longDescription = `remove-git-gpg remove gpg signature from series of commit, and optionally re-sign them with sign-key.
+
+With keyring, the signatures of the commits are verified against the public keys in the keyring before they are stripped,
+and the status of each commit, one of valid, invalid, unknown-key, or unsigned, is printed to stdout.
+The rewriting aborts with exit code 1 if the status of a commit is in abort-on,
+and the status can be recorded in the recreated commits as a trailer.
+`
+
 func newCmd() *Cmd {
 	c := &Cmd{
 		Command: &cobra.Command{
 			Use:   "remove-git-gpg",
 			Short: "remove gpg signature from series of commit.",
-			Long:  "remove gpg signature from series of commit.",
+			Long:  longDescription,
 			Args:  cobra.NoArgs,
 		},
 	}
@@ -47,11 +65,20 @@ func newCmd() *Cmd {
 	c.Flags().StringVarP(&c.EndCommit, "end-commit", "e", c.EndCommit, "commit hash (default to head)")
 	c.Flags().StringVarP(&c.StartCommit, "start-commit", "s", c.StartCommit, "commit hash to start from, default to empty, and history will seek to root unless restricted by number of commit")
 
+	c.Flags().StringVar(&c.rewriteFrom, "rewrite-from", c.rewriteFrom, "commit hash in the history to start rewriting from, the commits before it are kept untouched")
 	c.Flags().StringVar(&c.Branch, "branch", c.Branch, "branch to set the head to")
 	c.Flags().BoolVar(&c.SetHead, "set-head", c.SetHead, "set the generated commit history as the head")
 
 	c.Flags().IntVar(&c.LogLevel, "log-level", c.LogLevel, "log level passing to slog.")
 
+	c.SetupMailmapCobra(c.Command)
+	c.SetupSignCobra(c.Command)
+
+	c.Flags().StringVar(&c.keyring, "keyring", c.keyring, "openpgp public keys, armored or binary, to verify the signatures before they are stripped")
+	c.MarkFlagFilename("keyring")
+	c.Flags().StringSliceVar(&c.abortOn, "abort-on", c.abortOn, "signature statuses that abort the rewriting, for example invalid,unknown-key")
+	c.Flags().StringVar(&c.signatureTrailer, "signature-trailer", c.signatureTrailer, "key of the trailer recording the status of the original signature, for example Original-Signature")
+
 	return c
 }
 
@@ -67,7 +94,40 @@ func (c *Cmd) run(*cobra.Command, []string) {
 
 	hist := c.GetHistory(ctx, inputfs)
 
-	newhist := cmd.GetOrPanic(permgit.RemoveGPGForLinearHistory(ctx, hist, inputfs))
+	opts := &permgit.RemoveGPGOptions{
+		Mailmap:          c.GetMailmap(),
+		Signer:           c.GetSigner(),
+		SignatureTrailer: c.signatureTrailer,
+	}
+	if c.rewriteFrom != "" {
+		opts.RewriteFrom = cmd.MustHash(c.rewriteFrom)
+	}
+	if c.keyring != "" {
+		opts.Verifier = permgit.NewSignatureVerifier(cmd.GetOrPanic(permgit.LoadOpenPGPKeyring(cmd.GetOrPanic(os.ReadFile(c.keyring)))))
+	} else if len(c.abortOn) > 0 || c.signatureTrailer != "" {
+		cmd.OrPanic(fmt.Errorf("keyring is required for abort-on and signature-trailer"))
+	}
+	for _, status := range c.abortOn {
+		switch s := permgit.SignatureStatus(status); s {
+		case permgit.SignatureStatus_Unsigned, permgit.SignatureStatus_Valid, permgit.SignatureStatus_Invalid, permgit.SignatureStatus_UnknownKey:
+			opts.AbortOn = append(opts.AbortOn, s)
+		default:
+			cmd.OrPanic(fmt.Errorf("unknown signature status: %s", status))
+		}
+	}
+
+	newhist, err := permgit.RemoveGPGForLinearHistoryWithOptions(ctx, hist, inputfs, opts)
+	if opts.Verifier != nil {
+		for _, v := range opts.Verifier.Results() {
+			fmt.Println(v.String())
+		}
+	}
+	var unverifiedErr *permgit.UnverifiedSignatureError
+	if errors.As(err, &unverifiedErr) {
+		fmt.Fprintln(os.Stderr, unverifiedErr.Error())
+		os.Exit(1)
+	}
+	cmd.OrPanic(err)
 
 	c.SetBrancHeadFromHistory(inputfs, newhist)
 }
@@ -0,0 +1,56 @@
+package permgit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// CommitFilter is a [Filter] that depends on the commit being filtered, for example on the files in the tree of the commit.
+// [FilterCommitWithOptions] filters the tree of the commit with the filter returned by FilterForCommit,
+// and [ExpandCommitWithOptions] uses the filter for the target commit.
+//
+// Filter is still called when the commit is not known.
+type CommitFilter interface {
+	Filter
+	FilterForCommit(ctx context.Context, c *object.Commit) (Filter, error)
+}
+
+// filterForCommit returns the filter for the commit if the filter is a [CommitFilter], or the filter itself otherwise.
+func filterForCommit(ctx context.Context, filter Filter, c *object.Commit) (Filter, error) {
+	cf, ok := filter.(CommitFilter)
+	if !ok {
+		return filter, nil
+	}
+
+	f, err := cf.FilterForCommit(ctx, c)
+	if err != nil {
+		return nil, errorf(err, "failed to obtain filter for commit %s: %w", c.Hash, err)
+	}
+
+	return f, nil
+}
+
+// TreeFilter is a [CommitFilter] that only depends on the tree of the commit, so it can be resolved for a tree without the commit.
+type TreeFilter interface {
+	CommitFilter
+	FilterForTree(ctx context.Context, t *object.Tree) (Filter, error)
+}
+
+// FilterForTree returns the filter for the tree if the filter is a [TreeFilter], or the filter itself if it is not a [CommitFilter].
+// This is used when filtering a tree directly, and it fails for the [CommitFilter] depending on the commit, such as [FilterSchedule].
+func FilterForTree(ctx context.Context, filter Filter, t *object.Tree) (Filter, error) {
+	switch f := filter.(type) {
+	case TreeFilter:
+		r, err := f.FilterForTree(ctx, t)
+		if err != nil {
+			return nil, errorf(err, "failed to obtain filter for tree %s: %w", t.Hash, err)
+		}
+		return r, nil
+	case CommitFilter:
+		return nil, fmt.Errorf("filter %T depends on the commit and cannot be used for a tree", filter)
+	default:
+		return filter, nil
+	}
+}
@@ -12,12 +12,19 @@ import (
 // CopyTree copies the given tree into the [storer.Storer].
 // If the tree already exists in s, function returns nil error right away.
 func CopyTree(ctx context.Context, t *object.Tree, s storer.Storer) error {
-	if s.HasEncodedObject(t.Hash) == nil {
+	return copyTree(ctx, t, nil, s, nil)
+}
+
+// copyTree copies the tree at prepath into the [storer.Storer], and the blobs in it are scanned by the scanner, which can be nil.
+// The tree is still walked for the scanner if it already exists, since it may be left by a previous run failed on the secrets.
+func copyTree(ctx context.Context, t *object.Tree, prepath []string, s storer.Storer, scanner *SecretScanner) error {
+	exists := s.HasEncodedObject(t.Hash) == nil
+	if exists && scanner == nil {
 		logger.Debug("tree exists, not copying", "hash", t.Hash)
 		return nil
 	}
 
-	logger.Debug("copy tree", "hash", t.Hash)
+	logger.Debug("copy tree", "hash", t.Hash, "exists", exists)
 	for _, e := range t.Entries {
 		select {
 		case <-ctx.Done():
@@ -27,19 +34,22 @@ func CopyTree(ctx context.Context, t *object.Tree, s storer.Storer) error {
 
 		switch e.Mode {
 		case filemode.Deprecated, filemode.Executable, filemode.Regular, filemode.Symlink:
-			if s.HasEncodedObject(e.Hash) == nil {
-				continue
-			}
 			file, err := t.TreeEntryFile(&e)
 			if err != nil {
 				return fmt.Errorf("failed to obtain file %s: %w", e.Hash, err)
 			}
 
-			if err := updateHashAndSave(ctx, file, s); err != nil {
-				return errorf(err, "failed to write %s %s into new repo: %w", e.Mode.String(), file.Hash, err)
+			if s.HasEncodedObject(e.Hash) != nil {
+				if err := updateHashAndSave(ctx, file, s); err != nil {
+					return errorf(err, "failed to write %s %s into new repo: %w", e.Mode.String(), file.Hash, err)
+				}
+			}
+			if err := scanner.scan(addpath(prepath, e.Name), file.Hash, fileContent(file)); err != nil {
+				return err
 			}
 		case filemode.Submodule:
-			logger.Warn("ignoring submodule", "path", e.Name)
+			// the commit of the submodule is not in this repo, the entry is kept as is.
+			logger.Debug("not copying submodule", "path", e.Name, "commit", e.Hash)
 		case filemode.Empty:
 			continue
 		case filemode.Dir:
@@ -48,12 +58,16 @@ func CopyTree(ctx context.Context, t *object.Tree, s storer.Storer) error {
 				return fmt.Errorf("failed to find sub tree %s %s: %w", e.Name, e.Hash, err)
 			}
 
-			if err := CopyTree(ctx, dir, s); err != nil {
+			if err := copyTree(ctx, dir, addpath(prepath, e.Name), s, scanner); err != nil {
 				return errorf(err, "failed to copy sub tree %s %s: %w", e.Name, e.Hash, err)
 			}
 		}
 	}
 
+	if exists {
+		return nil
+	}
+
 	newtree := object.Tree{
 		Hash:    t.Hash,
 		Entries: t.Entries,
@@ -65,3 +79,11 @@ func CopyTree(ctx context.Context, t *object.Tree, s storer.Storer) error {
 
 	return nil
 }
+
+// fileContent returns a function reading the content of the file.
+func fileContent(file *object.File) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		content, err := file.Contents()
+		return []byte(content), err
+	}
+}
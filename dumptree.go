@@ -10,7 +10,17 @@ import (
 )
 
 // DumpTree writes the file entries in this tree and its sub trees to an [io.Writer].
+// If the filter is an [EntryFilter], the entries are filtered by their modes and hashes as well.
 func DumpTree(ctx context.Context, prepath []string, tree *object.Tree, filter Filter, output io.Writer) error {
+	return walkTree(ctx, prepath, tree, filter, func(fullpath []string, _ *object.TreeEntry) error {
+		fmt.Fprintln(output, pathsToFullPath(fullpath))
+		return nil
+	})
+}
+
+// walkTree calls fn on the file entries in this tree and its sub trees included by the filter.
+func walkTree(ctx context.Context, prepath []string, tree *object.Tree, filter Filter, fn func(fullpath []string, e *object.TreeEntry) error) error {
+	entryfilter := AsEntryFilter(filter)
 	for _, v := range tree.Entries {
 		select {
 		case <-ctx.Done():
@@ -21,7 +31,7 @@ func DumpTree(ctx context.Context, prepath []string, tree *object.Tree, filter F
 		fullpathstring := pathsToFullPath(fullpath)
 		switch v.Mode {
 		case filemode.Dir:
-			if filter.Filter(fullpath, true) == FilterResult_Out {
+			if entryfilter.FilterEntry(fullpath, &v) == FilterResult_Out {
 				continue
 			}
 			subtree, err := tree.Tree(v.Name)
@@ -29,16 +39,18 @@ func DumpTree(ctx context.Context, prepath []string, tree *object.Tree, filter F
 				return fmt.Errorf("failed to obtain tree %s: %w", fullpathstring, err)
 			}
 
-			err = DumpTree(ctx, fullpath, subtree, filter, output)
+			err = walkTree(ctx, fullpath, subtree, filter, fn)
 			if err != nil {
 				return errorf(err, "failed to dump tree %s: %w", fullpathstring, err)
 			}
 
 		default:
-			if filter.Filter(fullpath, false) == FilterResult_Out {
+			if entryfilter.FilterEntry(fullpath, &v) == FilterResult_Out {
 				continue
 			}
-			fmt.Fprintln(output, fullpathstring)
+			if err := fn(fullpath, &v); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -0,0 +1,257 @@
+package permgit
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// EntryFilter is a [Filter] that can also filter the entries of the tree by their mode and hash in addition to the path.
+// [FilterTreeWithOptions], [DumpTree], and [ExpandTreeWithOptions] use FilterEntry when the filter implements it.
+//
+// Filter is still called when only the path is known, and should return the result for the most permissive entry at the path.
+type EntryFilter interface {
+	Filter
+	FilterEntry(paths []string, entry *object.TreeEntry) FilterResult
+}
+
+// pathEntryFilter adapts a [Filter] into an [EntryFilter] by ignoring everything in the entry except if it is a directory.
+type pathEntryFilter struct {
+	filter Filter
+}
+
+func (f pathEntryFilter) Filter(paths []string, isdir bool) FilterResult {
+	return f.filter.Filter(paths, isdir)
+}
+
+func (f pathEntryFilter) FilterEntry(paths []string, entry *object.TreeEntry) FilterResult {
+	return f.filter.Filter(paths, entry.Mode == filemode.Dir)
+}
+
+// AsEntryFilter returns the filter itself if it is an [EntryFilter], or an adapter filtering the entries by their paths.
+func AsEntryFilter(f Filter) EntryFilter {
+	if ef, ok := f.(EntryFilter); ok {
+		return ef
+	}
+
+	return pathEntryFilter{filter: f}
+}
+
+// EntryFilterFunc is an [EntryFilter] implemented by a function, for example to filter the entries by their hashes.
+// Without the entry, directories are dived into and files are included.
+type EntryFilterFunc func(paths []string, entry *object.TreeEntry) FilterResult
+
+var _ EntryFilter = (EntryFilterFunc)(nil)
+
+func (f EntryFilterFunc) Filter(paths []string, isdir bool) FilterResult {
+	if isdir {
+		return FilterResult_DirDive
+	}
+
+	return FilterResult_In
+}
+
+func (f EntryFilterFunc) FilterEntry(paths []string, entry *object.TreeEntry) FilterResult {
+	return f(paths, entry)
+}
+
+// ModeFilter excludes the entries with the file modes, such as symlinks or executables.
+// Directories not excluded are dived into, so the entries in them are checked.
+type ModeFilter struct {
+	excluded []filemode.FileMode
+}
+
+var _ EntryFilter = (*ModeFilter)(nil)
+
+// NewModeFilter creates a new [ModeFilter] excluding the modes.
+func NewModeFilter(excluded ...filemode.FileMode) *ModeFilter {
+	return &ModeFilter{
+		excluded: excluded,
+	}
+}
+
+// ParseFileMode parses the name of the file mode of files, one of regular, executable, symlink, or submodule.
+func ParseFileMode(name string) (filemode.FileMode, error) {
+	switch strings.ToLower(name) {
+	case "regular", "file":
+		return filemode.Regular, nil
+	case "executable":
+		return filemode.Executable, nil
+	case "symlink":
+		return filemode.Symlink, nil
+	case "submodule", "gitlink":
+		return filemode.Submodule, nil
+	default:
+		return filemode.Empty, fmt.Errorf("unknown file mode: %s", name)
+	}
+}
+
+func (f *ModeFilter) Filter(paths []string, isdir bool) FilterResult {
+	if isdir {
+		return FilterResult_DirDive
+	}
+
+	return FilterResult_In
+}
+
+func (f *ModeFilter) FilterEntry(paths []string, entry *object.TreeEntry) FilterResult {
+	mode := entry.Mode
+	if mode == filemode.Deprecated {
+		mode = filemode.Regular
+	}
+	if slices.Contains(f.excluded, mode) {
+		return FilterResult_Out
+	}
+
+	return f.Filter(paths, mode == filemode.Dir)
+}
+
+// AndEntryFilter combines multiple [EntryFilter] into one with an "and" operation, like [AndFilter].
+type AndEntryFilter struct {
+	filters []EntryFilter
+}
+
+var (
+	_ EntryFilter  = (*AndEntryFilter)(nil)
+	_ CommitFilter = (*AndEntryFilter)(nil)
+)
+
+// NewAndEntryFilter creates a new [AndEntryFilter], the filters are adapted by [AsEntryFilter].
+func NewAndEntryFilter(filters ...Filter) *AndEntryFilter {
+	f := &AndEntryFilter{
+		filters: make([]EntryFilter, 0, len(filters)),
+	}
+	for _, v := range filters {
+		f.filters = append(f.filters, AsEntryFilter(v))
+	}
+
+	return f
+}
+
+func (f *AndEntryFilter) Filter(paths []string, isdir bool) FilterResult {
+	return f.combine(func(ef EntryFilter) FilterResult { return ef.Filter(paths, isdir) })
+}
+
+func (f *AndEntryFilter) FilterEntry(paths []string, entry *object.TreeEntry) FilterResult {
+	return f.combine(func(ef EntryFilter) FilterResult { return ef.FilterEntry(paths, entry) })
+}
+
+// FilterForCommit combines the filters for the commit of the filters that are [CommitFilter].
+func (f *AndEntryFilter) FilterForCommit(ctx context.Context, c *object.Commit) (Filter, error) {
+	filters, err := resolveEntryFilters(f.filters, func(v Filter) (Filter, error) { return filterForCommit(ctx, v, c) })
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAndEntryFilter(filters...), nil
+}
+
+// FilterForTree combines the filters for the tree of the filters, see [FilterForTree].
+func (f *AndEntryFilter) FilterForTree(ctx context.Context, t *object.Tree) (Filter, error) {
+	filters, err := resolveEntryFilters(f.filters, func(v Filter) (Filter, error) { return FilterForTree(ctx, v, t) })
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAndEntryFilter(filters...), nil
+}
+
+func (f *AndEntryFilter) combine(filter func(EntryFilter) FilterResult) FilterResult {
+	if len(f.filters) == 0 {
+		return FilterResult_Out
+	}
+
+	in := FilterResult_In
+	for _, v := range f.filters {
+		in = min(in, filter(v))
+		if in == FilterResult_Out {
+			break
+		}
+	}
+
+	return in
+}
+
+// OrEntryFilter combines multiple [EntryFilter] into one with an "or" operation, like [OrFilter].
+type OrEntryFilter struct {
+	filters []EntryFilter
+}
+
+var (
+	_ EntryFilter  = (*OrEntryFilter)(nil)
+	_ CommitFilter = (*OrEntryFilter)(nil)
+)
+
+// NewOrEntryFilter creates a new [OrEntryFilter], the filters are adapted by [AsEntryFilter].
+func NewOrEntryFilter(filters ...Filter) *OrEntryFilter {
+	f := &OrEntryFilter{
+		filters: make([]EntryFilter, 0, len(filters)),
+	}
+	for _, v := range filters {
+		f.filters = append(f.filters, AsEntryFilter(v))
+	}
+
+	return f
+}
+
+func (f *OrEntryFilter) Filter(paths []string, isdir bool) FilterResult {
+	return f.combine(func(ef EntryFilter) FilterResult { return ef.Filter(paths, isdir) })
+}
+
+func (f *OrEntryFilter) FilterEntry(paths []string, entry *object.TreeEntry) FilterResult {
+	return f.combine(func(ef EntryFilter) FilterResult { return ef.FilterEntry(paths, entry) })
+}
+
+// FilterForCommit combines the filters for the commit of the filters that are [CommitFilter].
+func (f *OrEntryFilter) FilterForCommit(ctx context.Context, c *object.Commit) (Filter, error) {
+	filters, err := resolveEntryFilters(f.filters, func(v Filter) (Filter, error) { return filterForCommit(ctx, v, c) })
+	if err != nil {
+		return nil, err
+	}
+
+	return NewOrEntryFilter(filters...), nil
+}
+
+// FilterForTree combines the filters for the tree of the filters, see [FilterForTree].
+func (f *OrEntryFilter) FilterForTree(ctx context.Context, t *object.Tree) (Filter, error) {
+	filters, err := resolveEntryFilters(f.filters, func(v Filter) (Filter, error) { return FilterForTree(ctx, v, t) })
+	if err != nil {
+		return nil, err
+	}
+
+	return NewOrEntryFilter(filters...), nil
+}
+
+func (f *OrEntryFilter) combine(filter func(EntryFilter) FilterResult) FilterResult {
+	in := FilterResult_Out
+	for _, v := range f.filters {
+		in = max(in, filter(v))
+		if in == FilterResult_In {
+			break
+		}
+	}
+
+	return in
+}
+
+// resolveEntryFilters resolves the filters for a commit or a tree, see [CommitFilter] and [TreeFilter].
+func resolveEntryFilters(filters []EntryFilter, resolve func(Filter) (Filter, error)) ([]Filter, error) {
+	r := make([]Filter, 0, len(filters))
+	for _, v := range filters {
+		var filter Filter = v
+		if pe, ok := v.(pathEntryFilter); ok {
+			filter = pe.filter
+		}
+		f, err := resolve(filter)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, f)
+	}
+
+	return r, nil
+}
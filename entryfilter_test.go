@@ -0,0 +1,103 @@
+package permgit_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func TestModeFilter(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	editor := permgit.NewTreeEditor(s, nil)
+	for _, f := range []struct {
+		path    string
+		mode    filemode.FileMode
+		content string
+	}{
+		{"src/main.go", filemode.Regular, "package main\n"},
+		{"src/run.sh", filemode.Executable, "#!/bin/sh\n"},
+		{"src/link", filemode.Symlink, "../secret"},
+		{"secret", filemode.Regular, "secret\n"},
+	} {
+		if _, err := editor.Put(f.path, f.mode, []byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orig, err := editor.Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patterns, err := permgit.NewOrFilterForPatterns("src/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := permgit.NewAndEntryFilter(patterns, permgit.NewModeFilter(filemode.Symlink))
+
+	filtered, err := permgit.FilterTree(ctx, orig, nil, s, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err = object.GetTree(s, filtered.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestTree(t, filtered); len(got) != 2 || got["src/run.sh"] != "#!/bin/sh\n" {
+		t.Errorf("unexpected filtered tree: %v", got)
+	}
+
+	var dump strings.Builder
+	if err := permgit.DumpTree(ctx, nil, orig, filter, &dump); err != nil {
+		t.Fatal(err)
+	}
+	if want := "src/main.go\nsrc/run.sh\n"; dump.String() != want {
+		t.Errorf("want dump %q, got %q", want, dump.String())
+	}
+
+	// adding a symlink in the filtered repo is refused.
+	newEditor := permgit.NewTreeEditor(s, filtered)
+	if _, err := newEditor.Put("src/other", filemode.Symlink, []byte("../secret")); err != nil {
+		t.Fatal(err)
+	}
+	filteredNew, err := newEditor.Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = permgit.ExpandTree(ctx, s, filtered, filteredNew, orig, s, filter)
+	var patchErr *permgit.FilePatchError
+	if !errors.As(err, &patchErr) || patchErr.ToError != "src/other" {
+		t.Errorf("expecting file patch error for src/other, got %v", err)
+	}
+
+	// filter by hash.
+	mainentry, err := filtered.FindEntry("src/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byhash := permgit.EntryFilterFunc(func(paths []string, entry *object.TreeEntry) permgit.FilterResult {
+		if entry.Hash == mainentry.Hash {
+			return permgit.FilterResult_Out
+		}
+		return permgit.NewTrueFilter().Filter(paths, entry.Mode == filemode.Dir)
+	})
+	filtered, err = permgit.FilterTree(ctx, orig, nil, s, permgit.NewAndEntryFilter(filter, byhash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err = object.GetTree(s, filtered.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestTree(t, filtered); len(got) != 1 {
+		t.Errorf("unexpected filtered tree: %v", got)
+	}
+}
@@ -3,6 +3,9 @@ package permgit
 import (
 	"context"
 	"fmt"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
@@ -19,13 +22,48 @@ func ExpandCommit(
 	targetStorer storer.Storer,
 	filter Filter,
 ) (*object.Commit, error) {
+	return ExpandCommitWithOptions(ctx, sourceStorer, filteredOrig, filteredNew, target, targetStorer, filter, nil)
+}
+
+// ExpandCommitWithOptions is [ExpandCommit] with [ExpandOptions].
+// The author is copied from filteredNew and mapped by [ExpandOptions.Mailmap],
+// and the committer and message are copied unless overridden by opts.
+// If the filter is a [CommitFilter], the filter for the target is used.
+func ExpandCommitWithOptions(
+	ctx context.Context,
+	sourceStorer storer.Storer,
+	filteredOrig *object.Commit,
+	filteredNew *object.Commit,
+	target *object.Commit,
+	targetStorer storer.Storer,
+	filter Filter,
+	opts *ExpandOptions,
+) (*object.Commit, error) {
+	if opts == nil {
+		opts = &ExpandOptions{}
+	}
+
+	filter, err := filterForCommit(ctx, filter, target)
+	if err != nil {
+		return nil, err
+	}
+
+	committer, err := getExpandCommitter(filteredNew.Committer, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	newtarget := &object.Commit{
-		Committer:    filteredNew.Committer,
-		Author:       filteredNew.Author,
-		Message:      filteredNew.Message,
+		Committer:    committer,
+		Author:       opts.Mailmap.Map(filteredNew.Author),
 		ParentHashes: []plumbing.Hash{target.Hash},
 	}
 
+	newtarget.Message, err = getExpandMessage(filteredNew, target, newtarget, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	filteredOrigTree, err := filteredOrig.Tree()
 	if err != nil {
 		return nil, fmt.Errorf("failed to obtain filtered parent tree: %w", err)
@@ -39,7 +77,7 @@ func ExpandCommit(
 		return nil, fmt.Errorf("failed to obtain target parent tree: %w", err)
 	}
 
-	newtree, err := ExpandTree(ctx, sourceStorer, filteredOrigTree, filteredNewTree, targetOrigTree, targetStorer, filter)
+	newtree, err := ExpandTreeWithOptions(ctx, sourceStorer, filteredOrigTree, filteredNewTree, targetOrigTree, targetStorer, filter, opts)
 	if err != nil {
 		return nil, errorf(err, "failed to expand tree for target: %w", err)
 	}
@@ -49,6 +87,10 @@ func ExpandCommit(
 		logger.Warn("empty tree", "filtered-new-commit", filteredNew.Hash, "filtered-orig-commit", filteredOrig.Hash, "target", target.Hash)
 	}
 
+	if err := signCommit(newtarget, opts.Signer); err != nil {
+		return nil, fmt.Errorf("failed to sign new commit: %w", err)
+	}
+
 	err = updateHashAndSave(ctx, newtarget, targetStorer)
 	if err != nil {
 		return nil, errorf(err, "failed to update new tree into storage: %w", err)
@@ -56,3 +98,71 @@ func ExpandCommit(
 
 	return newtarget, nil
 }
+
+func getExpandCommitter(orig object.Signature, opts *ExpandOptions) (object.Signature, error) {
+	committer := opts.Mailmap.Map(orig)
+	if opts.Committer != nil {
+		committer.Name = opts.Committer.Name
+		committer.Email = opts.Committer.Email
+	}
+
+	switch opts.CommitterTimestamp {
+	case "", TimestampPolicy_Keep:
+	case TimestampPolicy_Now:
+		committer.When = time.Now()
+	case TimestampPolicy_Fixed:
+		committer.When = opts.CommitterTime
+	default:
+		return committer, fmt.Errorf("unknown timestamp policy: %s", opts.CommitterTimestamp)
+	}
+
+	return committer, nil
+}
+
+func getExpandMessage(filteredNew *object.Commit, target *object.Commit, newtarget *object.Commit, opts *ExpandOptions) (string, error) {
+	data := &ExpandMessageData{
+		Message:        filteredNew.Message,
+		FilteredCommit: filteredNew.Hash.String(),
+		TargetCommit:   target.Hash.String(),
+		Author:         newtarget.Author,
+		Committer:      newtarget.Committer,
+	}
+
+	msg := filteredNew.Message
+	if opts.MessageTemplate != "" {
+		var err error
+		msg, err = executeMessageTemplate(opts.MessageTemplate, data)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate message: %w", err)
+		}
+	}
+
+	if len(opts.Trailers) == 0 {
+		return msg, nil
+	}
+
+	trailers := make([]string, 0, len(opts.Trailers))
+	for _, v := range opts.Trailers {
+		trailer, err := executeMessageTemplate(v, data)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate trailer %s: %w", v, err)
+		}
+		trailers = append(trailers, trailer)
+	}
+
+	return appendTrailers(msg, trailers...), nil
+}
+
+func executeMessageTemplate(tmpl string, data *ExpandMessageData) (string, error) {
+	t, err := template.New("message").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,142 @@
+package permgit_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func TestExpandCommitWithOptions(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	filter, err := permgit.NewOrFilterForPatterns("pub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := newTestCommit(t, s, map[string]string{"pub/a": "a\n", "priv/x": "x\n"}, 0)
+	filteredOrig := newTestCommit(t, s, map[string]string{"pub/a": "a\n"}, 1)
+
+	author := object.Signature{Name: "author", Email: "author@example.com", When: time.Unix(1700000000, 0).UTC()}
+	committer := object.Signature{Name: "committer", Email: "committer@example.com", When: time.Unix(1700003600, 0).UTC()}
+	newFiltered := func(t *testing.T, msg string) *object.Commit {
+		t.Helper()
+
+		return saveTestCommit(t, s, &object.Commit{
+			Author:       author,
+			Committer:    committer,
+			Message:      msg,
+			TreeHash:     buildTestTree(t, s, map[string]string{"pub/a": "a\n", "pub/b": "b\n"}).Hash,
+			ParentHashes: []plumbing.Hash{filteredOrig.Hash},
+		})
+	}
+
+	expand := func(t *testing.T, filteredNew *object.Commit, opts *permgit.ExpandOptions) *object.Commit {
+		t.Helper()
+
+		c, err := permgit.ExpandCommitWithOptions(ctx, s, filteredOrig, filteredNew, target, s, filter, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	filteredNew := newFiltered(t, "add b\n\nbody\n")
+
+	t.Run("default", func(t *testing.T) {
+		c := expand(t, filteredNew, nil)
+		if c.Message != filteredNew.Message {
+			t.Errorf("want message %q, got %q", filteredNew.Message, c.Message)
+		}
+		if c.Author.String() != author.String() || !c.Author.When.Equal(author.When) {
+			t.Errorf("want author %s, got %s", author.String(), c.Author.String())
+		}
+		if c.Committer.String() != committer.String() || !c.Committer.When.Equal(committer.When) {
+			t.Errorf("want committer %s, got %s", committer.String(), c.Committer.String())
+		}
+
+		// the generated commit is deterministic, and the same as zero options.
+		if again := expand(t, filteredNew, &permgit.ExpandOptions{}); again.Hash != c.Hash {
+			t.Errorf("want the same commit %s, got %s", c.Hash, again.Hash)
+		}
+		withoutopts, err := permgit.ExpandCommit(ctx, s, filteredOrig, filteredNew, target, s, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if withoutopts.Hash != c.Hash {
+			t.Errorf("want the same commit %s, got %s", c.Hash, withoutopts.Hash)
+		}
+	})
+
+	t.Run("committer", func(t *testing.T) {
+		override := &object.Signature{Name: "bot", Email: "bot@example.com"}
+		fixed := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		c := expand(t, filteredNew, &permgit.ExpandOptions{Committer: override, CommitterTimestamp: permgit.TimestampPolicy_Keep})
+		if c.Committer.Name != override.Name || c.Committer.Email != override.Email || !c.Committer.When.Equal(committer.When) {
+			t.Errorf("unexpected committer for keep: %s", c.Committer.String())
+		}
+		if c.Author.Name != author.Name {
+			t.Errorf("author should not be overridden: %s", c.Author.String())
+		}
+
+		c = expand(t, filteredNew, &permgit.ExpandOptions{Committer: override, CommitterTimestamp: permgit.TimestampPolicy_Fixed, CommitterTime: fixed})
+		if c.Committer.Name != override.Name || !c.Committer.When.Equal(fixed) {
+			t.Errorf("unexpected committer for fixed: %s", c.Committer.String())
+		}
+
+		before := time.Now().Add(-time.Second)
+		c = expand(t, filteredNew, &permgit.ExpandOptions{CommitterTimestamp: permgit.TimestampPolicy_Now})
+		if c.Committer.Name != committer.Name || c.Committer.When.Before(before) || c.Committer.When.After(time.Now().Add(time.Second)) {
+			t.Errorf("unexpected committer for now: %s", c.Committer.String())
+		}
+
+		if _, err := permgit.ExpandCommitWithOptions(ctx, s, filteredOrig, filteredNew, target, s, filter, &permgit.ExpandOptions{CommitterTimestamp: "later"}); err == nil {
+			t.Errorf("unknown timestamp policy should fail")
+		}
+	})
+
+	t.Run("message", func(t *testing.T) {
+		c := expand(t, filteredNew, &permgit.ExpandOptions{MessageTemplate: "[public] {{.Message}}from {{.FilteredCommit}} onto {{.TargetCommit}} by {{.Author.Name}}\n"})
+		want := "[public] add b\n\nbody\nfrom " + filteredNew.Hash.String() + " onto " + target.Hash.String() + " by author\n"
+		if c.Message != want {
+			t.Errorf("want message %q, got %q", want, c.Message)
+		}
+
+		if _, err := permgit.ExpandCommitWithOptions(ctx, s, filteredOrig, filteredNew, target, s, filter, &permgit.ExpandOptions{MessageTemplate: "{{.Unknown}}"}); err == nil {
+			t.Errorf("invalid template should fail")
+		}
+	})
+
+	t.Run("trailers", func(t *testing.T) {
+		trailers := []string{"Upstream-Commit: {{.FilteredCommit}}", "Reviewed-by: reviewer <reviewer@example.com>"}
+		upstream := "Upstream-Commit: " + filteredNew.Hash.String() + "\nReviewed-by: reviewer <reviewer@example.com>\n"
+
+		c := expand(t, filteredNew, &permgit.ExpandOptions{Trailers: trailers})
+		if want := "add b\n\nbody\n\n" + upstream; c.Message != want {
+			t.Errorf("want message %q, got %q", want, c.Message)
+		}
+
+		signedoff := newFiltered(t, "add b\n\nSigned-off-by: author <author@example.com>\n")
+		c = expand(t, signedoff, &permgit.ExpandOptions{Trailers: trailers})
+		if want := "add b\n\nSigned-off-by: author <author@example.com>\n" + strings.Replace(upstream, filteredNew.Hash.String(), signedoff.Hash.String(), 1); c.Message != want {
+			t.Errorf("want message %q, got %q", want, c.Message)
+		}
+
+		// a single line message is not a trailer block.
+		subject := newFiltered(t, "Fixes: everything\n")
+		c = expand(t, subject, &permgit.ExpandOptions{Trailers: trailers[1:]})
+		if want := "Fixes: everything\n\nReviewed-by: reviewer <reviewer@example.com>\n"; c.Message != want {
+			t.Errorf("want message %q, got %q", want, c.Message)
+		}
+	})
+}
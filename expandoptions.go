@@ -0,0 +1,80 @@
+package permgit
+
+import (
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// DefaultRenameScore is the similarity threshold used by git to detect renames.
+const DefaultRenameScore = 50
+
+// ExpandOptions contains the options for [ExpandTreeWithOptions] and [ExpandCommitWithOptions].
+// The zero value keeps the default deterministic behavior.
+type ExpandOptions struct {
+	// RenameScore is the similarity threshold, between 0 and 100, for a pair of deleted and added files in the filtered trees
+	// to be considered a rename. A zero value disables rename detection, and a rename is treated as a deletion and an addition.
+	// Unlike the command line tools, which default to [DefaultRenameScore], the zero value keeps nil options the same as [ExpandTree].
+	//
+	// A renamed file is moved in the target tree, and if the content of the file in the target differs from the filtered original,
+	// the differences are carried over to the new location with a three-way merge.
+	RenameScore uint
+	// DetectCopies enables the detection of added files that are exact copies of files in the filtered original tree.
+	// The content of the copy is taken from the file in the target, so the target's content-level differences are carried along.
+	DetectCopies bool
+
+	// KeepSubmodules indicates the filtered repo is generated with [FilterOptions.KeepSubmodules], so changes to .gitmodules are accepted
+	// regardless of the filter, and merged with the .gitmodules in the target section by section to keep the submodules filtered out.
+	// Updates to the submodules included by the filter are always applied.
+	KeepSubmodules bool
+	// BlobTransformers are the transformers used to generate the filtered repo.
+	// Changes to the transformed files that differ in the target are refused with [TransformedFileError].
+	BlobTransformers *BlobTransformers
+	// BlobSizeLimit is the size limit used to generate the filtered repo. With [SizePolicy_LFS], the Git LFS pointer files in the filtered repo
+	// match the large files in the target, and changed pointers are restored to the content in the lfs store, or kept as is if not found.
+	BlobSizeLimit *BlobSizeLimit
+
+	// Mailmap maps the author and committer of the generated commit, normally the [Mailmap.Inverse] of the one used to generate the filtered repo.
+	// It is applied before Committer.
+	Mailmap *Mailmap
+
+	// Committer overrides the name and email of the committer of the generated commit, which by default is copied from the filtered commit.
+	// The timestamp is decided by CommitterTimestamp.
+	Committer *object.Signature
+	// CommitterTimestamp decides the timestamp of the committer of the generated commit.
+	// Empty value is the same as [TimestampPolicy_Keep].
+	CommitterTimestamp TimestampPolicy
+	// CommitterTime is the timestamp of the committer for [TimestampPolicy_Fixed].
+	CommitterTime time.Time
+
+	// MessageTemplate is a [text/template] to generate the message of the generated commit, executed with [ExpandMessageData].
+	// Empty value keeps the message of the filtered commit.
+	MessageTemplate string
+	// Trailers are appended to the end of the message of the generated commit, such as "Reviewed-by: Name <email>".
+	// Each of them is also a [text/template] executed with [ExpandMessageData], for example "Upstream-Commit: {{.FilteredCommit}}".
+	Trailers []string
+
+	// Signer signs the generated commit.
+	Signer Signer
+}
+
+// TimestampPolicy decides the timestamp used for a generated commit.
+type TimestampPolicy string
+
+const (
+	TimestampPolicy_Keep  TimestampPolicy = "keep"  // keep the timestamp of the input commit.
+	TimestampPolicy_Now   TimestampPolicy = "now"   // use the current time, and the generated commit is no longer deterministic.
+	TimestampPolicy_Fixed TimestampPolicy = "fixed" // use a fixed time.
+)
+
+// ExpandMessageData is the data used to execute [ExpandOptions.MessageTemplate] and [ExpandOptions.Trailers].
+type ExpandMessageData struct {
+	// Message is the message of the filtered commit.
+	Message string
+	// FilteredCommit is the hash of the filtered commit.
+	FilteredCommit string
+	// TargetCommit is the hash of the target commit, which is the parent of the generated commit.
+	TargetCommit string
+	Author       object.Signature
+	Committer    object.Signature
+}
@@ -0,0 +1,329 @@
+package permgit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// ExpandSequencerStateFile is the name of the file in the git directory of the target repo
+// where [ExpandSequencer] persists its progress.
+const ExpandSequencerStateFile = "permgit-expand-state.json"
+
+// ErrNoExpandSequence is returned when an operation requires an ongoing expand sequence but there is none.
+var ErrNoExpandSequence = errors.New("no expand sequence in progress")
+
+// ExpandSequencerStep records a filtered commit that has been processed by the sequencer.
+type ExpandSequencerStep struct {
+	// Filtered is the hash of the commit in the filtered repo.
+	Filtered string `json:"filtered"`
+	// Generated is the hash of the commit generated in the target repo, empty if the commit is skipped.
+	Generated string `json:"generated,omitempty"`
+	// Skipped indicates the filtered commit is skipped.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// ExpandSequencerConflict records the filtered commit that cannot be expanded onto the target.
+type ExpandSequencerConflict struct {
+	// Filtered is the hash of the commit in the filtered repo.
+	Filtered string `json:"filtered"`
+	// Error is the error returned by [ExpandCommit].
+	Error string `json:"error"`
+}
+
+// ExpandSequencerState is the persisted state of an [ExpandSequencer].
+// All hashes are hex strings.
+type ExpandSequencerState struct {
+	// Onto is the target commit the sequence started from.
+	Onto string `json:"onto"`
+	// Head is the latest commit in the target repo, new commits will be generated on top of it.
+	Head string `json:"head"`
+	// Pending are the filtered commits yet to be expanded, the first one is the next.
+	// If there is a conflict, the conflicting commit is not included.
+	Pending []string `json:"pending"`
+	// Done are the filtered commits already processed.
+	Done []ExpandSequencerStep `json:"done"`
+	// Conflict is the filtered commit that failed to expand, nil if there is no conflict.
+	Conflict *ExpandSequencerConflict `json:"conflict,omitempty"`
+}
+
+// ExpandConflictError is returned by [ExpandSequencer] when a filtered commit cannot be expanded onto the target.
+// The conflict must be resolved by [ExpandSequencer.Continue], [ExpandSequencer.Skip], or [ExpandSequencer.Abort].
+type ExpandConflictError struct {
+	Filtered plumbing.Hash
+	Head     plumbing.Hash
+	Err      error
+}
+
+func (e *ExpandConflictError) Error() string {
+	return fmt.Sprintf("failed to expand filtered commit %s onto %s: %s", e.Filtered, e.Head, e.Err.Error())
+}
+
+func (e *ExpandConflictError) Unwrap() error {
+	return e.Err
+}
+
+// ExpandSequencer expands a list of filtered commits one by one onto a target commit with [ExpandCommitWithOptions],
+// similar to a rebase.
+// The sequencer stops at the first commit that fails to expand, and its progress is persisted in
+// [ExpandSequencerStateFile] in the provided [billy.Filesystem], which normally is the git directory of the target repo.
+// Once the conflict is fixed manually, the sequence can be resumed by [ExpandSequencer.Continue],
+// or the conflicting commit can be dropped by [ExpandSequencer.Skip].
+// [ExpandSequencer.Abort] removes the persisted state.
+type ExpandSequencer struct {
+	fs           billy.Filesystem
+	sourceStorer storer.Storer
+	targetStorer storer.Storer
+	filter       Filter
+	opts         *ExpandOptions
+
+	state *ExpandSequencerState
+}
+
+// NewExpandSequencer creates a new [ExpandSequencer], the state is persisted in fs.
+// The filter and opts are passed to [ExpandCommitWithOptions].
+func NewExpandSequencer(fs billy.Filesystem, sourceStorer storer.Storer, targetStorer storer.Storer, filter Filter, opts *ExpandOptions) *ExpandSequencer {
+	return &ExpandSequencer{
+		fs:           fs,
+		sourceStorer: sourceStorer,
+		targetStorer: targetStorer,
+		filter:       filter,
+		opts:         opts,
+	}
+}
+
+// State loads the persisted state. It returns [ErrNoExpandSequence] if there is no sequence in progress.
+func (s *ExpandSequencer) State() (*ExpandSequencerState, error) {
+	if s.state != nil {
+		return s.state, nil
+	}
+
+	content, err := util.ReadFile(s.fs, ExpandSequencerStateFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNoExpandSequence
+		}
+		return nil, fmt.Errorf("failed to read expand state: %w", err)
+	}
+
+	state := &ExpandSequencerState{}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("failed to parse expand state: %w", err)
+	}
+
+	s.state = state
+
+	return state, nil
+}
+
+func (s *ExpandSequencer) saveState() error {
+	content, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode expand state: %w", err)
+	}
+
+	if err := util.WriteFile(s.fs, ExpandSequencerStateFile, content, 0o644); err != nil {
+		return fmt.Errorf("failed to write expand state: %w", err)
+	}
+
+	return nil
+}
+
+// Start starts a new sequence expanding filtered commits onto the commit onto.
+// The filtered commits are the earliest first, and each one must have a parent, which is used as the original commit for [ExpandCommit].
+// It fails if there is already a sequence in progress.
+//
+// The returned hash is the head of the sequence, which is the last generated commit once all the filtered commits are expanded,
+// or the commit the conflicting commit should be applied to if an [ExpandConflictError] is returned.
+func (s *ExpandSequencer) Start(ctx context.Context, filtered []*object.Commit, onto *object.Commit) (plumbing.Hash, error) {
+	_, err := s.State()
+	if err == nil {
+		return plumbing.ZeroHash, fmt.Errorf("an expand sequence is already in progress")
+	} else if !errors.Is(err, ErrNoExpandSequence) {
+		return plumbing.ZeroHash, err
+	}
+
+	state := &ExpandSequencerState{
+		Onto:    onto.Hash.String(),
+		Head:    onto.Hash.String(),
+		Pending: make([]string, 0, len(filtered)),
+		Done:    make([]ExpandSequencerStep, 0, len(filtered)),
+	}
+
+	for _, c := range filtered {
+		state.Pending = append(state.Pending, c.Hash.String())
+	}
+
+	s.state = state
+	if err := s.saveState(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return s.run(ctx)
+}
+
+// Continue resumes the sequence after a conflict is fixed.
+// resolved is the commit in the target repo that contains the fix for the conflicting commit, and its first parent must be the current head.
+// The returned hash is the same as [ExpandSequencer.Start].
+func (s *ExpandSequencer) Continue(ctx context.Context, resolved *object.Commit) (plumbing.Hash, error) {
+	state, err := s.State()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if state.Conflict != nil {
+		if resolved == nil {
+			return plumbing.ZeroHash, fmt.Errorf("resolution of conflict on %s is required", state.Conflict.Filtered)
+		}
+		if resolved.NumParents() == 0 || resolved.ParentHashes[0].String() != state.Head {
+			return plumbing.ZeroHash, fmt.Errorf("resolved commit %s is not a child of current head %s", resolved.Hash, state.Head)
+		}
+
+		logger.Info("conflict resolved", "filtered", state.Conflict.Filtered, "resolved", resolved.Hash)
+
+		state.Done = append(state.Done, ExpandSequencerStep{
+			Filtered:  state.Conflict.Filtered,
+			Generated: resolved.Hash.String(),
+		})
+		state.Head = resolved.Hash.String()
+		state.Conflict = nil
+
+		if err := s.saveState(); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	return s.run(ctx)
+}
+
+// Skip drops the conflicting commit and resumes the sequence.
+// The returned hash is the same as [ExpandSequencer.Start].
+func (s *ExpandSequencer) Skip(ctx context.Context) (plumbing.Hash, error) {
+	state, err := s.State()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if state.Conflict != nil {
+		logger.Info("skip commit", "filtered", state.Conflict.Filtered)
+		state.Done = append(state.Done, ExpandSequencerStep{
+			Filtered: state.Conflict.Filtered,
+			Skipped:  true,
+		})
+		state.Conflict = nil
+
+		if err := s.saveState(); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	return s.run(ctx)
+}
+
+// Abort removes the persisted state and returns the commit the sequence started from.
+// Commits already generated are left in the target storer.
+func (s *ExpandSequencer) Abort() (plumbing.Hash, error) {
+	state, err := s.State()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := s.finish(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return plumbing.NewHash(state.Onto), nil
+}
+
+func (s *ExpandSequencer) finish() error {
+	if err := s.fs.Remove(ExpandSequencerStateFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove expand state: %w", err)
+	}
+
+	s.state = nil
+
+	return nil
+}
+
+// run expands the pending commits until all of them are done or a conflict is encountered.
+func (s *ExpandSequencer) run(ctx context.Context) (plumbing.Hash, error) {
+	state := s.state
+
+	for len(state.Pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return plumbing.NewHash(state.Head), ctx.Err()
+		default:
+		}
+
+		filteredHash := plumbing.NewHash(state.Pending[0])
+		headHash := plumbing.NewHash(state.Head)
+
+		newcommit, err := s.expandOne(ctx, filteredHash, headHash)
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return headHash, err
+		}
+
+		state.Pending = state.Pending[1:]
+
+		if err != nil {
+			state.Conflict = &ExpandSequencerConflict{
+				Filtered: filteredHash.String(),
+				Error:    err.Error(),
+			}
+			if saveerr := s.saveState(); saveerr != nil {
+				return headHash, errors.Join(err, saveerr)
+			}
+
+			return headHash, &ExpandConflictError{
+				Filtered: filteredHash,
+				Head:     headHash,
+				Err:      err,
+			}
+		}
+
+		logger.Info("expanded commit", "filtered", filteredHash, "generated", newcommit.Hash)
+
+		state.Done = append(state.Done, ExpandSequencerStep{
+			Filtered:  filteredHash.String(),
+			Generated: newcommit.Hash.String(),
+		})
+		state.Head = newcommit.Hash.String()
+
+		if err := s.saveState(); err != nil {
+			return newcommit.Hash, err
+		}
+	}
+
+	head := plumbing.NewHash(state.Head)
+
+	return head, s.finish()
+}
+
+func (s *ExpandSequencer) expandOne(ctx context.Context, filteredHash plumbing.Hash, headHash plumbing.Hash) (*object.Commit, error) {
+	filteredNew, err := object.GetCommit(s.sourceStorer, filteredHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain filtered commit %s: %w", filteredHash, err)
+	}
+	if filteredNew.NumParents() == 0 {
+		return nil, fmt.Errorf("filtered commit %s has no parent", filteredHash)
+	}
+	filteredOrig, err := filteredNew.Parent(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain parent of filtered commit %s: %w", filteredHash, err)
+	}
+	target, err := object.GetCommit(s.targetStorer, headHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain target commit %s: %w", headHash, err)
+	}
+
+	return ExpandCommitWithOptions(ctx, s.sourceStorer, filteredOrig, filteredNew, target, s.targetStorer, s.filter, s.opts)
+}
@@ -0,0 +1,200 @@
+package permgit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func newTestCommit(t testing.TB, s *memory.Storage, files map[string]string, i int, parents ...*object.Commit) *object.Commit {
+	t.Helper()
+
+	sig := object.Signature{Name: "a", Email: "a@example.com", When: time.Unix(1700000000+int64(i)*86400, 0).UTC()}
+	c := &object.Commit{
+		Author:    sig,
+		Committer: sig,
+		Message:   fmt.Sprintf("commit %d\n", i),
+		TreeHash:  buildTestTree(t, s, files).Hash,
+	}
+	for _, p := range parents {
+		c.ParentHashes = append(c.ParentHashes, p.Hash)
+	}
+
+	return saveTestCommit(t, s, c)
+}
+
+func TestExpandSequencer(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	filter, err := permgit.NewOrFilterForPatterns("pub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	onto := newTestCommit(t, s, map[string]string{"pub/a": "a\n", "priv/x": "x\n"}, 0)
+
+	f0 := newTestCommit(t, s, map[string]string{"pub/a": "a\n"}, 1)
+	f1 := newTestCommit(t, s, map[string]string{"pub/a": "a\n", "pub/b": "b\n"}, 2, f0)
+	// the change to priv/y is refused by the filter.
+	conflicting := newTestCommit(t, s, map[string]string{"pub/a": "a\n", "pub/b": "b\n", "priv/y": "y\n"}, 3, f1)
+	f3 := newTestCommit(t, s, map[string]string{"pub/a": "a\n", "pub/b": "b\n", "pub/c": "c\n"}, 4, f1)
+
+	filtered := []*object.Commit{f1, conflicting, f3}
+
+	// start runs until the conflict, and returns the generated commit for f1.
+	start := func(t *testing.T) (billy.Filesystem, plumbing.Hash) {
+		t.Helper()
+
+		fs := memfs.New()
+		seq := permgit.NewExpandSequencer(fs, s, s, filter, nil)
+		head, err := seq.Start(ctx, filtered, onto)
+
+		var conflict *permgit.ExpandConflictError
+		if !errors.As(err, &conflict) {
+			t.Fatalf("want conflict, got %v", err)
+		}
+		if conflict.Filtered != conflicting.Hash || conflict.Head != head {
+			t.Errorf("unexpected conflict %s", conflict.Error())
+		}
+
+		generated, err := object.GetCommit(s, head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if generated.ParentHashes[0] != onto.Hash {
+			t.Errorf("generated commit %s is not on top of %s", head, onto.Hash)
+		}
+
+		return fs, head
+	}
+
+	checkFiles := func(t *testing.T, hash plumbing.Hash, want map[string]string) {
+		t.Helper()
+
+		c, err := object.GetCommit(s, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree, err := c.Tree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := readTestTree(t, tree)
+		if len(got) != len(want) {
+			t.Errorf("want %v, got %v", want, got)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("file %s: want %q, got %q", k, v, got[k])
+			}
+		}
+	}
+
+	t.Run("continue", func(t *testing.T) {
+		fs, head := start(t)
+
+		// the state is reloaded from the file system.
+		seq := permgit.NewExpandSequencer(fs, s, s, filter, nil)
+		state, err := seq.State()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.Head != head.String() || state.Conflict == nil || state.Conflict.Filtered != conflicting.Hash.String() {
+			t.Errorf("unexpected state %+v", state)
+		}
+		if len(state.Pending) != 1 || state.Pending[0] != f3.Hash.String() {
+			t.Errorf("want pending %s, got %v", f3.Hash, state.Pending)
+		}
+		if len(state.Done) != 1 || state.Done[0].Filtered != f1.Hash.String() || state.Done[0].Generated != head.String() {
+			t.Errorf("unexpected done %+v", state.Done)
+		}
+
+		if _, err := seq.Start(ctx, filtered, onto); err == nil {
+			t.Errorf("start should fail with a sequence in progress")
+		}
+		if _, err := seq.Continue(ctx, nil); err == nil {
+			t.Errorf("continue should require the resolution")
+		}
+		if _, err := seq.Continue(ctx, onto); err == nil {
+			t.Errorf("continue should refuse the resolution not on top of head")
+		}
+
+		headcommit, err := object.GetCommit(s, head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resolved := newTestCommit(t, s, map[string]string{"pub/a": "a\n", "pub/b": "b\n", "pub/y": "y\n", "priv/x": "x\n"}, 5, headcommit)
+
+		newhead, err := seq.Continue(ctx, resolved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkFiles(t, newhead, map[string]string{"pub/a": "a\n", "pub/b": "b\n", "pub/c": "c\n", "pub/y": "y\n", "priv/x": "x\n"})
+
+		newheadcommit, err := object.GetCommit(s, newhead)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newheadcommit.ParentHashes[0] != resolved.Hash {
+			t.Errorf("new head %s is not on top of the resolution %s", newhead, resolved.Hash)
+		}
+
+		if _, err := permgit.NewExpandSequencer(fs, s, s, filter, nil).State(); !errors.Is(err, permgit.ErrNoExpandSequence) {
+			t.Errorf("state should be removed after the sequence finishes, got %v", err)
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		fs, head := start(t)
+
+		seq := permgit.NewExpandSequencer(fs, s, s, filter, nil)
+		newhead, err := seq.Skip(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkFiles(t, newhead, map[string]string{"pub/a": "a\n", "pub/b": "b\n", "pub/c": "c\n", "priv/x": "x\n"})
+
+		newheadcommit, err := object.GetCommit(s, newhead)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newheadcommit.ParentHashes[0] != head {
+			t.Errorf("new head %s is not on top of %s", newhead, head)
+		}
+
+		if _, err := seq.Skip(ctx); !errors.Is(err, permgit.ErrNoExpandSequence) {
+			t.Errorf("want %v, got %v", permgit.ErrNoExpandSequence, err)
+		}
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		fs, _ := start(t)
+
+		seq := permgit.NewExpandSequencer(fs, s, s, filter, nil)
+		orig, err := seq.Abort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if orig != onto.Hash {
+			t.Errorf("want %s, got %s", onto.Hash, orig)
+		}
+
+		if _, err := seq.State(); !errors.Is(err, permgit.ErrNoExpandSequence) {
+			t.Errorf("want %v, got %v", permgit.ErrNoExpandSequence, err)
+		}
+		if _, err := seq.Abort(); !errors.Is(err, permgit.ErrNoExpandSequence) {
+			t.Errorf("want %v, got %v", permgit.ErrNoExpandSequence, err)
+		}
+	})
+}
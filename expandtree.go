@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
@@ -42,7 +44,19 @@ func (e *FilePatchError) Error() string {
 	return strings.Join(errfs, "|")
 }
 
+// TransformedFileError is returned when a change in the filtered repo modifies a file whose content in the target
+// differs from the filtered repo because of [BlobTransformers]. Applying the change would lose the redacted content in the target,
+// or leak it backwards if the change is merged.
+type TransformedFileError struct {
+	Path string
+}
+
+func (e *TransformedFileError) Error() string {
+	return fmt.Sprintf("%s is transformed in the filtered repo and cannot be expanded", e.Path)
+}
+
 // ExpandTree apply the changes made in the filteredNew tree to filteredOrig tree and apply them to target tree, it returns a new tree.
+// See [ExpandTreeWithOptions].
 func ExpandTree(
 	ctx context.Context,
 	sourceStorer storer.Storer,
@@ -52,44 +66,104 @@ func ExpandTree(
 	targetStorer storer.Storer,
 	filter Filter,
 ) (*object.Tree, error) {
-	filteredPath, err := filteredOrig.Patch(filteredNew)
+	return ExpandTreeWithOptions(ctx, sourceStorer, filteredOrig, filteredNew, target, targetStorer, filter, nil)
+}
+
+// expandChange is a change between the filtered trees.
+// from is the entry in the filtered original tree, and to is the entry in the filtered new tree.
+type expandChange struct {
+	from *object.ChangeEntry
+	to   *object.ChangeEntry
+
+	// targetFrom is the hash of the from file in the target tree.
+	targetFrom plumbing.Hash
+	// toHash is the hash of the to file in the target tree, and it will be copied from the source storer if fromSource is true.
+	toHash     plumbing.Hash
+	fromSource bool
+	// fromLFS is true if the from file is a Git LFS pointer to the file in the target.
+	fromLFS bool
+}
+
+func (c *expandChange) isRename() bool {
+	return c.from != nil && c.to != nil && c.from.Name != c.to.Name
+}
+
+// ExpandTreeWithOptions apply the changes made in the filteredNew tree to filteredOrig tree and apply them to target tree, it returns a new tree.
+// Nil opts is the same as a zero [ExpandOptions].
+// If the filter is an [EntryFilter], the changed entries are checked by their modes and hashes as well,
+// for example a change turning an included file into an excluded symlink is refused.
+func ExpandTreeWithOptions(
+	ctx context.Context,
+	sourceStorer storer.Storer,
+	filteredOrig *object.Tree,
+	filteredNew *object.Tree,
+	target *object.Tree,
+	targetStorer storer.Storer,
+	filter Filter,
+	opts *ExpandOptions,
+) (*object.Tree, error) {
+	if opts == nil {
+		opts = &ExpandOptions{}
+	}
+
+	diffopts := &object.DiffTreeOptions{
+		DetectRenames: opts.RenameScore > 0,
+		RenameScore:   opts.RenameScore,
+	}
+	filteredChanges, err := object.DiffTreeWithOptions(ctx, filteredOrig, filteredNew, diffopts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate path for the two filtered trees: %w", err)
+		return nil, errorf(err, "failed to generate changes for the two filtered trees: %w", err)
 	}
 
-	filepatches := filteredPath.FilePatches()
+	entryfilter := AsEntryFilter(filter)
 
 	// collect all invalid file paths into the errors
 	var errs []error
 
-	// first pass, check if the patches are valid.
-	for i, afile := range filepatches {
+	changes := make([]*expandChange, 0, len(filteredChanges))
+	// gitmodules is the change of .gitmodules, which is merged into the target section by section.
+	var gitmodules *expandChange
+
+	// first pass, check if the changes are valid.
+	for i, afile := range filteredChanges {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		default:
 		}
-		fromfile, tofile := afile.Files()
+
+		achange := &expandChange{}
+		if afile.From.Name != "" {
+			achange.from = &afile.From
+		}
+		if afile.To.Name != "" {
+			achange.to = &afile.To
+		}
 
 		fromfilename := ""
-		if fromfile != nil {
-			fromfilename = fromfile.Path()
+		if achange.from != nil {
+			fromfilename = achange.from.Name
 		}
 		tofilename := ""
-		if tofile != nil {
-			tofilename = tofile.Path()
+		if achange.to != nil {
+			tofilename = achange.to.Name
 		}
 
-		logger.Debug("patch", "idx", i, "operation", getFileOperation(fromfilename, tofilename), "from", fromfilename, "to", tofilename)
+		logger.Debug("change", "idx", i, "operation", getFileOperation(fromfilename, tofilename), "from", fromfilename, "to", tofilename)
+
+		if opts.KeepSubmodules && (fromfilename == GitModulesFile || fromfilename == "") && (tofilename == GitModulesFile || tofilename == "") {
+			gitmodules = achange
+			continue
+		}
 
 		var thiserr *FilePatchError
-		if fromfile != nil && !filter.Filter(strings.Split(fromfilename, "/"), false).IsIn() {
+		if achange.from != nil && !entryfilter.FilterEntry(strings.Split(fromfilename, "/"), &achange.from.TreeEntry).IsIn() {
 			if thiserr == nil {
 				thiserr = new(FilePatchError)
 			}
 			thiserr.FromFile = fromfilename
 		}
-		if tofile != nil && !filter.Filter(strings.Split(tofilename, "/"), false).IsIn() {
+		if achange.to != nil && !entryfilter.FilterEntry(strings.Split(tofilename, "/"), &achange.to.TreeEntry).IsIn() {
 			if thiserr == nil {
 				thiserr = new(FilePatchError)
 			}
@@ -98,61 +172,172 @@ func ExpandTree(
 		if thiserr != nil {
 			errs = append(errs, thiserr)
 		}
+
+		changes = append(changes, achange)
 	}
 
 	if len(errs) > 0 {
 		return nil, errors.Join(errs...)
 	}
 
-	editTree, err := newInflightTree(target)
-	if err != nil {
-		return nil, err
+	editTree := newInflightTree(target)
+
+	var origFiles map[plumbing.Hash]string
+	if opts.DetectCopies {
+		origFiles, err = treeFilesByHash(ctx, filteredOrig)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// second pass, delete files that are deleted or renamed
-	for _, afile := range filepatches {
+	// second pass, figure out the content of the files in the target.
+	for _, achange := range changes {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		default:
 		}
 
-		fromfile, tofile := afile.Files()
-		if !(fromfile != nil && (tofile == nil || tofile.Path() != fromfile.Path())) {
+		if achange.from != nil {
+			achange.targetFrom = achange.from.TreeEntry.Hash
+		}
+
+		if achange.from != nil && (opts.BlobTransformers.Applies(strings.Split(achange.from.Name, "/")) ||
+			achange.to != nil && opts.BlobTransformers.Applies(strings.Split(achange.to.Name, "/"))) {
+			targetentry, found := editTree.Find(strings.Split(achange.from.Name, "/"))
+			if found && targetentry.Hash != achange.from.TreeEntry.Hash {
+				if achange.to != nil {
+					return nil, &TransformedFileError{Path: achange.from.Name}
+				}
+				// deleting the transformed file doesn't leak anything.
+				achange.targetFrom = targetentry.Hash
+			}
+		}
+
+		if achange.from != nil && opts.BlobSizeLimit != nil {
+			targetentry, found := editTree.Find(strings.Split(achange.from.Name, "/"))
+			if found && targetentry.Hash != achange.from.TreeEntry.Hash {
+				matched, err := opts.BlobSizeLimit.matchesPointer(sourceStorer, achange.from.TreeEntry.Hash, targetStorer, targetentry.Hash)
+				if err != nil {
+					return nil, fmt.Errorf("failed to match lfs pointer %s: %w", achange.from.Name, err)
+				}
+				if matched {
+					achange.targetFrom = targetentry.Hash
+					achange.fromLFS = true
+				}
+			}
+		}
+
+		if achange.to == nil {
 			continue
 		}
-		if fromfile.Mode() == filemode.Submodule {
-			logger.Warn("silently ignore submodule in from-file", "path", fromfile.Path())
+
+		achange.toHash = achange.to.TreeEntry.Hash
+		achange.fromSource = true
+
+		if opts.BlobSizeLimit != nil && achange.to.TreeEntry.Mode != filemode.Submodule {
+			if achange.fromLFS && achange.to.TreeEntry.Hash == achange.from.TreeEntry.Hash {
+				// the pointer is moved, move the large file in the target.
+				achange.toHash = achange.targetFrom
+				achange.fromSource = false
+				continue
+			}
+			restored, ok, err := opts.BlobSizeLimit.restore(sourceStorer, achange.to.TreeEntry.Hash, targetStorer)
+			if err != nil {
+				return nil, fmt.Errorf("failed to restore lfs pointer %s: %w", achange.to.Name, err)
+			}
+			if ok {
+				achange.toHash = restored
+				achange.fromSource = false
+				continue
+			}
+			if achange.fromLFS {
+				// the pointer is changed and the object is not in the store, keep the pointer intact.
+				continue
+			}
+		}
+
+		var basename string
+		var basehash plumbing.Hash
+		switch {
+		case achange.isRename():
+			basename = achange.from.Name
+			basehash = achange.from.TreeEntry.Hash
+		case achange.from == nil && opts.DetectCopies:
+			copyfrom, found := origFiles[achange.to.TreeEntry.Hash]
+			if !found {
+				continue
+			}
+			basename = copyfrom
+			basehash = achange.to.TreeEntry.Hash
+		default:
+			continue
+		}
+
+		targetentry, found := editTree.Find(strings.Split(basename, "/"))
+		if !found || targetentry.Hash == basehash || achange.to.TreeEntry.Mode == filemode.Submodule {
 			continue
 		}
 
-		err := editTree.Delete(ctx, fromfile.Hash(), fromfile.Mode(), strings.Split(fromfile.Path(), "/"))
+		logger.Debug("merge target differences", "from", basename, "to", achange.to.Name, "target", targetentry.Hash)
+
+		merged, err := mergeBlobs(sourceStorer, targetStorer, achange.to.Name, basehash, targetentry.Hash, achange.to.TreeEntry.Hash)
 		if err != nil {
-			return nil, errorf(err, "failed to delete file %s: %w", fromfile.Path(), err)
+			return nil, fmt.Errorf("failed to carry target changes in %s to %s: %w", basename, achange.to.Name, err)
 		}
+
+		if achange.isRename() {
+			achange.targetFrom = targetentry.Hash
+		}
+		achange.toHash = merged
+		achange.fromSource = false
 	}
 
-	// third pass, update files (new, renamed, or modified)
-	for _, afile := range filepatches {
+	// third pass, delete files that are deleted or renamed
+	for _, achange := range changes {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		default:
 		}
 
-		_, tofile := afile.Files()
-		if tofile == nil {
+		fromfile, tofile := achange.from, achange.to
+		if !(fromfile != nil && (tofile == nil || tofile.Name != fromfile.Name)) {
 			continue
 		}
-		if tofile.Mode() == filemode.Submodule {
-			logger.Warn("silently ignore submodule in to-file", "path", tofile.Path())
-			continue
+		err := editTree.Delete(ctx, achange.targetFrom, fromfile.TreeEntry.Mode, strings.Split(fromfile.Name, "/"))
+		if err != nil {
+			return nil, errorf(err, "failed to delete file %s: %w", fromfile.Name, err)
 		}
+	}
 
-		if err := editTree.Update(ctx, sourceStorer, targetStorer, tofile.Hash(), tofile.Mode(), strings.Split(tofile.Path(), "/")); err != nil {
-			return nil, errorf(err, "failed to update file %s %s: %w", tofile.Path(), tofile.Hash(), err)
+	// fourth pass, update files (new, renamed, or modified)
+	for _, achange := range changes {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
 		}
 
+		tofile := achange.to
+		if tofile == nil {
+			continue
+		}
+		pathsegs := strings.Split(tofile.Name, "/")
+		if achange.fromSource && tofile.TreeEntry.Mode != filemode.Submodule {
+			err = editTree.Update(ctx, sourceStorer, targetStorer, achange.toHash, tofile.TreeEntry.Mode, pathsegs)
+		} else {
+			err = editTree.Set(achange.toHash, tofile.TreeEntry.Mode, pathsegs)
+		}
+		if err != nil {
+			return nil, errorf(err, "failed to update file %s %s: %w", tofile.Name, achange.toHash, err)
+		}
+	}
+
+	if gitmodules != nil {
+		if err := expandGitModules(sourceStorer, targetStorer, editTree, gitmodules.from, gitmodules.to); err != nil {
+			return nil, err
+		}
 	}
 
 	newtree, err := editTree.BuildTree(ctx, targetStorer)
@@ -162,3 +347,32 @@ func ExpandTree(
 
 	return newtree, nil
 }
+
+// treeFilesByHash collects the paths of the files in the tree by their hashes.
+// If multiple files have the same hash, the first one found is kept.
+func treeFilesByHash(ctx context.Context, t *object.Tree) (map[plumbing.Hash]string, error) {
+	result := make(map[plumbing.Hash]string)
+
+	iter := t.Files()
+	defer iter.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		f, err := iter.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate files: %w", err)
+		}
+		if _, found := result[f.Hash]; !found {
+			result[f.Hash] = f.Name
+		}
+	}
+
+	return result, nil
+}
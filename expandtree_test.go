@@ -0,0 +1,372 @@
+package permgit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+// saveTestBlob writes the content as a blob into the storer.
+func saveTestBlob(t testing.TB, s storer.EncodedObjectStorer, content string) plumbing.Hash {
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	h, err := s.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+// buildTestTree creates a tree from a map of file path to content.
+func buildTestTree(t testing.TB, s storer.EncodedObjectStorer, files map[string]string) *object.Tree {
+	dirs := make(map[string]map[string]object.TreeEntry)
+	var adddir func(dir string)
+	adddir = func(dir string) {
+		if _, found := dirs[dir]; found {
+			return
+		}
+		dirs[dir] = make(map[string]object.TreeEntry)
+		if dir != "" {
+			parent := path.Dir(dir)
+			if parent == "." {
+				parent = ""
+			}
+			adddir(parent)
+		}
+	}
+
+	adddir("")
+	for name, content := range files {
+		dir := path.Dir(name)
+		if dir == "." {
+			dir = ""
+		}
+		adddir(dir)
+		dirs[dir][path.Base(name)] = object.TreeEntry{
+			Name: path.Base(name),
+			Mode: filemode.Regular,
+			Hash: saveTestBlob(t, s, content),
+		}
+	}
+
+	dirnames := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		dirnames = append(dirnames, dir)
+	}
+	// children before parents
+	sort.Slice(dirnames, func(i, j int) bool {
+		return strings.Count(dirnames[i], "/") > strings.Count(dirnames[j], "/") || (dirnames[i] != "" && dirnames[j] == "")
+	})
+
+	var root plumbing.Hash
+	for _, dir := range dirnames {
+		tree := &object.Tree{}
+		for _, e := range dirs[dir] {
+			tree.Entries = append(tree.Entries, e)
+		}
+		sort.Slice(tree.Entries, func(i, j int) bool {
+			return tree.Entries[i].Name < tree.Entries[j].Name
+		})
+		obj := s.NewEncodedObject()
+		if err := tree.Encode(obj); err != nil {
+			t.Fatal(err)
+		}
+		h, err := s.SetEncodedObject(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir == "" {
+			root = h
+			continue
+		}
+		parent := path.Dir(dir)
+		if parent == "." {
+			parent = ""
+		}
+		dirs[parent][path.Base(dir)] = object.TreeEntry{
+			Name: path.Base(dir),
+			Mode: filemode.Dir,
+			Hash: h,
+		}
+	}
+
+	tree, err := object.GetTree(s, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tree
+}
+
+// readTestTree reads all the files in the tree into a map of file path to content.
+func readTestTree(t testing.TB, tree *object.Tree) map[string]string {
+	result := make(map[string]string)
+	iter := tree.Files()
+	defer iter.Close()
+	for {
+		f, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := f.Contents()
+		if err != nil {
+			t.Fatal(err)
+		}
+		result[f.Name] = content
+	}
+
+	return result
+}
+
+func TestExpandTreeWithOptions_rename(t *testing.T) {
+	s := memory.NewStorage()
+	filter, err := permgit.NewOrFilterForPatterns("pub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filteredOrig := buildTestTree(t, s, map[string]string{
+		"pub/a.txt": "l1\nl2\nl3\nl4\nl5\n",
+	})
+	filteredNew := buildTestTree(t, s, map[string]string{
+		"pub/b.txt": "l1\nl2\nl3\nl4\nl5\nl6\n",
+	})
+	target := buildTestTree(t, s, map[string]string{
+		"pub/a.txt": "l1\nl2-internal\nl3\nl4\nl5\n",
+		"priv/x":    "x\n",
+	})
+
+	if _, err := permgit.ExpandTree(context.Background(), s, filteredOrig, filteredNew, target, s, filter); err == nil {
+		t.Errorf("expecting error when renamed file differs in target without rename detection")
+	}
+
+	newtree, err := permgit.ExpandTreeWithOptions(
+		context.Background(), s, filteredOrig, filteredNew, target, s, filter,
+		&permgit.ExpandOptions{RenameScore: permgit.DefaultRenameScore})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := readTestTree(t, newtree)
+	want := map[string]string{
+		"pub/b.txt": "l1\nl2-internal\nl3\nl4\nl5\nl6\n",
+		"priv/x":    "x\n",
+	}
+	if len(got) != len(want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("file %s: want %q, got %q", k, v, got[k])
+		}
+	}
+}
+
+// checkTestTree checks all the files in the tree against a map of file path to content.
+func checkTestTree(t testing.TB, tree *object.Tree, want map[string]string) {
+	t.Helper()
+
+	got := readTestTree(t, tree)
+	if len(got) != len(want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("file %s: want %q, got %q", k, v, got[k])
+		}
+	}
+}
+
+func TestExpandTreeWithOptions_copies(t *testing.T) {
+	s := memory.NewStorage()
+	filter, err := permgit.NewOrFilterForPatterns("pub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filteredOrig := buildTestTree(t, s, map[string]string{
+		"pub/a.txt": "l1\nl2\nl3\n",
+	})
+	filteredNew := buildTestTree(t, s, map[string]string{
+		"pub/a.txt": "l1\nl2\nl3\n",
+		"pub/c.txt": "l1\nl2\nl3\n",
+	})
+	target := buildTestTree(t, s, map[string]string{
+		"pub/a.txt": "l1\nl2-internal\nl3\n",
+	})
+
+	newtree, err := permgit.ExpandTree(context.Background(), s, filteredOrig, filteredNew, target, s, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestTree(t, newtree, map[string]string{
+		"pub/a.txt": "l1\nl2-internal\nl3\n",
+		"pub/c.txt": "l1\nl2\nl3\n",
+	})
+
+	newtree, err = permgit.ExpandTreeWithOptions(context.Background(), s, filteredOrig, filteredNew, target, s, filter, &permgit.ExpandOptions{DetectCopies: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestTree(t, newtree, map[string]string{
+		"pub/a.txt": "l1\nl2-internal\nl3\n",
+		"pub/c.txt": "l1\nl2-internal\nl3\n",
+	})
+}
+
+func TestExpandTreeWithOptions_renameScore(t *testing.T) {
+	s := memory.NewStorage()
+	filter, err := permgit.NewOrFilterForPatterns("pub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filteredOrig := buildTestTree(t, s, map[string]string{
+		"pub/a.txt": "line 1\nline 2\nline 3\nline 4\nline 5\nline 6\n",
+	})
+	// about half of the content is changed.
+	filteredNew := buildTestTree(t, s, map[string]string{
+		"pub/b.txt": "line 1\nline 2\nline 3\nother 4\nother 5\nother 6\n",
+	})
+	target := buildTestTree(t, s, map[string]string{
+		"pub/a.txt": "internal 1\nline 2\nline 3\nline 4\nline 5\nline 6\n",
+	})
+
+	if _, err := permgit.ExpandTreeWithOptions(context.Background(), s, filteredOrig, filteredNew, target, s, filter, &permgit.ExpandOptions{RenameScore: 90}); err == nil {
+		t.Errorf("expecting error when the similarity is below the rename score")
+	}
+
+	newtree, err := permgit.ExpandTreeWithOptions(context.Background(), s, filteredOrig, filteredNew, target, s, filter, &permgit.ExpandOptions{RenameScore: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestTree(t, newtree, map[string]string{
+		"pub/b.txt": "internal 1\nline 2\nline 3\nother 4\nother 5\nother 6\n",
+	})
+}
+
+func TestExpandTreeWithOptions_mergeConflict(t *testing.T) {
+	s := memory.NewStorage()
+	filter, err := permgit.NewOrFilterForPatterns("pub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filteredOrig := buildTestTree(t, s, map[string]string{
+		"pub/a.txt": "l1\nl2\nl3\nl4\nl5\n",
+	})
+	filteredNew := buildTestTree(t, s, map[string]string{
+		"pub/b.txt": "l1\nl2-public\nl3\nl4\nl5\n",
+	})
+	target := buildTestTree(t, s, map[string]string{
+		"pub/a.txt": "l1\nl2-internal\nl3\nl4\nl5\n",
+	})
+
+	_, err = permgit.ExpandTreeWithOptions(context.Background(), s, filteredOrig, filteredNew, target, s, filter, &permgit.ExpandOptions{RenameScore: permgit.DefaultRenameScore})
+	var conflict *permgit.MergeConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("want merge conflict, got %v", err)
+	}
+	if conflict.Path != "pub/b.txt" {
+		t.Errorf("want conflict in pub/b.txt, got %s", conflict.Path)
+	}
+}
+
+func TestExpandTreeWithOptions_binary(t *testing.T) {
+	s := memory.NewStorage()
+	filter, err := permgit.NewOrFilterForPatterns("pub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &permgit.ExpandOptions{RenameScore: permgit.DefaultRenameScore}
+
+	orig := "binary\x00" + strings.Repeat("public\n", 20)
+	filteredOrig := buildTestTree(t, s, map[string]string{
+		"pub/a.bin": orig,
+	})
+	target := buildTestTree(t, s, map[string]string{
+		"pub/a.bin": "binary\x00" + strings.Repeat("public\n", 19) + "internal\n",
+	})
+
+	// a pure rename keeps the content in the target.
+	filteredNew := buildTestTree(t, s, map[string]string{
+		"pub/b.bin": orig,
+	})
+	newtree, err := permgit.ExpandTreeWithOptions(context.Background(), s, filteredOrig, filteredNew, target, s, filter, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestTree(t, newtree, map[string]string{
+		"pub/b.bin": "binary\x00" + strings.Repeat("public\n", 19) + "internal\n",
+	})
+
+	// binary files cannot be merged by lines.
+	filteredNew = buildTestTree(t, s, map[string]string{
+		"pub/b.bin": "changed\x00" + strings.Repeat("public\n", 20),
+	})
+	_, err = permgit.ExpandTreeWithOptions(context.Background(), s, filteredOrig, filteredNew, target, s, filter, opts)
+	var conflict *permgit.MergeConflictError
+	if !errors.As(err, &conflict) {
+		t.Errorf("want merge conflict, got %v", err)
+	}
+}
+
+// BenchmarkExpandTree_large expands a single file change onto a target tree with 100,000 files.
+func BenchmarkExpandTree_large(b *testing.B) {
+	s := memory.NewStorage()
+	filter, err := permgit.NewOrFilterForPatterns("pub/")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	filteredOrig := buildTestTree(b, s, map[string]string{
+		"pub/a.txt": "a\n",
+	})
+	filteredNew := buildTestTree(b, s, map[string]string{
+		"pub/a.txt": "a\nb\n",
+	})
+
+	files := map[string]string{
+		"pub/a.txt": "a\n",
+	}
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 100; j++ {
+			for k := 0; k < 10; k++ {
+				files[fmt.Sprintf("d%d/e%d/f%d.txt", i, j, k)] = fmt.Sprintf("%d\n", k)
+			}
+		}
+	}
+	target := buildTestTree(b, s, files)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := permgit.ExpandTree(context.Background(), s, filteredOrig, filteredNew, target, s, filter); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package permgit_test
 
 import (
+	"context"
 	_ "embed"
+	"path"
 	"strings"
 	"testing"
 
 	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/google/go-cmp/cmp"
 
 	"github.com/fardream/permgit"
@@ -100,3 +104,252 @@ func TestPatternFilter_Filter_many(t *testing.T) {
 		}
 	}
 }
+
+var (
+	fuzzPatternSegments = []string{"a", "b", "c", "*", "a*", "*b", "?", "[ab]", "**"}
+	fuzzPathSegments    = []string{"a", "b", "c", "ab", "ba", "abc"}
+)
+
+// fuzzPattern generates a pattern from the bytes, the first byte decides the number of segments, if it is for directories only,
+// and if it is anchored with a leading '/'.
+func fuzzPattern(b []byte) string {
+	if len(b) == 0 {
+		return "/a"
+	}
+	n := 1 + int(b[0]%4)
+	segs := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		var v byte
+		if i < len(b) {
+			v = b[i]
+		}
+		segs = append(segs, fuzzPatternSegments[int(v)%len(fuzzPatternSegments)])
+	}
+	p := strings.Join(segs, "/")
+	if b[0]&0x40 == 0 {
+		p = "/" + p
+	}
+	if b[0]&0x80 != 0 {
+		p += "/"
+	}
+
+	return p
+}
+
+// fuzzPath generates a path of a file from the bytes, the first byte decides the number of segments.
+func fuzzPath(b []byte) []string {
+	if len(b) == 0 {
+		return []string{"a"}
+	}
+	n := 1 + int(b[0]%5)
+	segs := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		var v byte
+		if i < len(b) {
+			v = b[i]
+		}
+		segs = append(segs, fuzzPathSegments[int(v)%len(fuzzPathSegments)])
+	}
+
+	return segs
+}
+
+// checkFilterConsistency checks the results of the directories containing the file are consistent with the result of the file:
+// no file is in if any of its directories is out, and the file is in if any of its directories is in.
+func checkFilterConsistency(t *testing.T, name string, f permgit.Filter, paths []string) {
+	t.Helper()
+
+	file := f.Filter(paths, false)
+	for i := 1; i < len(paths); i++ {
+		dir := f.Filter(paths[:i], true)
+		if dir == permgit.FilterResult_Out && file != permgit.FilterResult_Out {
+			t.Errorf("%s: directory %s is out but file %s is %s", name, strings.Join(paths[:i], "/"), strings.Join(paths, "/"), file)
+		}
+		if dir == permgit.FilterResult_In && file != permgit.FilterResult_In {
+			t.Errorf("%s: directory %s is in but file %s is %s", name, strings.Join(paths[:i], "/"), strings.Join(paths, "/"), file)
+		}
+		if dir == permgit.FilterResult_Out || dir == permgit.FilterResult_In {
+			break
+		}
+	}
+}
+
+// anchorPattern adds the leading '/' to the pattern if it is missing.
+func anchorPattern(pattern string) string {
+	if strings.HasPrefix(pattern, "/") {
+		return pattern
+	}
+
+	return "/" + pattern
+}
+
+// referencePatternMatch matches the file against the pattern like git, the file is matched if it or any of its directories is matched.
+// Unlike git, a pattern without a leading '/', even without any '/' like *.go, is anchored at the root,
+// since the paths of [permgit.PatternFilter] are always relative to the root.
+func referencePatternMatch(pattern string, paths []string) bool {
+	dironly := strings.HasSuffix(pattern, "/")
+	segs := strings.Split(strings.Trim(anchorPattern(pattern), "/"), "/")
+
+	var match func(segs []string, paths []string) bool
+	match = func(segs []string, paths []string) bool {
+		switch {
+		case len(segs) == 0:
+			return len(paths) == 0
+		case segs[0] == "**":
+			for i := 0; i <= len(paths); i++ {
+				if match(segs[1:], paths[i:]) {
+					return true
+				}
+			}
+			return false
+		case len(paths) == 0:
+			return false
+		}
+		matched, _ := path.Match(segs[0], paths[0])
+		return matched && match(segs[1:], paths[1:])
+	}
+
+	for i := 1; i <= len(paths); i++ {
+		if i == len(paths) && dironly {
+			break
+		}
+		if match(segs, paths[:i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func FuzzPatternFilter(f *testing.F) {
+	f.Add([]byte{0x01, 0x00, 0x03}, []byte{0x02, 0x00, 0x01, 0x02})
+	f.Add([]byte{0x82, 0x00, 0x08, 0x01}, []byte{0x03, 0x00, 0x02, 0x01, 0x00})
+	f.Add([]byte{0x02, 0x08, 0x04, 0x05}, []byte{0x04, 0x02, 0x00, 0x01, 0x03, 0x05})
+	f.Add([]byte{0x81, 0x00, 0x06}, []byte{0x01, 0x00, 0x04})
+	f.Add([]byte{0x40, 0x04}, []byte{0x02, 0x05, 0x01, 0x03})
+	f.Add([]byte{0xc1, 0x08, 0x00}, []byte{0x03, 0x02, 0x00, 0x01, 0x05})
+	f.Add([]byte{0x41, 0x08, 0x05}, []byte{0x02, 0x00, 0x04, 0x01})
+
+	f.Fuzz(func(t *testing.T, patternBytes []byte, pathBytes []byte) {
+		pattern := fuzzPattern(patternBytes)
+		paths := fuzzPath(pathBytes)
+
+		filter, err := permgit.NewPatternFilter(pattern)
+		if err != nil {
+			// more than one ** or trailing **
+			t.Skip()
+		}
+
+		checkFilterConsistency(t, pattern, filter, paths)
+
+		got := filter.Filter(paths, false).IsIn()
+		if want := referencePatternMatch(pattern, paths); got != want {
+			t.Errorf("pattern %s on %s: reference matcher says %t but got %t", pattern, strings.Join(paths, "/"), want, got)
+		}
+
+		// the pattern without the leading '/' is the same as the anchored one.
+		anchored, err := permgit.NewPatternFilter(anchorPattern(pattern))
+		if err != nil {
+			t.Fatalf("pattern %s is valid but %s is not: %v", pattern, anchorPattern(pattern), err)
+		}
+		for i := 1; i <= len(paths); i++ {
+			for _, isdir := range []bool{true, false} {
+				if w, g := anchored.Filter(paths[:i], isdir), filter.Filter(paths[:i], isdir); w != g {
+					t.Errorf("pattern %s on %s (dir: %t): anchored gives %s, got %s", pattern, strings.Join(paths[:i], "/"), isdir, w, g)
+				}
+			}
+		}
+
+		// go-git does not backtrack for **, compare only when ** is followed by at most one segment.
+		if i := strings.Index(pattern, "**/"); i >= 0 && strings.Contains(strings.TrimSuffix(pattern[i+3:], "/"), "/") {
+			return
+		}
+		// like git, the files in a matched directory are matched, which go-git does not check for all patterns.
+		gp := gitignore.ParsePattern(anchorPattern(pattern), nil)
+		want := gp.Match(paths, false) == gitignore.Exclude
+		for i := 1; i < len(paths) && !want; i++ {
+			want = gp.Match(paths[:i], true) == gitignore.Exclude
+		}
+		if got != want {
+			t.Errorf("pattern %s on %s: gitignore says %t but got %t", pattern, strings.Join(paths, "/"), want, got)
+		}
+	})
+}
+
+func FuzzPatternTrieFilter(f *testing.F) {
+	f.Add([]byte{0x01, 0x00, 0x03}, []byte{0x82, 0x00, 0x08, 0x01}, []byte{0x03, 0x00, 0x02, 0x01, 0x00})
+	f.Add([]byte{0x02, 0x08, 0x04, 0x05}, []byte{0x81, 0x00, 0x06}, []byte{0x04, 0x02, 0x00, 0x01, 0x03, 0x05})
+	f.Add([]byte{0x41, 0x08, 0x05}, []byte{0xc0, 0x04}, []byte{0x03, 0x00, 0x04, 0x01})
+
+	f.Fuzz(func(t *testing.T, first []byte, second []byte, pathBytes []byte) {
+		patterns := []string{fuzzPattern(first), fuzzPattern(second)}
+		paths := fuzzPath(pathBytes)
+
+		want, err := permgit.NewOrFilterForPatterns(patterns...)
+		if err != nil {
+			t.Skip()
+		}
+		got, err := permgit.NewPatternTrieFilterForPatterns(patterns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 1; i <= len(paths); i++ {
+			for _, isdir := range []bool{true, false} {
+				if w, g := want.Filter(paths[:i], isdir), got.Filter(paths[:i], isdir); w != g {
+					t.Errorf("patterns %v on %s (dir: %t): want %s, got %s", patterns, strings.Join(paths[:i], "/"), isdir, w, g)
+				}
+			}
+		}
+	})
+}
+
+func TestPatternFilter_properties(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	files := make(map[string]string)
+	for _, line := range strings.Split(testfilenames, "\n") {
+		if line != "" {
+			files[line] = line + "\n"
+		}
+	}
+	tree := buildTestTree(t, s, files)
+
+	patterns := []string{"/aptos/**/*.js", "/aptos/**/src/", "/**/*.toml", "/*/api/**/cache/", "/LICENSE*"}
+	for i, p := range testPatternSet() {
+		if i%50 == 0 {
+			patterns = append(patterns, p)
+		}
+	}
+
+	for _, pattern := range patterns {
+		filter, err := permgit.NewPatternFilter(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		filtered, err := permgit.FilterTree(ctx, tree, nil, s, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]string
+		if filtered != nil {
+			stored, err := object.GetTree(s, filtered.Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = readTestTree(t, stored)
+		}
+
+		for name := range files {
+			paths := strings.Split(name, "/")
+			checkFilterConsistency(t, pattern, filter, paths)
+
+			_, kept := got[name]
+			if in := filter.Filter(paths, false).IsIn(); in != kept {
+				t.Errorf("pattern %s on %s: filter says %t but filtered tree says %t", pattern, name, in, kept)
+			}
+		}
+	}
+}
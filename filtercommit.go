@@ -13,12 +13,12 @@ import (
 // by applying filters to the tree in the input [object.Commit].
 // Optionally a parent commit can set on the generated commit.
 // The author info, committor info, commit message will be copied from the input commit.
-// Howver, GPG sign information will be dropped.
+// Howver, GPG sign information will be dropped, see [FilterOptions.Signer] to sign the generated commits.
 //
 //   - If after filtering, the tree is empty, a nil will be returned, and error will also be nil.
 //   - If the generated tree is exactly the same as the parent's, the parent commit will be returned and no new commit will be generated.
 //
-// Submodules will be silently ignored.
+// Submodules will be silently ignored. See [FilterCommitWithOptions] to keep them.
 func FilterCommit(
 	ctx context.Context,
 	c *object.Commit,
@@ -26,16 +26,51 @@ func FilterCommit(
 	s storer.Storer,
 	filters Filter,
 ) (*object.Commit, error) {
+	return FilterCommitWithOptions(ctx, c, parent, s, filters, nil)
+}
+
+// FilterCommitWithOptions is [FilterCommit] with [FilterOptions], nil opts is the same as a zero [FilterOptions].
+// The commit message is rewritten by [FilterOptions.MessageRewriter] if it is set,
+// the author and committer are mapped by [FilterOptions.Mailmap] if it is set,
+// the generated commit is signed by [FilterOptions.Signer] if it is set,
+// and the symlinks are validated by [ValidateSymlinks] if [FilterOptions.SymlinkPolicy] is set.
+// If the filter is a [CommitFilter], the tree is filtered by the filter for the commit.
+func FilterCommitWithOptions(
+	ctx context.Context,
+	c *object.Commit,
+	parent *object.Commit,
+	s storer.Storer,
+	filters Filter,
+	opts *FilterOptions,
+) (*object.Commit, error) {
+	if opts == nil {
+		opts = &FilterOptions{}
+	}
+
 	t, err := c.Tree()
 	if err != nil {
 		return nil, fmt.Errorf("failed to obtain tree for commit %s: %w", c.Hash.String(), err)
 	}
 
-	newtree, err := FilterTree(ctx, t, nil, s, filters)
+	filters, err = filterForCommit(ctx, filters, c)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.SecretScanner.setCommit(c.Hash)
+
+	newtree, err := FilterTreeWithOptions(ctx, t, nil, s, filters, opts)
 	if err != nil {
 		return nil, errorf(err, "failed to filter tree: %w", err)
 	}
 
+	if newtree != nil && opts.SymlinkPolicy != "" {
+		newtree, _, err = ValidateSymlinks(ctx, s, t, newtree, opts.SymlinkPolicy)
+		if err != nil {
+			return nil, errorf(err, "failed to validate symlinks: %w", err)
+		}
+	}
+
 	if newtree == nil {
 		return nil, nil
 	}
@@ -48,24 +83,48 @@ func FilterCommit(
 		parents = append(parents, parent.Hash)
 	}
 
+	message := c.Message
+	if opts.MessageRewriter != nil {
+		trees, err := commitAndParentTrees(c, t)
+		if err != nil {
+			return nil, err
+		}
+		message = opts.MessageRewriter.Rewrite(message, filters, trees...)
+	}
+
 	newcommit := &object.Commit{
 		TreeHash:     newtree.Hash,
-		Author:       c.Author,
-		Committer:    c.Committer,
-		Message:      c.Message,
+		Author:       opts.Mailmap.Map(c.Author),
+		Committer:    opts.Mailmap.Map(c.Committer),
+		Message:      message,
 		ParentHashes: parents,
 	}
 
-	newhash, err := GetHash(newcommit)
-	if err != nil {
-		return nil, fmt.Errorf("failed to obtain new hash for commit: %w ", err)
+	if err := signCommit(newcommit, opts.Signer); err != nil {
+		return nil, fmt.Errorf("failed to sign commit: %w", err)
 	}
 
-	newcommit.Hash = *newhash
-
 	if err := updateHashAndSave(ctx, newcommit, s); err != nil {
 		return nil, errorf(err, "failed to save commit: %w", err)
 	}
 
 	return newcommit, nil
 }
+
+// commitAndParentTrees returns the tree t of the commit followed by the trees of its parents.
+func commitAndParentTrees(c *object.Commit, t *object.Tree) ([]*object.Tree, error) {
+	trees := []*object.Tree{t}
+	for i, h := range c.ParentHashes {
+		parent, err := c.Parent(i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain parent %s of commit %s: %w", h, c.Hash, err)
+		}
+		pt, err := parent.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain tree for commit %s: %w", parent.Hash, err)
+		}
+		trees = append(trees, pt)
+	}
+
+	return trees, nil
+}
@@ -0,0 +1,121 @@
+package permgit
+
+import (
+	"context"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// FilterDiff is the difference between the files of a tree included by two filters.
+type FilterDiff struct {
+	// Included are the paths included by the new filter but not by the old one.
+	Included []string
+	// Excluded are the paths included by the old filter but not by the new one.
+	Excluded []string
+}
+
+// IsEmpty checks if the two filters include the same files.
+func (d *FilterDiff) IsEmpty() bool {
+	return len(d.Included) == 0 && len(d.Excluded) == 0
+}
+
+// DiffFilters lists the files in the tree newly included or excluded when the old filter is replaced by the new one.
+// The files are traversed the same way as [DumpTree], so the paths are those DumpTree would add or drop,
+// except the submodules, which [FilterTree] drops by default.
+// The filters are resolved for the tree by [FilterForTree], so the filters depending on the commit are rejected.
+func DiffFilters(ctx context.Context, tree *object.Tree, oldfilter Filter, newfilter Filter) (*FilterDiff, error) {
+	oldfilter, err := FilterForTree(ctx, oldfilter, tree)
+	if err != nil {
+		return nil, errorf(err, "failed to resolve old filter: %w", err)
+	}
+	newfilter, err = FilterForTree(ctx, newfilter, tree)
+	if err != nil {
+		return nil, errorf(err, "failed to resolve new filter: %w", err)
+	}
+
+	oldpaths, err := includedPaths(ctx, tree, oldfilter)
+	if err != nil {
+		return nil, errorf(err, "failed to walk tree with old filter: %w", err)
+	}
+	newpaths, err := includedPaths(ctx, tree, newfilter)
+	if err != nil {
+		return nil, errorf(err, "failed to walk tree with new filter: %w", err)
+	}
+
+	return &FilterDiff{
+		Included: pathsNotIn(newpaths, oldpaths),
+		Excluded: pathsNotIn(oldpaths, newpaths),
+	}, nil
+}
+
+// CommitFilterDiff is the [FilterDiff] of a commit.
+type CommitFilterDiff struct {
+	Commit *object.Commit
+	FilterDiff
+}
+
+// DiffFiltersForCommits compares the filters on each of the commits, and returns the commits whose filtered tree would change,
+// together with the files newly included or excluded.
+// Filters implementing [CommitFilter] are resolved for each commit the same way as [FilterCommit].
+func DiffFiltersForCommits(ctx context.Context, commits []*object.Commit, oldfilter Filter, newfilter Filter) ([]CommitFilterDiff, error) {
+	var result []CommitFilterDiff
+	for _, c := range commits {
+		tree, err := c.Tree()
+		if err != nil {
+			return nil, errorf(err, "failed to obtain tree for commit %s: %w", c.Hash, err)
+		}
+		oldcommitfilter, err := filterForCommit(ctx, oldfilter, c)
+		if err != nil {
+			return nil, errorf(err, "failed to resolve old filter for commit %s: %w", c.Hash, err)
+		}
+		newcommitfilter, err := filterForCommit(ctx, newfilter, c)
+		if err != nil {
+			return nil, errorf(err, "failed to resolve new filter for commit %s: %w", c.Hash, err)
+		}
+
+		diff, err := DiffFilters(ctx, tree, oldcommitfilter, newcommitfilter)
+		if err != nil {
+			return nil, errorf(err, "failed to diff filters for commit %s: %w", c.Hash, err)
+		}
+		if diff.IsEmpty() {
+			continue
+		}
+
+		logger.Debug("filtered tree changes", "commit", c.Hash, "included", len(diff.Included), "excluded", len(diff.Excluded))
+
+		result = append(result, CommitFilterDiff{Commit: c, FilterDiff: *diff})
+	}
+
+	return result, nil
+}
+
+func includedPaths(ctx context.Context, tree *object.Tree, filter Filter) ([]string, error) {
+	var paths []string
+	err := walkTree(ctx, nil, tree, filter, func(fullpath []string, e *object.TreeEntry) error {
+		if e.Mode == filemode.Submodule {
+			return nil
+		}
+		paths = append(paths, pathsToFullPath(fullpath))
+		return nil
+	})
+
+	return paths, err
+}
+
+// pathsNotIn returns the paths in a but not in b, keeping the order of a.
+func pathsNotIn(a []string, b []string) []string {
+	inb := make(map[string]struct{}, len(b))
+	for _, v := range b {
+		inb[v] = struct{}{}
+	}
+
+	var result []string
+	for _, v := range a {
+		if _, found := inb[v]; !found {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,183 @@
+package permgit_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func TestDiffFilters(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	tree := buildTestTree(t, s, map[string]string{
+		"README.md":     "a\n",
+		"src/a.go":      "b\n",
+		"src/b_test.go": "c\n",
+		"docs/x.md":     "d\n",
+		"internal/y.go": "e\n",
+	})
+
+	oldfilter, err := permgit.NewPatternTrieFilterForPatterns("/src/", "/internal/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newfilter, err := permgit.NewPatternTrieFilterForPatterns("/src/", "**/*.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := permgit.DiffFilters(ctx, tree, oldfilter, newfilter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"README.md", "docs/x.md"}; !slices.Equal(diff.Included, want) {
+		t.Errorf("want included %v, got %v", want, diff.Included)
+	}
+	if want := []string{"internal/y.go"}; !slices.Equal(diff.Excluded, want) {
+		t.Errorf("want excluded %v, got %v", want, diff.Excluded)
+	}
+
+	diff, err = permgit.DiffFilters(ctx, tree, newfilter, newfilter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.IsEmpty() {
+		t.Errorf("same filter gives diff %+v", diff)
+	}
+}
+
+// TestDiffFilters_treeFilter resolves the filters depending on the tree, and rejects those depending on the commit.
+func TestDiffFilters_treeFilter(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	tree := buildTestTree(t, s, map[string]string{
+		".permgit":      "/src/\n/docs/\n",
+		"src/a.go":      "a\n",
+		"docs/x.md":     "b\n",
+		"internal/y.go": "c\n",
+	})
+
+	fallback, err := permgit.NewPatternTrieFilterForPatterns("/internal/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rulesfile, err := permgit.NewRulesFileFilter("", fallback, permgit.RulesFilePolicy_Filter, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped := permgit.NewAndEntryFilter(rulesfile, permgit.NewModeFilter(filemode.Symlink))
+
+	for _, filter := range []permgit.Filter{rulesfile, wrapped} {
+		diff, err := permgit.DiffFilters(ctx, tree, fallback, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"docs/x.md", "src/a.go"}; !slices.Equal(diff.Included, want) {
+			t.Errorf("want included %v, got %v", want, diff.Included)
+		}
+		if want := []string{"internal/y.go"}; !slices.Equal(diff.Excluded, want) {
+			t.Errorf("want excluded %v, got %v", want, diff.Excluded)
+		}
+	}
+
+	// the filters not depending on the commit are returned as is.
+	if resolved, err := permgit.FilterForTree(ctx, fallback, tree); err != nil || resolved != permgit.Filter(fallback) {
+		t.Errorf("want the same filter, got %v %v", resolved, err)
+	}
+
+	// the schedule depends on the commit, and cannot be resolved for a tree.
+	schedule := permgit.NewFilterSchedule(fallback)
+	for _, filter := range []permgit.Filter{schedule, permgit.NewOrEntryFilter(schedule), permgit.NewGitAttributesFilter(schedule)} {
+		if _, err := permgit.DiffFilters(ctx, tree, fallback, filter); err == nil {
+			t.Errorf("%T should fail for a tree", filter)
+		}
+	}
+}
+
+// TestDiffFilters_submodule ignores the submodules, which are dropped by FilterTree.
+func TestDiffFilters_submodule(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	editor := permgit.NewTreeEditor(s, nil)
+	if err := editor.Apply(
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: ".gitmodules", Content: []byte(testGitModules)},
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: "vendor/a", Mode: filemode.Submodule, Hash: plumbing.NewHash("1111111111111111111111111111111111111111")},
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: "vendor/c.go", Content: []byte("c\n")},
+	); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := editor.Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := permgit.DiffFilters(ctx, tree, permgit.NewOrFilter(), permgit.NewTrueFilter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{".gitmodules", "vendor/c.go"}; !slices.Equal(diff.Included, want) {
+		t.Errorf("want included %v, got %v", want, diff.Included)
+	}
+}
+
+func TestDiffFiltersForCommits(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	files := map[string]string{"src/a.go": "a\n"}
+	var hist []*object.Commit
+	for i, path := range []string{"src/b.go", "docs/c.md", "src/d.go", "docs/e.md"} {
+		files[path] = fmt.Sprintf("%d\n", i)
+		sig := object.Signature{Name: "a", Email: "a@example.com", When: time.Unix(1700000000+int64(i)*86400, 0).UTC()}
+		c := &object.Commit{
+			Author:    sig,
+			Committer: sig,
+			Message:   fmt.Sprintf("commit %d\n", i),
+			TreeHash:  buildTestTree(t, s, files).Hash,
+		}
+		if i > 0 {
+			c.ParentHashes = []plumbing.Hash{hist[i-1].Hash}
+		}
+		hist = append(hist, saveTestCommit(t, s, c))
+	}
+
+	oldfilter, err := permgit.NewPatternFilter("/src/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new filter only adds docs from the third commit.
+	schedule := permgit.NewFilterSchedule(oldfilter)
+	schedule.AddFromCommit(hist[2].Hash, permgit.NewTrueFilter())
+
+	diffs, err := permgit.DiffFiltersForCommits(ctx, hist, oldfilter, schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 2 {
+		t.Fatalf("want 2 commits changed, got %d", len(diffs))
+	}
+	for i, v := range diffs {
+		if v.Commit.Hash != hist[i+2].Hash {
+			t.Errorf("want commit %s, got %s", hist[i+2].Hash, v.Commit.Hash)
+		}
+		if len(v.Excluded) != 0 {
+			t.Errorf("commit %s has excluded %v", v.Commit.Hash, v.Excluded)
+		}
+	}
+	if want := []string{"docs/c.md", "docs/e.md"}; !slices.Equal(diffs[1].Included, want) {
+		t.Errorf("want included %v, got %v", want, diffs[1].Included)
+	}
+}
@@ -0,0 +1,490 @@
+package permgit
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+)
+
+// MarshalFilter serializes the built-in filters into a canonical S-expression, which can be parsed back by [UnmarshalFilter].
+// The same filter always gives the same text, so it can be logged, hashed, and compared.
+//
+//	(true)
+//	(pattern "/src/" [ignore-case] [normalize-unicode])
+//	(and F...) (or F...) (cached F) (trie (pattern ...)...)
+//	(and-entry F...) (or-entry F...) (mode regular|executable|symlink|submodule...)
+//	(gitattributes F (exclude|include "attr")...)
+//	(schedule F (from-commit "hash" F)... (from-date "RFC 3339" F)...)
+//	(rules-file "path" filter|include|exclude [ignore-unsupported] [ignore-case] [normalize-unicode] F)
+//
+// Strings are quoted like Go strings. An error is returned for the filters that are not built-in, such as [EntryFilterFunc].
+func MarshalFilter(f Filter) (string, error) {
+	var b strings.Builder
+	if err := marshalFilter(&b, f); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+func marshalFilter(b *strings.Builder, f Filter) error {
+	switch v := f.(type) {
+	case *TrueFilter:
+		b.WriteString("(true)")
+	case *PatternFilter:
+		marshalPattern(b, v)
+	case *AndFilter:
+		return marshalList(b, "and", v.filters)
+	case *OrFilter:
+		return marshalList(b, "or", v.filters)
+	case *CachedFilter:
+		return marshalList(b, "cached", []Filter{v.filter})
+	case *PatternTrieFilter:
+		b.WriteString("(trie")
+		for _, p := range v.patterns {
+			b.WriteString(" ")
+			marshalPattern(b, p)
+		}
+		b.WriteString(")")
+	case pathEntryFilter:
+		return marshalFilter(b, v.filter)
+	case *AndEntryFilter:
+		return marshalEntryList(b, "and-entry", v.filters)
+	case *OrEntryFilter:
+		return marshalEntryList(b, "or-entry", v.filters)
+	case *ModeFilter:
+		b.WriteString("(mode")
+		for _, m := range v.excluded {
+			name, err := fileModeName(m)
+			if err != nil {
+				return err
+			}
+			b.WriteString(" " + name)
+		}
+		b.WriteString(")")
+	case *GitAttributesFilter:
+		b.WriteString("(gitattributes ")
+		if err := marshalFilter(b, v.base); err != nil {
+			return err
+		}
+		for _, r := range v.rules {
+			kind := "exclude"
+			if r.Include {
+				kind = "include"
+			}
+			fmt.Fprintf(b, " (%s %s)", kind, strconv.Quote(r.String()))
+		}
+		b.WriteString(")")
+	case *FilterSchedule:
+		b.WriteString("(schedule ")
+		if err := marshalFilter(b, v.initial); err != nil {
+			return err
+		}
+		hashes := make([]plumbing.Hash, 0, len(v.commits))
+		for h := range v.commits {
+			hashes = append(hashes, h)
+		}
+		slices.SortFunc(hashes, func(a, b plumbing.Hash) int { return strings.Compare(a.String(), b.String()) })
+		for _, h := range hashes {
+			fmt.Fprintf(b, " (from-commit %s ", strconv.Quote(h.String()))
+			if err := marshalFilter(b, v.commits[h]); err != nil {
+				return err
+			}
+			b.WriteString(")")
+		}
+		for _, d := range v.dates {
+			fmt.Fprintf(b, " (from-date %s ", strconv.Quote(d.since.UTC().Format(time.RFC3339Nano)))
+			if err := marshalFilter(b, d.filter); err != nil {
+				return err
+			}
+			b.WriteString(")")
+		}
+		b.WriteString(")")
+	case *RulesFileFilter:
+		fmt.Fprintf(b, "(rules-file %s %s ", strconv.Quote(v.path), v.policy)
+		if v.ignoreUnsupported {
+			b.WriteString("ignore-unsupported ")
+		}
+		if v.options.CaseInsensitive {
+			b.WriteString("ignore-case ")
+		}
+		if v.options.NormalizeUnicode {
+			b.WriteString("normalize-unicode ")
+		}
+		if err := marshalFilter(b, v.fallback); err != nil {
+			return err
+		}
+		b.WriteString(")")
+	default:
+		return fmt.Errorf("filter %T cannot be serialized", f)
+	}
+
+	return nil
+}
+
+func marshalPattern(b *strings.Builder, p *PatternFilter) {
+	b.WriteString("(pattern " + strconv.Quote(p.inputPattern))
+	if p.options.CaseInsensitive {
+		b.WriteString(" ignore-case")
+	}
+	if p.options.NormalizeUnicode {
+		b.WriteString(" normalize-unicode")
+	}
+	b.WriteString(")")
+}
+
+func marshalList(b *strings.Builder, name string, filters []Filter) error {
+	b.WriteString("(" + name)
+	for _, f := range filters {
+		b.WriteString(" ")
+		if err := marshalFilter(b, f); err != nil {
+			return err
+		}
+	}
+	b.WriteString(")")
+
+	return nil
+}
+
+func marshalEntryList(b *strings.Builder, name string, filters []EntryFilter) error {
+	r := make([]Filter, 0, len(filters))
+	for _, f := range filters {
+		r = append(r, f)
+	}
+
+	return marshalList(b, name, r)
+}
+
+// fileModeName is the inverse of [ParseFileMode].
+func fileModeName(m filemode.FileMode) (string, error) {
+	switch m {
+	case filemode.Regular:
+		return "regular", nil
+	case filemode.Executable:
+		return "executable", nil
+	case filemode.Symlink:
+		return "symlink", nil
+	case filemode.Submodule:
+		return "submodule", nil
+	default:
+		return "", fmt.Errorf("file mode %s cannot be serialized", m)
+	}
+}
+
+// filterExpr is a parsed S-expression, the arguments are either *filterExpr, quoted strings as filterString, or atoms as string.
+type filterExpr struct {
+	name string
+	args []any
+}
+
+type filterString string
+
+// UnmarshalFilter parses the S-expression from [MarshalFilter] into the filter.
+func UnmarshalFilter(s string) (Filter, error) {
+	p := &filterExprParser{input: s}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos != len(p.input) {
+		return nil, fmt.Errorf("unexpected trailing content at %d", p.pos)
+	}
+
+	return e.build()
+}
+
+type filterExprParser struct {
+	input string
+	pos   int
+}
+
+func (p *filterExprParser) skipSpaces() {
+	for p.pos < len(p.input) && strings.ContainsRune(" \t\r\n", rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *filterExprParser) parseExpr() (*filterExpr, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.input) || p.input[p.pos] != '(' {
+		return nil, fmt.Errorf("expecting ( at %d", p.pos)
+	}
+	p.pos++
+
+	p.skipSpaces()
+	e := &filterExpr{name: p.parseAtom()}
+	if e.name == "" {
+		return nil, fmt.Errorf("expecting filter name at %d", p.pos)
+	}
+
+	for {
+		p.skipSpaces()
+		if p.pos >= len(p.input) {
+			return nil, fmt.Errorf("unexpected end of input, expecting )")
+		}
+		switch p.input[p.pos] {
+		case ')':
+			p.pos++
+			return e, nil
+		case '(':
+			sub, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			e.args = append(e.args, sub)
+		case '"':
+			quoted, err := strconv.QuotedPrefix(p.input[p.pos:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %w", p.pos, err)
+			}
+			p.pos += len(quoted)
+			str, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %w", p.pos, err)
+			}
+			e.args = append(e.args, filterString(str))
+		default:
+			atom := p.parseAtom()
+			if atom == "" {
+				return nil, fmt.Errorf("unexpected character %q at %d", p.input[p.pos], p.pos)
+			}
+			e.args = append(e.args, atom)
+		}
+	}
+}
+
+func (p *filterExprParser) parseAtom() string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			break
+		}
+		p.pos++
+	}
+
+	return p.input[start:p.pos]
+}
+
+func (e *filterExpr) build() (Filter, error) {
+	switch e.name {
+	case "true":
+		if len(e.args) != 0 {
+			return nil, fmt.Errorf("true takes no arguments")
+		}
+		return NewTrueFilter(), nil
+	case "pattern":
+		return e.buildPattern()
+	case "and", "or", "and-entry", "or-entry", "cached":
+		filters, err := buildFilters(e.args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.name, err)
+		}
+		switch e.name {
+		case "and":
+			return NewAndFilter(filters...), nil
+		case "or":
+			return NewOrFilter(filters...), nil
+		case "and-entry":
+			return NewAndEntryFilter(filters...), nil
+		case "or-entry":
+			return NewOrEntryFilter(filters...), nil
+		}
+		if len(filters) != 1 {
+			return nil, fmt.Errorf("cached takes one filter")
+		}
+		return NewCachedFilter(filters[0]), nil
+	case "trie":
+		patterns := make([]*PatternFilter, 0, len(e.args))
+		for _, arg := range e.args {
+			sub, ok := arg.(*filterExpr)
+			if !ok || sub.name != "pattern" {
+				return nil, fmt.Errorf("trie takes patterns")
+			}
+			p, err := sub.buildPattern()
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, p)
+		}
+		return NewPatternTrieFilter(patterns...), nil
+	case "mode":
+		modes := make([]filemode.FileMode, 0, len(e.args))
+		for _, arg := range e.args {
+			name, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("mode takes names of file modes")
+			}
+			m, err := ParseFileMode(name)
+			if err != nil {
+				return nil, err
+			}
+			modes = append(modes, m)
+		}
+		return NewModeFilter(modes...), nil
+	case "gitattributes":
+		return e.buildGitAttributes()
+	case "schedule":
+		return e.buildSchedule()
+	case "rules-file":
+		return e.buildRulesFile()
+	default:
+		return nil, fmt.Errorf("unknown filter: %s", e.name)
+	}
+}
+
+func buildFilters(args []any) ([]Filter, error) {
+	filters := make([]Filter, 0, len(args))
+	for _, arg := range args {
+		sub, ok := arg.(*filterExpr)
+		if !ok {
+			return nil, fmt.Errorf("expecting filter, got %v", arg)
+		}
+		f, err := sub.build()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+
+	return filters, nil
+}
+
+func (e *filterExpr) buildPattern() (*PatternFilter, error) {
+	if len(e.args) == 0 {
+		return nil, fmt.Errorf("pattern takes a string")
+	}
+	pattern, ok := e.args[0].(filterString)
+	if !ok {
+		return nil, fmt.Errorf("pattern takes a string")
+	}
+	opts := &PatternFilterOptions{}
+	for _, arg := range e.args[1:] {
+		switch arg {
+		case "ignore-case":
+			opts.CaseInsensitive = true
+		case "normalize-unicode":
+			opts.NormalizeUnicode = true
+		default:
+			return nil, fmt.Errorf("unknown pattern option: %v", arg)
+		}
+	}
+
+	return NewPatternFilterWithOptions(string(pattern), opts)
+}
+
+func (e *filterExpr) buildGitAttributes() (Filter, error) {
+	if len(e.args) == 0 {
+		return nil, fmt.Errorf("gitattributes takes a base filter")
+	}
+	base, err := buildFilters(e.args[:1])
+	if err != nil {
+		return nil, fmt.Errorf("gitattributes: %w", err)
+	}
+
+	rules := make([]GitAttributeRule, 0, len(e.args)-1)
+	for _, arg := range e.args[1:] {
+		sub, ok := arg.(*filterExpr)
+		if !ok || (sub.name != "exclude" && sub.name != "include") || len(sub.args) != 1 {
+			return nil, fmt.Errorf("gitattributes takes exclude or include rules")
+		}
+		text, ok := sub.args[0].(filterString)
+		if !ok {
+			return nil, fmt.Errorf("gitattributes rule takes a string")
+		}
+		r, err := ParseGitAttributeRule(string(text), sub.name == "include")
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	return NewGitAttributesFilter(base[0], rules...), nil
+}
+
+func (e *filterExpr) buildSchedule() (Filter, error) {
+	if len(e.args) == 0 {
+		return nil, fmt.Errorf("schedule takes an initial filter")
+	}
+	initial, err := buildFilters(e.args[:1])
+	if err != nil {
+		return nil, fmt.Errorf("schedule: %w", err)
+	}
+
+	s := NewFilterSchedule(initial[0])
+	for _, arg := range e.args[1:] {
+		sub, ok := arg.(*filterExpr)
+		if !ok || (sub.name != "from-commit" && sub.name != "from-date") || len(sub.args) != 2 {
+			return nil, fmt.Errorf("schedule takes from-commit or from-date entries")
+		}
+		key, ok := sub.args[0].(filterString)
+		if !ok {
+			return nil, fmt.Errorf("%s takes a string", sub.name)
+		}
+		filter, err := buildFilters(sub.args[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sub.name, err)
+		}
+
+		if sub.name == "from-commit" {
+			if !plumbing.IsHash(string(key)) {
+				return nil, fmt.Errorf("invalid commit hash: %s", key)
+			}
+			s.AddFromCommit(plumbing.NewHash(string(key)), filter[0])
+			continue
+		}
+		since, err := time.Parse(time.RFC3339Nano, string(key))
+		if err != nil {
+			return nil, err
+		}
+		s.AddFromDate(since, filter[0])
+	}
+
+	return s, nil
+}
+
+func (e *filterExpr) buildRulesFile() (Filter, error) {
+	if len(e.args) < 3 {
+		return nil, fmt.Errorf("rules-file takes a path, a policy, and a fallback filter")
+	}
+	path, ok := e.args[0].(filterString)
+	if !ok {
+		return nil, fmt.Errorf("rules-file takes a path")
+	}
+	policy, ok := e.args[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("rules-file takes a policy")
+	}
+	ignoreUnsupported := false
+	opts := &PatternFilterOptions{}
+	rest := e.args[2:]
+options:
+	for len(rest) > 0 {
+		switch rest[0] {
+		case "ignore-unsupported":
+			ignoreUnsupported = true
+		case "ignore-case":
+			opts.CaseInsensitive = true
+		case "normalize-unicode":
+			opts.NormalizeUnicode = true
+		default:
+			break options
+		}
+		rest = rest[1:]
+	}
+	if len(rest) != 1 {
+		return nil, fmt.Errorf("rules-file takes one fallback filter")
+	}
+	fallback, err := buildFilters(rest)
+	if err != nil {
+		return nil, fmt.Errorf("rules-file: %w", err)
+	}
+
+	return NewRulesFileFilter(string(path), fallback[0], RulesFilePolicy(policy), ignoreUnsupported, opts)
+}
@@ -0,0 +1,153 @@
+package permgit_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func mustPatternFilter(t *testing.T, pattern string, opts *permgit.PatternFilterOptions) *permgit.PatternFilter {
+	t.Helper()
+
+	f, err := permgit.NewPatternFilterWithOptions(pattern, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func TestMarshalFilter(t *testing.T) {
+	src := mustPatternFilter(t, "/src/", nil)
+	docs := mustPatternFilter(t, "docs/**/*.MD", &permgit.PatternFilterOptions{CaseInsensitive: true, NormalizeUnicode: true})
+	quoted := mustPatternFilter(t, `/a "b"/c`, nil)
+
+	rulesfile, err := permgit.NewRulesFileFilter("", src, permgit.RulesFilePolicy_Exclude, true, &permgit.PatternFilterOptions{CaseInsensitive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule := permgit.NewFilterSchedule(src)
+	schedule.AddFromDate(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), permgit.NewTrueFilter())
+	schedule.AddFromCommit(plumbing.NewHash("0123456789abcdef0123456789abcdef01234567"), docs)
+
+	for _, tc := range []struct {
+		filter permgit.Filter
+		want   string
+	}{
+		{permgit.NewTrueFilter(), `(true)`},
+		{src, `(pattern "/src/")`},
+		{docs, `(pattern "docs/**/*.md" ignore-case normalize-unicode)`},
+		{quoted, `(pattern "/a \"b\"/c")`},
+		{permgit.NewAndFilter(src, permgit.NewOrFilter(docs, quoted)), `(and (pattern "/src/") (or (pattern "docs/**/*.md" ignore-case normalize-unicode) (pattern "/a \"b\"/c")))`},
+		{permgit.NewCachedFilter(permgit.NewPatternTrieFilter(src, quoted)), `(cached (trie (pattern "/src/") (pattern "/a \"b\"/c")))`},
+		{permgit.NewAndEntryFilter(src, permgit.NewModeFilter(filemode.Symlink, filemode.Submodule)), `(and-entry (pattern "/src/") (mode symlink submodule))`},
+		{permgit.NewOrEntryFilter(), `(or-entry)`},
+		{
+			permgit.NewGitAttributesFilter(src, permgit.GitAttributeRule{Attribute: "export-ignore"}, permgit.GitAttributeRule{Attribute: "publish", Value: "true", Include: true}),
+			`(gitattributes (pattern "/src/") (exclude "export-ignore") (include "publish=true"))`,
+		},
+		{
+			schedule,
+			`(schedule (pattern "/src/") (from-commit "0123456789abcdef0123456789abcdef01234567" (pattern "docs/**/*.md" ignore-case normalize-unicode)) (from-date "2024-01-02T03:04:05Z" (true)))`,
+		},
+		{rulesfile, `(rules-file ".permgit" exclude ignore-unsupported ignore-case (pattern "/src/"))`},
+	} {
+		got, err := permgit.MarshalFilter(tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("want %s, got %s", tc.want, got)
+		}
+
+		parsed, err := permgit.UnmarshalFilter(got)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", got, err)
+		}
+		again, err := permgit.MarshalFilter(parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again != got {
+			t.Errorf("round trip of %s gives %s", got, again)
+		}
+	}
+
+	if _, err := permgit.MarshalFilter(permgit.EntryFilterFunc(func([]string, *object.TreeEntry) permgit.FilterResult { return permgit.FilterResult_In })); err == nil {
+		t.Errorf("EntryFilterFunc should not be serialized")
+	}
+
+	for _, text := range []string{"", "(", "(true", "(true) (true)", "(unknown)", "(pattern)", "(cached (true) (true))", `(pattern "/a" bad-option)`, "(mode deprecated)"} {
+		if _, err := permgit.UnmarshalFilter(text); err == nil {
+			t.Errorf("%q should fail", text)
+		}
+	}
+}
+
+func TestRecordFilter(t *testing.T) {
+	s := memory.NewStorage()
+
+	filter, err := permgit.NewPatternTrieFilterForPatterns("/src/", "*.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := permgit.RecordFilter(s, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := s.Reference(permgit.FilterRefName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.Hash() != hash {
+		t.Errorf("want ref at %s, got %s", hash, ref.Hash())
+	}
+
+	loaded, text, err := permgit.LoadRecordedFilter(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `(trie (pattern "/src/") (pattern "*.md"))`; text != want {
+		t.Errorf("want %s, got %s", want, text)
+	}
+	if r := loaded.Filter([]string{"src", "a.go"}, false); r != permgit.FilterResult_In {
+		t.Errorf("loaded filter gives %s", r)
+	}
+	if options, err := permgit.LoadRecordedOptions(s); err != nil || len(options) != 0 {
+		t.Errorf("want no options, got %v %v", options, err)
+	}
+
+	// the options are recorded after the filter.
+	options := []permgit.RecordedOption{
+		{Name: "max-blob-size", Value: "100M"},
+		{Name: "transform-rules", Value: "sha256:0123"},
+		{Name: "submodule-url", Value: "https://a.example.com/x.git=https://b.example.com/x with space.git"},
+	}
+	if _, err := permgit.RecordFilterWithOptions(s, filter, options); err != nil {
+		t.Fatal(err)
+	}
+	if _, again, err := permgit.LoadRecordedFilter(s); err != nil || again != text {
+		t.Errorf("want filter %s, got %s %v", text, again, err)
+	}
+	loadedOptions, err := permgit.LoadRecordedOptions(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(loadedOptions, options) {
+		t.Errorf("want options %v, got %v", options, loadedOptions)
+	}
+
+	for _, o := range []permgit.RecordedOption{{Name: ""}, {Name: "a b", Value: "c"}, {Name: "a", Value: "b\nc"}} {
+		if _, err := permgit.RecordFilterWithOptions(s, filter, []permgit.RecordedOption{o}); err == nil {
+			t.Errorf("option %q %q should fail", o.Name, o.Value)
+		}
+	}
+}
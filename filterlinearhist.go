@@ -22,11 +22,23 @@ import (
 // but will parent correctly linked and gpg sign information dropped.
 //
 // The input commits can be obtained from [GetLinearHistory].
+// Use a [FilterSchedule] to change the filter over the history.
 func FilterLinearHistory(
 	ctx context.Context,
 	hist []*object.Commit,
 	s storer.Storer,
 	filter Filter,
+) ([]*object.Commit, error) {
+	return FilterLinearHistoryWithOptions(ctx, hist, s, filter, nil)
+}
+
+// FilterLinearHistoryWithOptions is [FilterLinearHistory] with [FilterOptions], nil opts is the same as a zero [FilterOptions].
+func FilterLinearHistoryWithOptions(
+	ctx context.Context,
+	hist []*object.Commit,
+	s storer.Storer,
+	filter Filter,
+	opts *FilterOptions,
 ) ([]*object.Commit, error) {
 	newhist := make([]*object.Commit, 0, len(hist))
 
@@ -38,7 +50,7 @@ func FilterLinearHistory(
 			return nil, ctx.Err()
 		default:
 		}
-		newcommit, err := FilterCommit(ctx, v, prevCommit, s, filter)
+		newcommit, err := FilterCommitWithOptions(ctx, v, prevCommit, s, filter, opts)
 		if err != nil {
 			return nil, errorf(err, "failed to generate commit at %d for commit %s: %w ", i, v.Hash, err)
 		}
@@ -63,5 +75,11 @@ func FilterLinearHistory(
 		prevCommit = newcommit
 	}
 
+	if opts != nil {
+		if err := opts.SecretScanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
 	return newhist, nil
 }
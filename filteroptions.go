@@ -0,0 +1,34 @@
+package permgit
+
+// FilterOptions contains the options for [FilterTreeWithOptions], [FilterCommitWithOptions], and [FilterLinearHistoryWithOptions].
+// The zero value keeps the default behavior.
+type FilterOptions struct {
+	// KeepSubmodules keeps the submodules (gitlink entries) included by the filter, which are dropped by default.
+	// The sections of .gitmodules at the root of the tree are filtered to the kept submodules,
+	// and .gitmodules is included in the filtered tree if any submodule is kept, regardless of the filter.
+	KeepSubmodules bool
+	// SubmoduleURLMap rewrites the urls of the kept submodules in .gitmodules, the key is the original url and the value is the new url.
+	// Urls not in the map are kept as is.
+	SubmoduleURLMap map[string]string
+
+	// BlobTransformers transforms the content of the files included by the filter, for example to redact internal information.
+	// The same [BlobTransformers] should be used for all the commits of a history to reuse the transformed blobs.
+	BlobTransformers *BlobTransformers
+	// BlobSizeLimit drops, fails on, or converts to Git LFS pointers the blobs larger than the limit, which is applied after BlobTransformers,
+	// so the content written into the lfs store is transformed. The content written into the lfs store is scanned by SecretScanner,
+	// while the dropped blobs are never published and not scanned.
+	// The same [BlobSizeLimit] should be used for all the commits of a history to reuse the converted blobs.
+	BlobSizeLimit *BlobSizeLimit
+	// SymlinkPolicy is applied by [FilterCommitWithOptions] to the symlinks escaping the repo or pointing at excluded paths, see [ValidateSymlinks].
+	// Empty value disables the validation.
+	SymlinkPolicy SymlinkPolicy
+	// SecretScanner scans the blobs included in the filtered tree, even if they are already in the storer, and those written into the lfs store.
+	// [FilterLinearHistoryWithOptions] fails with [SecretsFoundError] if any secret is found.
+	SecretScanner *SecretScanner
+	// MessageRewriter rewrites the messages of the filtered commits.
+	MessageRewriter *MessageRewriter
+	// Mailmap maps the authors and committers of the filtered commits.
+	Mailmap *Mailmap
+	// Signer signs the filtered commits.
+	Signer Signer
+}
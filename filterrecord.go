@@ -0,0 +1,109 @@
+package permgit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// FilterRefName is the reference in the filtered repo pointing at the blob of the serialized filter recorded by [RecordFilter].
+const FilterRefName plumbing.ReferenceName = "refs/permgit/filter"
+
+// RecordedOption is a setting besides the filter that affects the filtered repo, recorded by [RecordFilterWithOptions],
+// for example the size limit, or the content hash of a rules file.
+type RecordedOption struct {
+	// Name is the name of the option, which cannot contain white spaces.
+	Name string
+	// Value is the value of the option, which cannot contain new lines.
+	Value string
+}
+
+// RecordFilter serializes the filter with [MarshalFilter], writes it as a blob into the storer, and points [FilterRefName] at it,
+// so the filter used to produce the filtered repo can be loaded by [LoadRecordedFilter].
+// The hash of the blob is returned, which identifies the filter.
+//
+// The filtered repo also depends on the [FilterOptions], see [RecordFilterWithOptions] to record them next to the filter.
+func RecordFilter(s storer.Storer, f Filter) (plumbing.Hash, error) {
+	return RecordFilterWithOptions(s, f, nil)
+}
+
+// RecordFilterWithOptions is [RecordFilter] with the options recorded after the filter, one per line in the order given,
+// so the filtered repo can be reproduced with the filter and the same options. The options are loaded by [LoadRecordedOptions].
+func RecordFilterWithOptions(s storer.Storer, f Filter, options []RecordedOption) (plumbing.Hash, error) {
+	text, err := MarshalFilter(f)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to serialize filter: %w", err)
+	}
+
+	var b strings.Builder
+	b.WriteString(text + "\n")
+	for _, o := range options {
+		if o.Name == "" || strings.ContainsAny(o.Name, " \t\r\n") || strings.ContainsAny(o.Value, "\r\n") {
+			return plumbing.ZeroHash, fmt.Errorf("invalid recorded option %q: %q", o.Name, o.Value)
+		}
+		fmt.Fprintf(&b, "%s %s\n", o.Name, o.Value)
+	}
+
+	hash, err := saveBlob(s, []byte(b.String()))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to save filter: %w", err)
+	}
+
+	if err := s.SetReference(plumbing.NewHashReference(FilterRefName, hash)); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to set %s: %w", FilterRefName, err)
+	}
+
+	return hash, nil
+}
+
+// readRecordedFilter reads the lines of the blob recorded by [RecordFilterWithOptions].
+func readRecordedFilter(s storer.Storer) (plumbing.Hash, []string, error) {
+	ref, err := s.Reference(FilterRefName)
+	if err != nil {
+		return plumbing.ZeroHash, nil, fmt.Errorf("failed to obtain %s: %w", FilterRefName, err)
+	}
+
+	content, err := readBlobContent(s, ref.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	return ref.Hash(), strings.Split(strings.TrimSuffix(string(content), "\n"), "\n"), nil
+}
+
+// LoadRecordedFilter reads the filter recorded by [RecordFilter], and returns it with its serialized text.
+func LoadRecordedFilter(s storer.Storer) (Filter, string, error) {
+	hash, lines, err := readRecordedFilter(s)
+	if err != nil {
+		return nil, "", err
+	}
+	text := lines[0]
+
+	f, err := UnmarshalFilter(text)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse recorded filter %s: %w", hash, err)
+	}
+
+	return f, text, nil
+}
+
+// LoadRecordedOptions reads the options recorded after the filter by [RecordFilterWithOptions].
+func LoadRecordedOptions(s storer.Storer) ([]RecordedOption, error) {
+	hash, lines, err := readRecordedFilter(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []RecordedOption
+	for _, l := range lines[1:] {
+		name, value, found := strings.Cut(l, " ")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid option %q in recorded filter %s", l, hash)
+		}
+		options = append(options, RecordedOption{Name: name, Value: value})
+	}
+
+	return options, nil
+}
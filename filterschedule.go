@@ -0,0 +1,183 @@
+package permgit
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// FilterSchedule is a [CommitFilter] changing the filter over the history, for example when a directory becomes public.
+//
+// A filter added by [FilterSchedule.AddFromCommit] applies to the commit and the commits following it on the first-parent history,
+// the nearest one in the first-parent ancestry of the commit is used.
+// A filter added by [FilterSchedule.AddFromDate] applies to the commits with the committer date not before the date,
+// the latest one is used. Filters from commits take precedence over the filters from dates,
+// and the initial filter is used if neither applies.
+//
+// The filter for a commit only depends on the commit and its ancestry, so the output is deterministic regardless of the order the commits are filtered.
+// The first-parent ancestry is memoized, the memo is not concurrent safe.
+type FilterSchedule struct {
+	initial Filter
+	commits map[plumbing.Hash]Filter
+	dates   []scheduledFilter
+
+	// memo is the filter from commits for the commits already seen, nil if none applies.
+	memo map[plumbing.Hash]Filter
+}
+
+type scheduledFilter struct {
+	since  time.Time
+	filter Filter
+}
+
+var (
+	_ EntryFilter  = (*FilterSchedule)(nil)
+	_ CommitFilter = (*FilterSchedule)(nil)
+)
+
+// NewFilterSchedule creates a new [FilterSchedule] with the initial filter.
+func NewFilterSchedule(initial Filter) *FilterSchedule {
+	return &FilterSchedule{
+		initial: initial,
+		commits: make(map[plumbing.Hash]Filter),
+		memo:    make(map[plumbing.Hash]Filter),
+	}
+}
+
+// AddFromCommit uses the filter from the commit with the hash.
+func (s *FilterSchedule) AddFromCommit(hash plumbing.Hash, filter Filter) {
+	s.commits[hash] = filter
+	clear(s.memo)
+}
+
+// AddFromDate uses the filter from the commits with committer date not before since.
+func (s *FilterSchedule) AddFromDate(since time.Time, filter Filter) {
+	s.dates = append(s.dates, scheduledFilter{since: since, filter: filter})
+	slices.SortStableFunc(s.dates, func(a, b scheduledFilter) int { return a.since.Compare(b.since) })
+}
+
+// Filter applies the initial filter, since the commit is not known.
+func (s *FilterSchedule) Filter(paths []string, isdir bool) FilterResult {
+	return s.initial.Filter(paths, isdir)
+}
+
+// FilterEntry applies the initial filter, since the commit is not known.
+func (s *FilterSchedule) FilterEntry(paths []string, entry *object.TreeEntry) FilterResult {
+	return AsEntryFilter(s.initial).FilterEntry(paths, entry)
+}
+
+// FilterForCommit returns the filter scheduled for the commit, resolved for the commit if it is a [CommitFilter].
+func (s *FilterSchedule) FilterForCommit(ctx context.Context, c *object.Commit) (Filter, error) {
+	filter, err := s.fromCommits(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if filter == nil {
+		filter = s.initial
+		for _, v := range s.dates {
+			if v.since.After(c.Committer.When) {
+				break
+			}
+			filter = v.filter
+		}
+	}
+
+	return filterForCommit(ctx, filter, c)
+}
+
+// fromCommits finds the filter from the nearest scheduled commit in the first-parent ancestry of the commit.
+func (s *FilterSchedule) fromCommits(ctx context.Context, c *object.Commit) (Filter, error) {
+	if len(s.commits) == 0 {
+		return nil, nil
+	}
+
+	var found Filter
+	var chain []plumbing.Hash
+	for cur := c; ; {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		if f, ok := s.commits[cur.Hash]; ok {
+			found = f
+			break
+		}
+		if f, ok := s.memo[cur.Hash]; ok {
+			found = f
+			break
+		}
+		chain = append(chain, cur.Hash)
+		if cur.NumParents() == 0 {
+			break
+		}
+
+		parent, err := cur.Parent(0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain first parent of commit %s: %w", cur.Hash, err)
+		}
+		cur = parent
+	}
+
+	for _, h := range chain {
+		s.memo[h] = found
+	}
+
+	return found, nil
+}
+
+// FilterScheduleEntryConfig is the json representation of an entry of [FilterSchedule], one of Commit and Since must be set.
+type FilterScheduleEntryConfig struct {
+	// Commit is the hash of the commit the filter applies from.
+	Commit string `json:"commit,omitempty"`
+	// Since is the committer date in RFC 3339 the filter applies from.
+	Since string `json:"since,omitempty"`
+	// Patterns are the patterns of the filter, see [NewPatternTrieFilterForPatternsWithOptions].
+	Patterns []string `json:"patterns"`
+}
+
+// LoadFilterSchedule parses a json array of [FilterScheduleEntryConfig] into a [FilterSchedule] with the initial filter.
+// The patterns are compiled with opts, and nil opts is the same as a zero [PatternFilterOptions].
+func LoadFilterSchedule(content []byte, initial Filter, opts *PatternFilterOptions) (*FilterSchedule, error) {
+	var configs []FilterScheduleEntryConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse filter schedule: %w", err)
+	}
+
+	s := NewFilterSchedule(initial)
+	for i, c := range configs {
+		if len(c.Patterns) == 0 {
+			return nil, fmt.Errorf("invalid filter schedule entry %d: patterns cannot be empty", i)
+		}
+		filter, err := NewPatternTrieFilterForPatternsWithOptions(c.Patterns, opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter schedule entry %d: %w", i, err)
+		}
+
+		switch {
+		case c.Commit != "" && c.Since == "":
+			if _, err := hex.DecodeString(c.Commit); err != nil || len(c.Commit) != 40 {
+				return nil, fmt.Errorf("invalid filter schedule entry %d: invalid commit hash %s", i, c.Commit)
+			}
+			s.AddFromCommit(plumbing.NewHash(c.Commit), filter)
+		case c.Since != "" && c.Commit == "":
+			since, err := time.Parse(time.RFC3339, c.Since)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter schedule entry %d: %w", i, err)
+			}
+			s.AddFromDate(since, filter)
+		default:
+			return nil, fmt.Errorf("invalid filter schedule entry %d: requires one of commit and since", i)
+		}
+	}
+
+	return s, nil
+}
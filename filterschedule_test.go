@@ -0,0 +1,113 @@
+package permgit_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func TestFilterSchedule(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	files := map[string]string{"pub/a": "a\n", "later/b": "b\n"}
+	var hist []*object.Commit
+	for i := 0; i < 4; i++ {
+		files[fmt.Sprintf("later/%d", i)] = fmt.Sprintf("%d\n", i)
+		sig := object.Signature{Name: "a", Email: "a@example.com", When: time.Unix(1700000000+int64(i)*86400, 0).UTC()}
+		c := &object.Commit{
+			Author:    sig,
+			Committer: sig,
+			Message:   fmt.Sprintf("commit %d\n", i),
+			TreeHash:  buildTestTree(t, s, files).Hash,
+		}
+		if i > 0 {
+			c.ParentHashes = []plumbing.Hash{hist[i-1].Hash}
+		}
+		hist = append(hist, saveTestCommit(t, s, c))
+	}
+
+	initial, err := permgit.NewPatternFilter("pub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkHist := func(t *testing.T, filter permgit.Filter, want []int) {
+		t.Helper()
+
+		newhist, err := permgit.FilterLinearHistory(ctx, hist, s, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, c := range newhist {
+			tree, err := object.GetTree(s, c.TreeHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, len(readTestTree(t, tree)))
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("want number of files %v, got %v", want, got)
+		}
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		schedule := permgit.NewFilterSchedule(initial)
+		schedule.AddFromCommit(hist[2].Hash, permgit.NewTrueFilter())
+
+		checkHist(t, schedule, []int{1, 5, 6})
+
+		// the filter of the commits only depends on their ancestry.
+		newcommit, err := permgit.FilterCommit(ctx, hist[3], nil, s, schedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if newcommit.TreeHash != hist[3].TreeHash {
+			t.Errorf("commit after the scheduled commit is not fully included")
+		}
+	})
+
+	t.Run("date", func(t *testing.T) {
+		config := fmt.Sprintf(`[{"since": %q, "patterns": ["later/"]}, {"since": %q, "patterns": ["pub/", "later/"]}]`,
+			hist[1].Committer.When.Format(time.RFC3339),
+			hist[3].Committer.When.Format(time.RFC3339),
+		)
+		schedule, err := permgit.LoadFilterSchedule([]byte(config), initial, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkHist(t, schedule, []int{1, 3, 4, 6})
+	})
+
+	t.Run("options", func(t *testing.T) {
+		config := fmt.Sprintf(`[{"since": %q, "patterns": ["LATER/"]}]`, hist[0].Committer.When.Format(time.RFC3339))
+		schedule, err := permgit.LoadFilterSchedule([]byte(config), initial, &permgit.PatternFilterOptions{CaseInsensitive: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkHist(t, schedule, []int{2, 3, 4, 5})
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, config := range []string{
+			`[{"patterns": ["a"]}]`,
+			`[{"commit": "1234", "patterns": ["a"]}]`,
+			`[{"since": "2024-01-01T00:00:00Z"}]`,
+		} {
+			if _, err := permgit.LoadFilterSchedule([]byte(config), initial, nil); err == nil {
+				t.Errorf("%s should fail", config)
+			}
+		}
+	})
+}
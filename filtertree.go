@@ -3,6 +3,7 @@ package permgit
 import (
 	"context"
 	"fmt"
+	"slices"
 
 	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
@@ -12,7 +13,7 @@ import (
 // FilterTree filters the entries of the tree by the filter and stores it in the given [storer.Storer].
 // If after filtering the tree is empty, nil will be returned for the tree and the error.
 //
-// Note: Submodules will be silently ignored.
+// Note: Submodules will be silently ignored. See [FilterTreeWithOptions] to keep them.
 func FilterTree(
 	ctx context.Context,
 	t *object.Tree,
@@ -20,6 +21,29 @@ func FilterTree(
 	s storer.Storer,
 	filter Filter,
 ) (*object.Tree, error) {
+	return FilterTreeWithOptions(ctx, t, prepath, s, filter, nil)
+}
+
+// FilterTreeWithOptions filters the entries of the tree by the filter and stores it in the given [storer.Storer].
+// If after filtering the tree is empty, nil will be returned for the tree and the error.
+// Nil opts is the same as a zero [FilterOptions].
+// If the filter is an [EntryFilter], the entries are filtered by their modes and hashes as well.
+//
+// If submodules are kept, the .gitmodules file is only processed when prepath is empty, i.e. t is the root of the repo.
+func FilterTreeWithOptions(
+	ctx context.Context,
+	t *object.Tree,
+	prepath []string,
+	s storer.Storer,
+	filter Filter,
+	opts *FilterOptions,
+) (*object.Tree, error) {
+	if opts == nil {
+		opts = &FilterOptions{}
+	}
+
+	isroot := len(prepath) == 0
+	entryfilter := AsEntryFilter(filter)
 	newEntries := make([]object.TreeEntry, 0, len(t.Entries))
 
 	for _, e := range t.Entries {
@@ -32,9 +56,14 @@ func FilterTree(
 		fullname := addpath(prepath, e.Name)
 		fullnamestring := pathsToFullPath(fullname)
 
+		if isroot && opts.KeepSubmodules && e.Name == GitModulesFile {
+			// .gitmodules is regenerated from the kept submodules below.
+			continue
+		}
+
 		switch e.Mode {
 		case filemode.Deprecated, filemode.Executable, filemode.Regular, filemode.Symlink:
-			if !filter.Filter(fullname, false).IsIn() {
+			if !entryfilter.FilterEntry(fullname, &e).IsIn() {
 				continue
 			}
 			entryToAdd := e
@@ -46,6 +75,53 @@ func FilterTree(
 					err)
 			}
 
+			// transform the content before the size limit, so the content written into the lfs store is redacted too.
+			newhash, transformed, err := opts.BlobTransformers.transform(s, fullname, file.Hash, fileContent(file))
+			if err != nil {
+				return nil, errorf(err, "failed to transform %s: %w", fullnamestring, err)
+			}
+			if transformed {
+				size, err := s.EncodedObjectSize(newhash)
+				if err != nil {
+					return nil, fmt.Errorf("failed to obtain size of transformed %s: %w", fullnamestring, err)
+				}
+				limitedhash, keep, err := opts.BlobSizeLimit.limit(s, fullname, newhash, size, blobReader(s, newhash))
+				if err != nil {
+					return nil, err
+				}
+				if !keep {
+					continue
+				}
+				// the transformed content is published either in the tree or in the lfs store.
+				err = opts.SecretScanner.scan(fullname, newhash, func() ([]byte, error) {
+					return readBlobContent(s, newhash)
+				})
+				if err != nil {
+					return nil, err
+				}
+				entryToAdd.Hash = limitedhash
+				newEntries = append(newEntries, entryToAdd)
+				continue
+			}
+
+			limitedhash, keep, err := opts.BlobSizeLimit.limit(s, fullname, file.Hash, file.Size, file.Reader)
+			if err != nil {
+				return nil, err
+			}
+			if !keep {
+				// the dropped file is never published, and not scanned.
+				continue
+			}
+			if limitedhash != file.Hash {
+				// the file is converted to a lfs pointer, and the content in the lfs store is scanned.
+				if err := opts.SecretScanner.scan(fullname, file.Hash, fileContent(file)); err != nil {
+					return nil, err
+				}
+				entryToAdd.Hash = limitedhash
+				newEntries = append(newEntries, entryToAdd)
+				continue
+			}
+
 			haserr := s.HasEncodedObject(file.Hash)
 			if haserr != nil {
 				if err := updateHashAndSave(ctx, file, s); err != nil {
@@ -57,10 +133,21 @@ func FilterTree(
 						err)
 				}
 			}
+			// the blob is scanned even if it is already in the storer, which may be left by a previous failed run.
+			if err := opts.SecretScanner.scan(fullname, file.Hash, fileContent(file)); err != nil {
+				return nil, err
+			}
 			newEntries = append(newEntries, entryToAdd)
 		case filemode.Submodule:
-			logger.Warn("ignoring submodule", "path", fullnamestring)
-			continue
+			if !opts.KeepSubmodules {
+				logger.Warn("ignoring submodule", "path", fullnamestring)
+				continue
+			}
+			if !entryfilter.FilterEntry(fullname, &e).IsIn() {
+				continue
+			}
+			// the commit of the submodule is not in this repo, only the entry is kept.
+			newEntries = append(newEntries, e)
 		case filemode.Empty:
 			continue
 		case filemode.Dir:
@@ -69,11 +156,16 @@ func FilterTree(
 				return nil, fmt.Errorf("failed to find sub tree %s: %w", fullnamestring, err)
 			}
 			var newTree *object.Tree
-			switch filter.Filter(fullname, true) {
+			result := entryfilter.FilterEntry(fullname, &e)
+			if result == FilterResult_In && (opts.BlobTransformers.MayApplyUnder(fullname) || opts.BlobSizeLimit != nil) {
+				// files in the sub tree may be transformed or too large, the sub tree cannot be copied as is.
+				result = FilterResult_DirDive
+			}
+			switch result {
 			case FilterResult_Out:
 				continue
 			case FilterResult_In:
-				if err = CopyTree(ctx, dir, s); err != nil {
+				if err = copyTree(ctx, dir, fullname, s, opts.SecretScanner); err != nil {
 					return nil, errorf(err, "failed to copy sub tree %s: %w", fullnamestring, err)
 				}
 
@@ -82,7 +174,7 @@ func FilterTree(
 					return nil, fmt.Errorf("failed to get tree %s: %w", fullnamestring, err)
 				}
 			case FilterResult_DirDive:
-				newTree, err = FilterTree(ctx, dir, fullname, s, filter)
+				newTree, err = FilterTreeWithOptions(ctx, dir, fullname, s, filter, opts)
 				if err != nil {
 					return nil, err
 				}
@@ -99,6 +191,17 @@ func FilterTree(
 		}
 	}
 
+	if isroot && opts.KeepSubmodules {
+		gitmodules, err := filterGitModules(ctx, t, newEntries, s, opts.SubmoduleURLMap)
+		if err != nil {
+			return nil, errorf(err, "failed to filter %s: %w", GitModulesFile, err)
+		}
+		if gitmodules != nil {
+			newEntries = append(newEntries, *gitmodules)
+			slices.SortFunc(newEntries, compareTreeEntry)
+		}
+	}
+
 	if len(newEntries) == 0 {
 		logger.Debug("empty tree", "tree", t.Hash, "prefix", pathsToFullPath(prepath))
 		return nil, nil
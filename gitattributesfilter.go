@@ -0,0 +1,302 @@
+package permgit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// gitAttributesFile is the name of the .gitattributes files.
+const gitAttributesFile = ".gitattributes"
+
+// GitAttributeRule includes or excludes the paths by the state of a git attribute.
+type GitAttributeRule struct {
+	// Attribute is the name of the attribute, for example export-ignore.
+	Attribute string
+	// Value is the value of the attribute set with attr=value, empty Value matches the attribute set with attr.
+	Value string
+	// Unset matches the attribute unset with -attr, Value is ignored if it is true.
+	Unset bool
+	// Include includes the matched paths, otherwise they are excluded.
+	Include bool
+}
+
+// ParseGitAttributeRule parses the rule in the format of .gitattributes, "attr", "-attr", or "attr=value".
+func ParseGitAttributeRule(rule string, include bool) (GitAttributeRule, error) {
+	r := GitAttributeRule{Include: include}
+	rule = strings.TrimSpace(rule)
+
+	name, value, hasvalue := strings.Cut(rule, "=")
+	switch {
+	case hasvalue:
+		r.Value = value
+	case strings.HasPrefix(name, "-"):
+		name = name[1:]
+		r.Unset = true
+	}
+
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, " \t!") {
+		return r, fmt.Errorf("invalid git attribute rule: %q", rule)
+	}
+	r.Attribute = name
+
+	return r, nil
+}
+
+// String returns the rule in the format of .gitattributes, see [ParseGitAttributeRule].
+func (r GitAttributeRule) String() string {
+	switch {
+	case r.Unset:
+		return "-" + r.Attribute
+	case r.Value != "":
+		return r.Attribute + "=" + r.Value
+	default:
+		return r.Attribute
+	}
+}
+
+func (r GitAttributeRule) matches(attr gitattributes.Attribute) bool {
+	switch {
+	case r.Unset:
+		return attr.IsUnset()
+	case r.Value != "":
+		return attr.IsValueSet() && attr.Value() == r.Value
+	default:
+		return attr.IsSet()
+	}
+}
+
+// GitAttributesFilter includes or excludes the paths by their attributes in the .gitattributes files of the tree being filtered,
+// and falls back to the base filter for the paths not matched by any rule.
+// It is a [CommitFilter], so the attributes follow the .gitattributes files as they change over the history.
+//
+// The .gitattributes at every level of the tree are read, the deeper ones and the later lines take precedence like git.
+// Macros are only allowed in the .gitattributes at the root. Rules are checked in order, and the first matched rule decides.
+// Directories excluded by the attributes, for example "dir export-ignore", are excluded with all their entries like git archive.
+//
+// The parsed .gitattributes are cached by the hashes of the trees, the cache is not concurrent safe.
+type GitAttributesFilter struct {
+	base  Filter
+	rules []GitAttributeRule
+
+	hasInclude bool
+
+	// cache is the attributes of the subtrees, keyed by the path of the subtree and its hash.
+	cache map[string][]gitattributes.MatchAttribute
+}
+
+var _ CommitFilter = (*GitAttributesFilter)(nil)
+
+// NewGitAttributesFilter creates a new [GitAttributesFilter] with the base filter and the rules.
+func NewGitAttributesFilter(base Filter, rules ...GitAttributeRule) *GitAttributesFilter {
+	f := &GitAttributesFilter{
+		base:  base,
+		rules: rules,
+		cache: make(map[string][]gitattributes.MatchAttribute),
+	}
+	for _, r := range rules {
+		f.hasInclude = f.hasInclude || r.Include
+	}
+
+	return f
+}
+
+// Filter applies the base filter, since the attributes are not known without the tree.
+func (f *GitAttributesFilter) Filter(paths []string, isdir bool) FilterResult {
+	return f.base.Filter(paths, isdir)
+}
+
+// FilterEntry applies the base filter, since the attributes are not known without the tree.
+func (f *GitAttributesFilter) FilterEntry(paths []string, entry *object.TreeEntry) FilterResult {
+	return AsEntryFilter(f.base).FilterEntry(paths, entry)
+}
+
+// FilterForCommit returns the filter for the tree of the commit, see [GitAttributesFilter.FilterForTree].
+// The base filter is also resolved for the commit if it is a [CommitFilter].
+func (f *GitAttributesFilter) FilterForCommit(ctx context.Context, c *object.Commit) (Filter, error) {
+	t, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain tree for commit %s: %w", c.Hash, err)
+	}
+	base, err := filterForCommit(ctx, f.base, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.filterForTree(ctx, t, base)
+}
+
+// FilterForTree reads the .gitattributes files in the tree, and returns the filter applying the attributes in them.
+// The base filter is also resolved for the tree, see [FilterForTree].
+func (f *GitAttributesFilter) FilterForTree(ctx context.Context, t *object.Tree) (Filter, error) {
+	base, err := FilterForTree(ctx, f.base, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.filterForTree(ctx, t, base)
+}
+
+func (f *GitAttributesFilter) filterForTree(ctx context.Context, t *object.Tree, base Filter) (EntryFilter, error) {
+	stack, err := f.readAttributes(ctx, t, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	macros := make(map[string]gitattributes.MatchAttribute)
+	for _, m := range stack {
+		if m.Pattern == nil {
+			macros[m.Name] = m
+		}
+	}
+
+	return &treeAttributesFilter{
+		parent: f,
+		stack:  stack,
+		macros: macros,
+		base:   AsEntryFilter(base),
+	}, nil
+}
+
+// readAttributes reads the .gitattributes in the tree at the path and its subtrees, in the order of increasing precedence.
+func (f *GitAttributesFilter) readAttributes(ctx context.Context, t *object.Tree, paths []string) ([]gitattributes.MatchAttribute, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	key := gitAttributesKey(paths, t.Hash)
+	if stack, found := f.cache[key]; found {
+		return stack, nil
+	}
+
+	var stack []gitattributes.MatchAttribute
+	for _, e := range t.Entries {
+		if e.Name != gitAttributesFile || !e.Mode.IsFile() {
+			continue
+		}
+		file, err := t.TreeEntryFile(&e)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain %s: %w", pathsToFullPath(append(paths, e.Name)), err)
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", pathsToFullPath(append(paths, e.Name)), err)
+		}
+		attrs, err := gitattributes.ReadAttributes(strings.NewReader(content), paths, len(paths) == 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", pathsToFullPath(append(paths, e.Name)), err)
+		}
+		stack = append(stack, attrs...)
+	}
+
+	for _, e := range t.Entries {
+		if e.Mode != filemode.Dir {
+			continue
+		}
+		subtree, err := t.Tree(e.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain tree %s: %w", pathsToFullPath(append(paths, e.Name)), err)
+		}
+		substack, err := f.readAttributes(ctx, subtree, append(paths[:len(paths):len(paths)], e.Name))
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, substack...)
+	}
+
+	f.cache[key] = stack
+
+	return stack, nil
+}
+
+// treeAttributesFilter is the [GitAttributesFilter] for a specific tree.
+type treeAttributesFilter struct {
+	parent *GitAttributesFilter
+	stack  []gitattributes.MatchAttribute
+	macros map[string]gitattributes.MatchAttribute
+	base   EntryFilter
+}
+
+var _ EntryFilter = (*treeAttributesFilter)(nil)
+
+// attribute finds the state of the attribute for the path, false is returned if it is not specified.
+//
+// The matcher of go-git stops at the first line matching any of the requested attributes,
+// so the stack is searched directly for the line that specifies the attribute.
+func (f *treeAttributesFilter) attribute(paths []string, name string) (gitattributes.Attribute, bool) {
+	for i := len(f.stack) - 1; i >= 0; i-- {
+		m := f.stack[i]
+		if m.Pattern == nil || !m.Pattern.Match(paths) {
+			continue
+		}
+		for j := len(m.Attributes) - 1; j >= 0; j-- {
+			attr := m.Attributes[j]
+			if attr.Name() == name {
+				return attr, !attr.IsUnspecified()
+			}
+			if macro, found := f.macros[attr.Name()]; found && attr.IsSet() {
+				for _, v := range macro.Attributes {
+					if v.Name() == name {
+						return v, !v.IsUnspecified()
+					}
+				}
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// decide returns the first rule matching the path.
+func (f *treeAttributesFilter) decide(paths []string) (*GitAttributeRule, bool) {
+	for i := range f.parent.rules {
+		r := &f.parent.rules[i]
+		attr, found := f.attribute(paths, r.Attribute)
+		if found && r.matches(attr) {
+			return r, true
+		}
+	}
+
+	return nil, false
+}
+
+func (f *treeAttributesFilter) Filter(paths []string, isdir bool) FilterResult {
+	return f.apply(paths, isdir, f.base.Filter(paths, isdir))
+}
+
+func (f *treeAttributesFilter) FilterEntry(paths []string, entry *object.TreeEntry) FilterResult {
+	return f.apply(paths, entry.Mode == filemode.Dir, f.base.FilterEntry(paths, entry))
+}
+
+func (f *treeAttributesFilter) apply(paths []string, isdir bool, base FilterResult) FilterResult {
+	r, matched := f.decide(paths)
+	if matched && !r.Include {
+		return FilterResult_Out
+	}
+
+	if isdir {
+		// entries in the directory can still be excluded or included by their own attributes.
+		if base == FilterResult_Out && !f.parent.hasInclude {
+			return FilterResult_Out
+		}
+		return FilterResult_DirDive
+	}
+
+	if matched {
+		return FilterResult_In
+	}
+
+	return base
+}
+
+// gitAttributesKey is the cache key of the attributes of a tree.
+func gitAttributesKey(paths []string, hash plumbing.Hash) string {
+	return pathsToFullPath(paths) + "\x00" + hash.String()
+}
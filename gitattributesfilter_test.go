@@ -0,0 +1,167 @@
+package permgit_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func TestGitAttributesFilter(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	base, err := permgit.NewOrFilterForPatterns("src/", ".gitattributes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := permgit.NewGitAttributesFilter(
+		base,
+		permgit.GitAttributeRule{Attribute: "export-ignore"},
+		permgit.GitAttributeRule{Attribute: "publish", Value: "false"},
+		permgit.GitAttributeRule{Attribute: "publish", Value: "true", Include: true},
+	)
+
+	sig := object.Signature{Name: "a", Email: "a@example.com", When: time.Unix(1700000000, 0).UTC()}
+
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name: "root and nested",
+			files: map[string]string{
+				".gitattributes":             "*.bin export-ignore\ninternal export-ignore\ndocs/*.md publish=true\n",
+				"src/a.go":                   "a\n",
+				"src/b.bin":                  "b\n",
+				"src/internal/c.go":          "c\n",
+				"src/x/.gitattributes":       "secret.go publish=false\nkeep.bin -export-ignore\n",
+				"src/x/secret.go":            "s\n",
+				"src/x/keep.bin":             "k\n",
+				"src/x/y/secret.go":          "s\n",
+				"docs/readme.md":             "r\n",
+				"docs/other.txt":             "o\n",
+				"docs/nested/.gitattributes": "*.md publish=false\n",
+				"docs/nested/hidden.md":      "h\n",
+			},
+			want: []string{
+				".gitattributes",
+				"docs/readme.md",
+				"src/a.go",
+				"src/x/.gitattributes",
+				"src/x/keep.bin",
+			},
+		},
+		{
+			name: "attributes changed",
+			files: map[string]string{
+				".gitattributes":    "[attr]private publish=false\n*.go private\na.go !publish\n",
+				"src/a.go":          "a\n",
+				"src/b.go":          "b\n",
+				"src/internal/c.go": "c\n",
+			},
+			want: []string{
+				".gitattributes",
+				"src/a.go",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := saveTestCommit(t, s, &object.Commit{
+				Author:    sig,
+				Committer: sig,
+				Message:   tc.name + "\n",
+				TreeHash:  buildTestTree(t, s, tc.files).Hash,
+			})
+
+			newcommit, err := permgit.FilterCommitWithOptions(ctx, c, nil, s, filter, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree, err := object.GetTree(s, newcommit.TreeHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for p := range readTestTree(t, tree) {
+				got = append(got, p)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGitAttributesFilter_FilterForTree(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	tree := buildTestTree(t, s, map[string]string{
+		".gitattributes": "*.bin export-ignore\n",
+		".permgit":       "/src/\n",
+		"src/a.go":       "a\n",
+		"src/b.bin":      "b\n",
+		"docs/c.md":      "c\n",
+	})
+
+	// the base is the rules file in the tree, and is resolved for the tree too.
+	fallback, err := permgit.NewOrFilterForPatterns("docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := permgit.NewRulesFileFilter("", fallback, permgit.RulesFilePolicy_Filter, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := permgit.NewGitAttributesFilter(base, permgit.GitAttributeRule{Attribute: "export-ignore"}).FilterForTree(ctx, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path []string
+		want permgit.FilterResult
+	}{
+		{[]string{"src", "a.go"}, permgit.FilterResult_In},
+		{[]string{"src", "b.bin"}, permgit.FilterResult_Out},
+		{[]string{"docs", "c.md"}, permgit.FilterResult_Out},
+	} {
+		if got := filter.Filter(tc.path, false); got != tc.want {
+			t.Errorf("%v: want %s, got %s", tc.path, tc.want, got)
+		}
+	}
+}
+
+func TestParseGitAttributeRule(t *testing.T) {
+	for _, tc := range []struct {
+		rule string
+		want permgit.GitAttributeRule
+	}{
+		{"export-ignore", permgit.GitAttributeRule{Attribute: "export-ignore"}},
+		{"-text", permgit.GitAttributeRule{Attribute: "text", Unset: true}},
+		{"publish=false", permgit.GitAttributeRule{Attribute: "publish", Value: "false"}},
+	} {
+		got, err := permgit.ParseGitAttributeRule(tc.rule, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: want %+v, got %+v", tc.rule, tc.want, got)
+		}
+	}
+
+	for _, rule := range []string{"", "-", "!text", "--text"} {
+		if _, err := permgit.ParseGitAttributeRule(rule, false); err == nil {
+			t.Errorf("%q should fail", rule)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package permgit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// GitModulesFile is the name of the file at the root of the tree describing the submodules.
+const GitModulesFile = ".gitmodules"
+
+// isGitlinkInEntries checks if the path is a submodule in the tree made of the entries, sub trees are read from the storer.
+func isGitlinkInEntries(s storer.EncodedObjectStorer, entries []object.TreeEntry, path string) bool {
+	segs := strings.Split(path, "/")
+	for _, e := range entries {
+		if e.Name != segs[0] {
+			continue
+		}
+		if len(segs) == 1 {
+			return e.Mode == filemode.Submodule
+		}
+		if e.Mode != filemode.Dir {
+			return false
+		}
+		t, err := object.GetTree(s, e.Hash)
+		if err != nil {
+			return false
+		}
+		found, err := t.FindEntry(strings.Join(segs[1:], "/"))
+
+		return err == nil && found.Mode == filemode.Submodule
+	}
+
+	return false
+}
+
+// filterGitModules filters the sections of .gitmodules in the original tree t to the submodules kept in the filtered entries,
+// and rewrites the urls by the url map.
+// The filtered .gitmodules is saved into the storer, and the entry for it is returned. If no submodule is kept, nil is returned.
+func filterGitModules(
+	ctx context.Context,
+	t *object.Tree,
+	entries []object.TreeEntry,
+	s storer.Storer,
+	urlmap map[string]string,
+) (*object.TreeEntry, error) {
+	f, err := t.File(GitModulesFile)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain %s: %w", GitModulesFile, err)
+	}
+
+	content, err := f.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", GitModulesFile, err)
+	}
+
+	cfg, err := parseGitModules([]byte(content))
+	if err != nil {
+		return nil, err
+	}
+
+	kept := 0
+	for _, section := range cfg.Sections {
+		if !section.IsName("submodule") {
+			continue
+		}
+		subsections := make(config.Subsections, 0, len(section.Subsections))
+		for _, sub := range section.Subsections {
+			path := sub.Option("path")
+			if path == "" || !isGitlinkInEntries(s, entries, path) {
+				logger.Debug("drop submodule from .gitmodules", "name", sub.Name, "path", path)
+				continue
+			}
+			if newurl, found := urlmap[sub.Option("url")]; found {
+				logger.Debug("rewrite submodule url", "name", sub.Name, "from", sub.Option("url"), "to", newurl)
+				sub.SetOption("url", newurl)
+			}
+			subsections = append(subsections, sub)
+		}
+		section.Subsections = subsections
+		kept += len(subsections)
+	}
+
+	if kept == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if err := config.NewEncoder(&buf).Encode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", GitModulesFile, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	hash, err := saveBlob(s, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return &object.TreeEntry{
+		Name: GitModulesFile,
+		Mode: filemode.Regular,
+		Hash: hash,
+	}, nil
+}
+
+// parseGitModules parses the content of .gitmodules.
+func parseGitModules(content []byte) (*config.Config, error) {
+	cfg := config.New()
+	if err := config.NewDecoder(bytes.NewReader(content)).Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", GitModulesFile, err)
+	}
+
+	return cfg, nil
+}
+
+// submodulesByName collects the submodule sections of .gitmodules by their names.
+func submodulesByName(cfg *config.Config) map[string]*config.Subsection {
+	result := make(map[string]*config.Subsection)
+	for _, section := range cfg.Sections {
+		if !section.IsName("submodule") {
+			continue
+		}
+		for _, sub := range section.Subsections {
+			result[sub.Name] = sub
+		}
+	}
+
+	return result
+}
+
+// mergeGitModules applies the change of .gitmodules in the filtered repo from the from content to the to content,
+// either of which is empty if the file is added or deleted, to the target content section by section.
+// The sections of the submodules in from or to are kept in the filtered repo, and they are replaced by those in to,
+// or removed if they are not in to. A section unchanged in the filtered repo keeps the target version,
+// whose url may be rewritten when filtering. The other sections, for the submodules outside the filtered tree, are kept.
+// nil is returned if nothing is left.
+func mergeGitModules(target []byte, from []byte, to []byte) ([]byte, error) {
+	targetcfg, err := parseGitModules(target)
+	if err != nil {
+		return nil, err
+	}
+	fromcfg, err := parseGitModules(from)
+	if err != nil {
+		return nil, err
+	}
+	tocfg, err := parseGitModules(to)
+	if err != nil {
+		return nil, err
+	}
+
+	fromsubs := submodulesByName(fromcfg)
+	tosubs := submodulesByName(tocfg)
+
+	section := targetcfg.Section("submodule")
+	subsections := make(config.Subsections, 0, len(section.Subsections))
+	merged := make(map[string]struct{}, len(section.Subsections))
+	for _, sub := range section.Subsections {
+		merged[sub.Name] = struct{}{}
+		fromsub, infrom := fromsubs[sub.Name]
+		tosub, into := tosubs[sub.Name]
+		switch {
+		case !infrom && !into:
+			subsections = append(subsections, sub)
+		case !into:
+			logger.Debug("remove submodule from .gitmodules", "name", sub.Name)
+		case infrom && slices.EqualFunc(fromsub.Options, tosub.Options, func(a, b *config.Option) bool { return *a == *b }):
+			subsections = append(subsections, sub)
+		default:
+			logger.Debug("update submodule in .gitmodules", "name", sub.Name)
+			subsections = append(subsections, tosub)
+		}
+	}
+	for _, tosection := range tocfg.Sections {
+		if !tosection.IsName("submodule") {
+			continue
+		}
+		for _, sub := range tosection.Subsections {
+			if _, found := merged[sub.Name]; !found {
+				logger.Debug("add submodule to .gitmodules", "name", sub.Name)
+				subsections = append(subsections, sub)
+			}
+		}
+	}
+	section.Subsections = subsections
+
+	if len(subsections) == 0 {
+		targetcfg.RemoveSection("submodule")
+	}
+	if len(targetcfg.Sections) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if err := config.NewEncoder(&buf).Encode(targetcfg); err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", GitModulesFile, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// expandGitModules applies the change of .gitmodules between the filtered trees to the target, see [mergeGitModules].
+// from or to is nil if .gitmodules is added or deleted in the filtered repo.
+func expandGitModules(
+	sourceStorer storer.EncodedObjectStorer,
+	targetStorer storer.EncodedObjectStorer,
+	editTree *inflightTree,
+	from *object.ChangeEntry,
+	to *object.ChangeEntry,
+) error {
+	var fromcontent, tocontent, targetcontent []byte
+	var err error
+	if from != nil {
+		if fromcontent, err = readBlobContent(sourceStorer, from.TreeEntry.Hash); err != nil {
+			return err
+		}
+	}
+	if to != nil {
+		if tocontent, err = readBlobContent(sourceStorer, to.TreeEntry.Hash); err != nil {
+			return err
+		}
+	}
+	targetentry, found := editTree.Find([]string{GitModulesFile})
+	if found {
+		if targetcontent, err = readBlobContent(targetStorer, targetentry.Hash); err != nil {
+			return err
+		}
+	}
+
+	merged, err := mergeGitModules(targetcontent, fromcontent, tocontent)
+	if err != nil {
+		return err
+	}
+
+	if merged == nil {
+		if !found {
+			return nil
+		}
+		logger.Debug("remove .gitmodules without submodules", "target", targetentry.Hash)
+		if _, _, err := editTree.Remove([]string{GitModulesFile}); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", GitModulesFile, err)
+		}
+		return nil
+	}
+
+	hash, err := saveBlob(targetStorer, merged)
+	if err != nil {
+		return err
+	}
+	if found && targetentry.Hash == hash {
+		return nil
+	}
+	if err := editTree.Set(hash, filemode.Regular, []string{GitModulesFile}); err != nil {
+		return fmt.Errorf("failed to update %s: %w", GitModulesFile, err)
+	}
+
+	return nil
+}
@@ -14,98 +14,171 @@ import (
 
 // inflightTree is a structure built from a [object.Tree], and records if the content of the tree should be changed.
 // Use [inflightTree.BuildTree] to update the contained tree.
+//
+// The tree is loaded lazily: the entries of a tree are only read when the tree is visited, and sub trees are only
+// materialized when a path inside them is visited. Sub trees that are not changed keep their original hashes.
 type inflightTree struct {
-	nonTrees map[string]object.TreeEntry
-	trees    map[string]*inflightTree
+	// baseTree is the tree this is built from, nil for a new tree.
+	baseTree *object.Tree
+	// hash is the hash of the tree when it is not changed.
+	hash plumbing.Hash
 
-	changed bool
+	loaded bool
+	// entries are all the entries of the tree, including the sub trees.
+	entries map[string]object.TreeEntry
+	// trees are the sub trees that are visited.
+	trees map[string]*inflightTree
 
-	baseTree *object.Tree
+	changed bool
 }
 
-func newInflightTree(t *object.Tree) (*inflightTree, error) {
+// newInflightTree creates a new [inflightTree] from the tree, a nil tree creates an empty new tree.
+func newInflightTree(t *object.Tree) *inflightTree {
 	r := &inflightTree{
-		nonTrees: make(map[string]object.TreeEntry),
-		trees:    make(map[string]*inflightTree),
 		baseTree: t,
+		trees:    make(map[string]*inflightTree),
 	}
 
-	for _, e := range t.Entries {
-		switch e.Mode {
-		case filemode.Dir:
-			subtree, err := t.Tree(e.Name)
-			if err != nil {
-				return nil, fmt.Errorf("failed to obtain sub tree at %s: %w", e.Name, err)
-			}
-			subinflight, err := newInflightTree(subtree)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create sub inflight tree at %s: %w", e.Name, err)
-			}
-			r.trees[e.Name] = subinflight
-		default:
-			r.nonTrees[e.Name] = e
-		}
+	if t == nil {
+		r.loaded = true
+		r.changed = true
+		r.entries = make(map[string]object.TreeEntry)
+	} else {
+		r.hash = t.Hash
 	}
 
-	return r, nil
+	return r
 }
 
-// BuildTree updates tree's contents and return the rebuilt tree.
-func (it *inflightTree) BuildTree(ctx context.Context, s storer.Storer) (*object.Tree, error) {
-	if !it.changed {
-		return it.baseTree, nil
+// load reads the entries of the base tree.
+func (it *inflightTree) load() {
+	if it.loaded {
+		return
 	}
 
-	it.changed = false
+	it.entries = make(map[string]object.TreeEntry, len(it.baseTree.Entries))
+	for _, e := range it.baseTree.Entries {
+		it.entries[e.Name] = e
+	}
+	it.loaded = true
+}
 
-	newtree := &object.Tree{}
+// subtree returns the sub tree with the name. If the sub tree doesn't exist, a new one is created if create is true, otherwise nil is returned.
+func (it *inflightTree) subtree(name string, create bool) (*inflightTree, error) {
+	if subtree, found := it.trees[name]; found {
+		return subtree, nil
+	}
 
-	for name, subtree := range it.trees {
-		_, err := subtree.BuildTree(ctx, s)
+	it.load()
+
+	e, found := it.entries[name]
+	switch {
+	case found && e.Mode == filemode.Dir:
+		t, err := it.baseTree.Tree(name)
 		if err != nil {
-			return nil, errorf(err, "failed to build sub tree %s: %w", name, err)
+			return nil, fmt.Errorf("failed to obtain sub tree at %s: %w", name, err)
 		}
-
-		newtree.Entries = append(newtree.Entries, object.TreeEntry{
+		subtree := newInflightTree(t)
+		it.trees[name] = subtree
+		return subtree, nil
+	case found:
+		return nil, fmt.Errorf("%s is not a directory", name)
+	case create:
+		subtree := newInflightTree(nil)
+		it.trees[name] = subtree
+		it.entries[name] = object.TreeEntry{
 			Name: name,
 			Mode: filemode.Dir,
-			Hash: subtree.baseTree.Hash,
-		})
+		}
+		return subtree, nil
+	default:
+		return nil, nil
 	}
+}
 
-	for _, item := range it.nonTrees {
-		newtree.Entries = append(newtree.Entries, item)
+// BuildTree updates tree's contents and return the rebuilt tree.
+func (it *inflightTree) BuildTree(ctx context.Context, s storer.Storer) (*object.Tree, error) {
+	if !it.changed && it.baseTree != nil {
+		return it.baseTree, nil
 	}
 
-	slices.SortFunc(newtree.Entries, func(l, r object.TreeEntry) int {
-		if l.Name < r.Name {
-			return -1
-		} else if l.Name > r.Name {
-			return 1
-		} else {
-			return 0
+	hash, err := it.build(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	newtree, err := object.GetTree(s, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reobtain the tree: %w", err)
+	}
+
+	it.baseTree = newtree
+
+	return newtree, nil
+}
+
+// compareTreeEntry compares the tree entries in the order of git, where directories are compared as if their names end with '/'.
+func compareTreeEntry(l, r object.TreeEntry) int {
+	lname, rname := l.Name, r.Name
+	if l.Mode == filemode.Dir {
+		lname += "/"
+	}
+	if r.Mode == filemode.Dir {
+		rname += "/"
+	}
+
+	return strings.Compare(lname, rname)
+}
+
+// build saves the changed trees into the storer and returns the hash of this tree.
+func (it *inflightTree) build(ctx context.Context, s storer.Storer) (plumbing.Hash, error) {
+	if !it.changed {
+		return it.hash, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return plumbing.ZeroHash, ctx.Err()
+	default:
+	}
+
+	newtree := &object.Tree{
+		Entries: make([]object.TreeEntry, 0, len(it.entries)),
+	}
+
+	for name, e := range it.entries {
+		if subtree, found := it.trees[name]; found && e.Mode == filemode.Dir {
+			if subtree.IsEmpty() {
+				continue
+			}
+			hash, err := subtree.build(ctx, s)
+			if err != nil {
+				return plumbing.ZeroHash, errorf(err, "failed to build sub tree %s: %w", name, err)
+			}
+			e.Hash = hash
 		}
-	})
+
+		newtree.Entries = append(newtree.Entries, e)
+	}
+
+	slices.SortFunc(newtree.Entries, compareTreeEntry)
 
 	treehash, err := GetHash(newtree)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get hash: %w", err)
+		return plumbing.ZeroHash, fmt.Errorf("failed to get hash: %w", err)
 	}
 	newtree.Hash = *treehash
 
 	if err := updateHashAndSave(ctx, newtree, s); err != nil {
-		return nil, errorf(err, "failed to save new tree: %w", err)
+		return plumbing.ZeroHash, errorf(err, "failed to save new tree: %w", err)
 	}
 
-	newtree, err = object.GetTree(s, newtree.Hash)
-	if err != nil {
-		return nil, fmt.Errorf("failed to reobtain the tree: %w", err)
-	}
+	logger.Debug("updating tree", "old", it.hash, "new", newtree.Hash, "entries", treeEntryNames(newtree.Entries))
 
-	logger.Debug("updating tree", "old", treeEntryNames(it.baseTree.Entries), "new", treeEntryNames(newtree.Entries))
-	it.baseTree = newtree
+	it.hash = newtree.Hash
+	it.changed = false
 
-	return it.baseTree, nil
+	return it.hash, nil
 }
 
 func treeEntryNames(t []object.TreeEntry) []string {
@@ -116,12 +189,37 @@ func treeEntryNames(t []object.TreeEntry) []string {
 	return r
 }
 
+// IsEmpty checks if the tree contains no files, sub trees containing no files are also empty.
 func (it *inflightTree) IsEmpty() bool {
-	return (len(it.nonTrees) + len(it.trees)) == 0
+	it.load()
+
+	for name, e := range it.entries {
+		if subtree, found := it.trees[name]; found && e.Mode == filemode.Dir && subtree.IsEmpty() {
+			continue
+		}
+		return false
+	}
+
+	return true
 }
 
 func (it *inflightTree) UpdateFile(ctx context.Context, sourceStorer storer.Storer, targetStorer storer.Storer, hash plumbing.Hash, mode filemode.FileMode, filename string) error {
-	it.nonTrees[filename] = object.TreeEntry{
+	if err := copyObject(sourceStorer, targetStorer, hash); err != nil {
+		return err
+	}
+
+	return it.SetFile(hash, mode, filename)
+}
+
+// SetFile sets the file entry in this tree, the object of the file must be already in the target storer.
+func (it *inflightTree) SetFile(hash plumbing.Hash, mode filemode.FileMode, filename string) error {
+	it.load()
+
+	if e, found := it.entries[filename]; found && e.Mode == filemode.Dir {
+		return fmt.Errorf("cannot overwrite directory %s with a file", filename)
+	}
+
+	it.entries[filename] = object.TreeEntry{
 		Mode: mode,
 		Hash: hash,
 		Name: filename,
@@ -129,6 +227,13 @@ func (it *inflightTree) UpdateFile(ctx context.Context, sourceStorer storer.Stor
 
 	logger.Debug("update file", "name", filename, "hash", hash.String())
 
+	it.changed = true
+
+	return nil
+}
+
+// copyObject copies the object with the hash from sourceStorer to targetStorer.
+func copyObject(sourceStorer storer.Storer, targetStorer storer.Storer, hash plumbing.Hash) error {
 	sourcefile, err := object.GetObject(sourceStorer, hash)
 	if err != nil {
 		return fmt.Errorf("failed to get non dir object at hash %s: %w", hash.String(), err)
@@ -144,31 +249,34 @@ func (it *inflightTree) UpdateFile(ctx context.Context, sourceStorer storer.Stor
 		return fmt.Errorf("failed to save the object with hash %s: %w", hash.String(), err)
 	}
 
-	it.changed = true
-
 	return nil
 }
 
+// Update copies the object from sourceStorer to targetStorer, and sets the file at the path to it.
 func (it *inflightTree) Update(ctx context.Context, sourceStorer storer.Storer, targetStorer storer.Storer, hash plumbing.Hash, mode filemode.FileMode, pathsegs []string) error {
+	if err := copyObject(sourceStorer, targetStorer, hash); err != nil {
+		return err
+	}
+
+	return it.Set(hash, mode, pathsegs)
+}
+
+// Set sets the file at the path, the object must be already in the target storer.
+func (it *inflightTree) Set(hash plumbing.Hash, mode filemode.FileMode, pathsegs []string) error {
 	if len(pathsegs) == 0 {
 		return fmt.Errorf("zero length path segment for file: %s", hash.String())
 	}
 	if len(pathsegs) == 1 {
-		return it.UpdateFile(ctx, sourceStorer, targetStorer, hash, mode, pathsegs[0])
+		return it.SetFile(hash, mode, pathsegs[0])
 	}
 
 	foldername := pathsegs[0]
-	subtree, found := it.trees[foldername]
-	var err error
-	if !found {
-		subtree, err = newInflightTree(&object.Tree{})
-		if err != nil {
-			return fmt.Errorf("failed to create an empty inflight tree: %w", err)
-		}
-		it.trees[foldername] = subtree
+	subtree, err := it.subtree(foldername, true)
+	if err != nil {
+		return err
 	}
 
-	err = subtree.Update(ctx, sourceStorer, targetStorer, hash, mode, pathsegs[1:])
+	err = subtree.Set(hash, mode, pathsegs[1:])
 	if err != nil {
 		return err
 	}
@@ -178,9 +286,33 @@ func (it *inflightTree) Update(ctx context.Context, sourceStorer storer.Storer,
 	return nil
 }
 
+// Find returns the non-directory entry at the path.
+func (it *inflightTree) Find(pathsegs []string) (object.TreeEntry, bool) {
+	if len(pathsegs) == 0 {
+		return object.TreeEntry{}, false
+	}
+	if len(pathsegs) == 1 {
+		it.load()
+		e, found := it.entries[pathsegs[0]]
+		if !found || e.Mode == filemode.Dir {
+			return object.TreeEntry{}, false
+		}
+		return e, true
+	}
+
+	subtree, err := it.subtree(pathsegs[0], false)
+	if err != nil || subtree == nil {
+		return object.TreeEntry{}, false
+	}
+
+	return subtree.Find(pathsegs[1:])
+}
+
 func (it *inflightTree) DeleteFile(ctx context.Context, hash plumbing.Hash, mode filemode.FileMode, filename string) error {
-	filetodelete, found := it.nonTrees[filename]
-	if !found {
+	it.load()
+
+	filetodelete, found := it.entries[filename]
+	if !found || filetodelete.Mode == filemode.Dir {
 		return fmt.Errorf("cannot find the file to delete: %s", filename)
 	}
 
@@ -195,7 +327,7 @@ func (it *inflightTree) DeleteFile(ctx context.Context, hash plumbing.Hash, mode
 
 	logger.Debug("delete file from tree", "file", filename)
 
-	delete(it.nonTrees, filename)
+	delete(it.entries, filename)
 
 	return nil
 }
@@ -210,20 +342,152 @@ func (it *inflightTree) Delete(ctx context.Context, hash plumbing.Hash, mode fil
 	}
 
 	foldername := pathsegs[0]
-	subtree, found := it.trees[foldername]
-	if !found {
+	subtree, err := it.subtree(foldername, false)
+	if err != nil {
+		return err
+	}
+	if subtree == nil {
 		return fmt.Errorf("cannot find folder: %s", foldername)
 	}
 
-	err := subtree.Delete(ctx, hash, mode, pathsegs[1:])
+	err = subtree.Delete(ctx, hash, mode, pathsegs[1:])
 	if err != nil {
 		return errorf(err, "failed to delete %v: %w", strings.Join(pathsegs[1:], "/"), err)
 	}
 	if subtree.IsEmpty() {
 		delete(it.trees, foldername)
+		delete(it.entries, foldername)
+	}
+
+	it.changed = true
+
+	return nil
+}
+
+// Entry returns the entry at the path, which can be a directory.
+func (it *inflightTree) Entry(pathsegs []string) (object.TreeEntry, bool) {
+	if len(pathsegs) == 0 {
+		return object.TreeEntry{}, false
+	}
+	if len(pathsegs) == 1 {
+		it.load()
+		e, found := it.entries[pathsegs[0]]
+		return e, found
+	}
+
+	subtree, err := it.subtree(pathsegs[0], false)
+	if err != nil || subtree == nil {
+		return object.TreeEntry{}, false
+	}
+
+	return subtree.Entry(pathsegs[1:])
+}
+
+// Remove removes the entry at the path regardless of its hash and mode, and returns the removed entry and
+// its sub tree if the entry is a directory.
+// Directories that become empty are removed too.
+func (it *inflightTree) Remove(pathsegs []string) (object.TreeEntry, *inflightTree, error) {
+	if len(pathsegs) == 0 {
+		return object.TreeEntry{}, nil, fmt.Errorf("zero length path segment")
+	}
+
+	if len(pathsegs) == 1 {
+		it.load()
+		name := pathsegs[0]
+		e, found := it.entries[name]
+		if !found {
+			return object.TreeEntry{}, nil, fmt.Errorf("cannot find %s", name)
+		}
+		var subtree *inflightTree
+		if e.Mode == filemode.Dir {
+			// materialize the sub tree so it can be inserted elsewhere without the base tree.
+			var err error
+			subtree, err = it.subtree(name, false)
+			if err != nil {
+				return object.TreeEntry{}, nil, err
+			}
+		}
+		delete(it.entries, name)
+		delete(it.trees, name)
+		it.changed = true
+
+		logger.Debug("remove entry from tree", "name", name, "mode", e.Mode)
+
+		return e, subtree, nil
+	}
+
+	foldername := pathsegs[0]
+	subtree, err := it.subtree(foldername, false)
+	if err != nil {
+		return object.TreeEntry{}, nil, err
+	}
+	if subtree == nil {
+		return object.TreeEntry{}, nil, fmt.Errorf("cannot find folder: %s", foldername)
+	}
+
+	e, removed, err := subtree.Remove(pathsegs[1:])
+	if err != nil {
+		return object.TreeEntry{}, nil, errorf(err, "failed to remove %v: %w", strings.Join(pathsegs[1:], "/"), err)
+	}
+	if subtree.IsEmpty() {
+		delete(it.trees, foldername)
+		delete(it.entries, foldername)
+	}
+
+	it.changed = true
+
+	return e, removed, nil
+}
+
+// Insert adds the entry at the path, and the path must not exist.
+// If the entry is a directory, subtree must be its sub tree.
+// Parent directories are created if they don't exist.
+func (it *inflightTree) Insert(pathsegs []string, e object.TreeEntry, subtree *inflightTree) error {
+	if len(pathsegs) == 0 {
+		return fmt.Errorf("zero length path segment")
+	}
+
+	if len(pathsegs) == 1 {
+		it.load()
+		name := pathsegs[0]
+		if _, found := it.entries[name]; found {
+			return fmt.Errorf("%s already exists", name)
+		}
+		e.Name = name
+		it.entries[name] = e
+		if subtree != nil {
+			it.trees[name] = subtree
+		}
+		it.changed = true
+
+		return nil
+	}
+
+	parent, err := it.subtree(pathsegs[0], true)
+	if err != nil {
+		return err
+	}
+
+	if err := parent.Insert(pathsegs[1:], e, subtree); err != nil {
+		return err
 	}
 
 	it.changed = true
 
 	return nil
 }
+
+// Mkdir creates the directory at the path and its parents if they don't exist.
+// Empty directories are dropped when the tree is built.
+func (it *inflightTree) Mkdir(pathsegs []string) error {
+	if len(pathsegs) == 0 {
+		return nil
+	}
+
+	subtree, err := it.subtree(pathsegs[0], true)
+	if err != nil {
+		return err
+	}
+
+	return subtree.Mkdir(pathsegs[1:])
+}
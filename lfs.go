@@ -0,0 +1,185 @@
+package permgit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// LFSPointerVersion is the version line of the Git LFS pointer files.
+const LFSPointerVersion = "version https://git-lfs.github.com/spec/v1"
+
+// lfsPointerMaxSize is the maximum size of a Git LFS pointer file.
+const lfsPointerMaxSize = 1024
+
+// LFSPointer is the content of a Git LFS pointer file, see https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md.
+type LFSPointer struct {
+	// OID is the hex encoded sha256 of the content of the object.
+	OID  string
+	Size int64
+}
+
+// Bytes returns the content of the pointer file.
+func (p *LFSPointer) Bytes() []byte {
+	return []byte(fmt.Sprintf("%s\noid sha256:%s\nsize %d\n", LFSPointerVersion, p.OID, p.Size))
+}
+
+// ParseLFSPointer parses the content of a Git LFS pointer file, false is returned if the content is not a pointer.
+func ParseLFSPointer(content []byte) (*LFSPointer, bool) {
+	if len(content) > lfsPointerMaxSize || !bytes.HasPrefix(content, []byte(LFSPointerVersion+"\n")) {
+		return nil, false
+	}
+
+	p := &LFSPointer{Size: -1}
+	for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")[1:] {
+		key, value, found := strings.Cut(line, " ")
+		if !found {
+			return nil, false
+		}
+		switch key {
+		case "oid":
+			oid, found := strings.CutPrefix(value, "sha256:")
+			if !found || len(oid) != sha256.Size*2 {
+				return nil, false
+			}
+			if _, err := hex.DecodeString(oid); err != nil {
+				return nil, false
+			}
+			p.OID = oid
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return nil, false
+			}
+			p.Size = size
+		}
+	}
+
+	if p.OID == "" || p.Size < 0 {
+		return nil, false
+	}
+
+	return p, true
+}
+
+// readLFSPointer reads the blob with the hash as a Git LFS pointer, nil is returned if the blob is not a pointer.
+func readLFSPointer(s storer.EncodedObjectStorer, hash plumbing.Hash) (*LFSPointer, error) {
+	obj, err := s.EncodedObject(plumbing.BlobObject, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain blob %s: %w", hash, err)
+	}
+	if obj.Size() > lfsPointerMaxSize {
+		return nil, nil
+	}
+	content, err := readBlobContent(s, hash)
+	if err != nil {
+		return nil, err
+	}
+	p, _ := ParseLFSPointer(content)
+
+	return p, nil
+}
+
+// lfsPointerOf computes the Git LFS pointer of the blob with the hash.
+func lfsPointerOf(s storer.EncodedObjectStorer, hash plumbing.Hash) (*LFSPointer, error) {
+	obj, err := s.EncodedObject(plumbing.BlobObject, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain blob %s: %w", hash, err)
+	}
+	r, err := obj.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+
+	return &LFSPointer{OID: hex.EncodeToString(h.Sum(nil)), Size: n}, nil
+}
+
+// LFSStore is a local Git LFS object store, with the same layout as the lfs directory in a git directory,
+// so the objects can be pushed by git lfs from the repo whose .git/lfs is the root of the store.
+type LFSStore struct {
+	fs billy.Filesystem
+}
+
+// NewLFSStore creates a new [LFSStore] in the [billy.Filesystem].
+func NewLFSStore(fs billy.Filesystem) *LFSStore {
+	return &LFSStore{
+		fs: fs,
+	}
+}
+
+func (l *LFSStore) objectPath(oid string) string {
+	return path.Join("objects", oid[0:2], oid[2:4], oid)
+}
+
+// Has checks if the object of the pointer is in the store.
+func (l *LFSStore) Has(p *LFSPointer) bool {
+	info, err := l.fs.Stat(l.objectPath(p.OID))
+
+	return err == nil && info.Size() == p.Size
+}
+
+// Open opens the object of the pointer in the store.
+func (l *LFSStore) Open(p *LFSPointer) (io.ReadCloser, error) {
+	f, err := l.fs.Open(l.objectPath(p.OID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lfs object %s: %w", p.OID, err)
+	}
+
+	return f, nil
+}
+
+// Put writes the content into the store, and returns the pointer to it.
+func (l *LFSStore) Put(r io.Reader) (*LFSPointer, error) {
+	if err := l.fs.MkdirAll("tmp", 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create lfs tmp directory: %w", err)
+	}
+	tmp, err := l.fs.TempFile("tmp", "permgit-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lfs tmp file: %w", err)
+	}
+	tmpname := tmp.Name()
+	defer l.fs.Remove(tmpname)
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("failed to write lfs object: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write lfs object: %w", err)
+	}
+
+	p := &LFSPointer{OID: hex.EncodeToString(h.Sum(nil)), Size: n}
+	if l.Has(p) {
+		return p, nil
+	}
+
+	objpath := l.objectPath(p.OID)
+	if err := l.fs.MkdirAll(path.Dir(objpath), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create lfs object directory: %w", err)
+	}
+	if err := l.fs.Rename(tmpname, objpath); err != nil && !errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("failed to save lfs object %s: %w", p.OID, err)
+	}
+
+	return p, nil
+}
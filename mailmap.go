@@ -0,0 +1,187 @@
+package permgit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// DefaultAnonymizeDomain is the email domain of the anonymized identities if [Mailmap.AnonymizeDomain] is empty.
+const DefaultAnonymizeDomain = "anonymized.invalid"
+
+// MailmapEntry maps the identity in the commits to the proper identity, similar to a line in .mailmap.
+// Empty ProperName or ProperEmail keeps the name or email in the commit.
+// Empty CommitName matches any name with the CommitEmail.
+type MailmapEntry struct {
+	ProperName  string
+	ProperEmail string
+	CommitName  string
+	CommitEmail string
+}
+
+// Mailmap maps the identities of the authors and committers, see https://git-scm.com/docs/gitmailmap.
+// Emails and names are matched case insensitively.
+type Mailmap struct {
+	entries []MailmapEntry
+
+	// Anonymize replaces the identities not in the mailmap with deterministic anonymous ones derived from the email.
+	Anonymize bool
+	// AnonymizeSalt is mixed into the anonymized identities so they cannot be reversed by guessing the emails.
+	AnonymizeSalt string
+	// AnonymizeDomain is the email domain of the anonymized identities, default to [DefaultAnonymizeDomain].
+	AnonymizeDomain string
+}
+
+// NewMailmap creates a new [Mailmap] from the entries.
+func NewMailmap(entries ...MailmapEntry) *Mailmap {
+	return &Mailmap{
+		entries: entries,
+	}
+}
+
+// ParseMailmap parses the content of a .mailmap file. Supported forms of lines are
+//
+//	Proper Name <commit@email>
+//	<proper@email> <commit@email>
+//	Proper Name <proper@email> <commit@email>
+//	Proper Name <proper@email> Commit Name <commit@email>
+//
+// '#' starts a comment, and blank lines are ignored.
+func ParseMailmap(content string) (*Mailmap, error) {
+	m := NewMailmap()
+
+	for i, line := range strings.Split(content, "\n") {
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var names, emails []string
+		rest := line
+		for rest != "" {
+			start := strings.Index(rest, "<")
+			if start < 0 {
+				return nil, fmt.Errorf("line %d of mailmap has text after the last email: %s", i+1, line)
+			}
+			end := strings.Index(rest[start:], ">")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d of mailmap has unclosed email: %s", i+1, line)
+			}
+			names = append(names, strings.TrimSpace(rest[:start]))
+			emails = append(emails, strings.TrimSpace(rest[start+1:start+end]))
+			rest = strings.TrimSpace(rest[start+end+1:])
+		}
+
+		var e MailmapEntry
+		switch len(emails) {
+		case 1:
+			e = MailmapEntry{ProperName: names[0], CommitEmail: emails[0]}
+		case 2:
+			e = MailmapEntry{ProperName: names[0], ProperEmail: emails[0], CommitName: names[1], CommitEmail: emails[1]}
+		default:
+			return nil, fmt.Errorf("line %d of mailmap has %d emails: %s", i+1, len(emails), line)
+		}
+		if e.CommitEmail == "" {
+			return nil, fmt.Errorf("line %d of mailmap has empty commit email: %s", i+1, line)
+		}
+
+		m.entries = append(m.entries, e)
+	}
+
+	return m, nil
+}
+
+// Entries returns the entries of the mailmap.
+func (m *Mailmap) Entries() []MailmapEntry {
+	return m.entries
+}
+
+// lookup finds the proper name and email for the identity. Like git, the entries with the same commit email are merged,
+// the entry matching both name and email takes precedence, and later entries override earlier ones.
+func (m *Mailmap) lookup(name string, email string) (MailmapEntry, bool) {
+	var r MailmapEntry
+	found := false
+	for _, precise := range []bool{false, true} {
+		for _, e := range m.entries {
+			if !strings.EqualFold(e.CommitEmail, email) || (e.CommitName != "") != precise {
+				continue
+			}
+			if precise && !strings.EqualFold(e.CommitName, name) {
+				continue
+			}
+			found = true
+			if e.ProperName != "" {
+				r.ProperName = e.ProperName
+			}
+			if e.ProperEmail != "" {
+				r.ProperEmail = e.ProperEmail
+			}
+		}
+	}
+
+	return r, found
+}
+
+// anonymize creates the anonymous identity from the email.
+func (m *Mailmap) anonymize(email string) (string, string) {
+	h := sha256.Sum256([]byte(m.AnonymizeSalt + "\x00" + strings.ToLower(email)))
+	id := hex.EncodeToString(h[:6])
+	domain := m.AnonymizeDomain
+	if domain == "" {
+		domain = DefaultAnonymizeDomain
+	}
+
+	return "contributor-" + id, id + "@" + domain
+}
+
+// Map maps the identity of the signature, the timestamp is kept.
+// Nil mailmap returns the signature unchanged.
+func (m *Mailmap) Map(sig object.Signature) object.Signature {
+	if m == nil {
+		return sig
+	}
+
+	e, found := m.lookup(sig.Name, sig.Email)
+	if !found {
+		if m.Anonymize {
+			sig.Name, sig.Email = m.anonymize(sig.Email)
+		}
+		return sig
+	}
+
+	if e.ProperName != "" {
+		sig.Name = e.ProperName
+	}
+	if e.ProperEmail != "" {
+		sig.Email = e.ProperEmail
+	}
+
+	return sig
+}
+
+// Inverse creates the mailmap mapping the proper identities back to the identities in the commits, which is used to expand
+// the commits in the filtered repo. The mapping is by email only, the name is restored if the entry has the commit name.
+// If multiple commit identities have the same proper email, they are merged and the later ones in the mailmap win.
+// Anonymized identities cannot be inversed.
+func (m *Mailmap) Inverse() *Mailmap {
+	r := NewMailmap()
+	for _, e := range m.entries {
+		properEmail := e.ProperEmail
+		if properEmail == "" {
+			properEmail = e.CommitEmail
+		}
+		r.entries = append(r.entries, MailmapEntry{
+			ProperName:  e.CommitName,
+			ProperEmail: e.CommitEmail,
+			CommitEmail: properEmail,
+		})
+	}
+
+	return r
+}
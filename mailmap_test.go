@@ -0,0 +1,60 @@
+package permgit_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/fardream/permgit"
+)
+
+const testMailmap = `# comment
+Jane Doe <jane@corp.internal>
+<jane@example.com> <jane@corp.internal>
+Public Bot <bot@example.com> Build Bot <build@corp.internal>
+`
+
+func TestMailmap(t *testing.T) {
+	m, err := permgit.ParseMailmap(testMailmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Anonymize = true
+
+	when := time.Unix(1700000000, 0).UTC()
+	for _, c := range []struct {
+		in   object.Signature
+		want object.Signature
+	}{
+		{
+			in:   object.Signature{Name: "jane", Email: "Jane@corp.internal", When: when},
+			want: object.Signature{Name: "Jane Doe", Email: "jane@example.com", When: when},
+		},
+		{
+			in:   object.Signature{Name: "Build Bot", Email: "build@corp.internal", When: when},
+			want: object.Signature{Name: "Public Bot", Email: "bot@example.com", When: when},
+		},
+	} {
+		if got := m.Map(c.in); got != c.want {
+			t.Errorf("map %v: want %v, got %v", c.in, c.want, got)
+		}
+	}
+
+	anon := m.Map(object.Signature{Name: "Other", Email: "other@corp.internal", When: when})
+	if anon != m.Map(object.Signature{Name: "Someone", Email: "OTHER@corp.internal", When: when}) {
+		t.Errorf("anonymized identity is not deterministic")
+	}
+	if strings.Contains(anon.Name+anon.Email, "other") || !strings.HasSuffix(anon.Email, "@"+permgit.DefaultAnonymizeDomain) {
+		t.Errorf("identity is not anonymized: %v", anon)
+	}
+
+	inverse := m.Inverse()
+	if got := inverse.Map(object.Signature{Name: "Public Bot", Email: "bot@example.com", When: when}); got.Name != "Build Bot" || got.Email != "build@corp.internal" {
+		t.Errorf("unexpected inverse: %v", got)
+	}
+	if got := inverse.Map(object.Signature{Name: "Jane Doe", Email: "jane@example.com", When: when}); got.Name != "Jane Doe" || got.Email != "jane@corp.internal" {
+		t.Errorf("unexpected inverse: %v", got)
+	}
+}
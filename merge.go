@@ -0,0 +1,186 @@
+package permgit
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// MergeConflictError is returned when the changes on two sides of a three-way merge overlap.
+type MergeConflictError struct {
+	Path string
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("conflicting changes in %s", e.Path)
+}
+
+// mergeHunk is a consecutive change to the base lines: base lines [start, end) are replaced by lines.
+type mergeHunk struct {
+	start int
+	end   int
+	lines []string
+}
+
+func (h *mergeHunk) equal(o *mergeHunk) bool {
+	if h.start != o.start || h.end != o.end || len(h.lines) != len(o.lines) {
+		return false
+	}
+	for i, l := range h.lines {
+		if o.lines[i] != l {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (h *mergeHunk) overlap(o *mergeHunk) bool {
+	return h.start == o.start || (h.start < o.end && o.start < h.end)
+}
+
+// splitLines splits the string into lines, and the line breaks are kept.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// diffHunks obtains the line based changes from base to other.
+func diffHunks(base string, other string) []*mergeHunk {
+	var result []*mergeHunk
+	var current *mergeHunk
+	pos := 0
+
+	for _, d := range diff.Do(base, other) {
+		lines := splitLines(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			current = nil
+			pos += len(lines)
+		case diffmatchpatch.DiffDelete:
+			if current == nil {
+				current = &mergeHunk{start: pos, end: pos}
+				result = append(result, current)
+			}
+			pos += len(lines)
+			current.end = pos
+		case diffmatchpatch.DiffInsert:
+			if current == nil {
+				current = &mergeHunk{start: pos, end: pos}
+				result = append(result, current)
+			}
+			current.lines = append(current.lines, lines...)
+		}
+	}
+
+	return result
+}
+
+// mergeLines performs a line based three-way merge, the changes from base to ours and from base to theirs
+// are both applied to base. The returned bool is false if the changes overlap.
+func mergeLines(base string, ours string, theirs string) (string, bool) {
+	switch {
+	case ours == base || ours == theirs:
+		return theirs, true
+	case theirs == base:
+		return ours, true
+	}
+
+	baselines := splitLines(base)
+	ourhunks := diffHunks(base, ours)
+	theirhunks := diffHunks(base, theirs)
+
+	var b strings.Builder
+	pos := 0
+	apply := func(h *mergeHunk) {
+		for _, l := range baselines[pos:h.start] {
+			b.WriteString(l)
+		}
+		for _, l := range h.lines {
+			b.WriteString(l)
+		}
+		pos = h.end
+	}
+
+	i, j := 0, 0
+	for i < len(ourhunks) || j < len(theirhunks) {
+		switch {
+		case j >= len(theirhunks):
+			apply(ourhunks[i])
+			i++
+		case i >= len(ourhunks):
+			apply(theirhunks[j])
+			j++
+		case ourhunks[i].overlap(theirhunks[j]):
+			if !ourhunks[i].equal(theirhunks[j]) {
+				return "", false
+			}
+			apply(ourhunks[i])
+			i++
+			j++
+		case ourhunks[i].start < theirhunks[j].start:
+			apply(ourhunks[i])
+			i++
+		default:
+			apply(theirhunks[j])
+			j++
+		}
+	}
+
+	for _, l := range baselines[pos:] {
+		b.WriteString(l)
+	}
+
+	return b.String(), true
+}
+
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// mergeBlobs performs a three-way merge of blobs, base and theirs are from the sourceStorer and ours is from the targetStorer.
+// The merged blob is saved into the targetStorer and its hash is returned.
+func mergeBlobs(
+	sourceStorer storer.EncodedObjectStorer,
+	targetStorer storer.EncodedObjectStorer,
+	path string,
+	base plumbing.Hash,
+	ours plumbing.Hash,
+	theirs plumbing.Hash,
+) (plumbing.Hash, error) {
+	basecontent, err := readBlobContent(sourceStorer, base)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	ourcontent, err := readBlobContent(targetStorer, ours)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	theircontent, err := readBlobContent(sourceStorer, theirs)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if isBinary(basecontent) || isBinary(ourcontent) || isBinary(theircontent) {
+		if bytes.Equal(basecontent, theircontent) {
+			return ours, nil
+		}
+		return plumbing.ZeroHash, &MergeConflictError{Path: path}
+	}
+
+	merged, ok := mergeLines(string(basecontent), string(ourcontent), string(theircontent))
+	if !ok {
+		return plumbing.ZeroHash, &MergeConflictError{Path: path}
+	}
+
+	return saveBlob(targetStorer, []byte(merged))
+}
@@ -0,0 +1,121 @@
+package permgit
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// MessageSubstitution replaces the matches of the regular expression in the commit message,
+// see [regexp.Regexp.ReplaceAllString] for the syntax of the replacement.
+type MessageSubstitution struct {
+	Regex       string `json:"regex"`
+	Replacement string `json:"replacement"`
+}
+
+// MessageRulesConfig is the json representation of the rules of [MessageRewriter].
+type MessageRulesConfig struct {
+	Substitutions []MessageSubstitution `json:"substitutions,omitempty"`
+	// RemoveTrailers are the keys of the trailers to remove, case insensitive, such as "Reviewed-on".
+	RemoveTrailers []string `json:"remove-trailers,omitempty"`
+	// DropExcludedPaths drops the lines of the message mentioning paths in the repo that are excluded by the filter.
+	DropExcludedPaths bool `json:"drop-excluded-paths,omitempty"`
+}
+
+// MessageRewriter rewrites the commit messages of the filtered commits.
+// The rewrite is deterministic, the lines mentioning excluded paths are dropped first, then the trailers are removed,
+// and finally the substitutions are applied in order.
+type MessageRewriter struct {
+	substitutions     []*regexp.Regexp
+	replacements      []string
+	removeTrailers    []string
+	dropExcludedPaths bool
+}
+
+// NewMessageRewriter creates a new [MessageRewriter] from the config.
+func NewMessageRewriter(config *MessageRulesConfig) (*MessageRewriter, error) {
+	r := &MessageRewriter{
+		removeTrailers:    config.RemoveTrailers,
+		dropExcludedPaths: config.DropExcludedPaths,
+	}
+
+	for _, sub := range config.Substitutions {
+		re, err := regexp.Compile(sub.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile regex %s: %w", sub.Regex, err)
+		}
+		r.substitutions = append(r.substitutions, re)
+		r.replacements = append(r.replacements, sub.Replacement)
+	}
+
+	return r, nil
+}
+
+// LoadMessageRewriter parses the json of [MessageRulesConfig] into a [MessageRewriter].
+func LoadMessageRewriter(content []byte) (*MessageRewriter, error) {
+	config := &MessageRulesConfig{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("failed to parse message rules: %w", err)
+	}
+
+	return NewMessageRewriter(config)
+}
+
+// pathTokenRegexp matches the tokens that look like paths, including single segments such as top level files.
+// Only the tokens existing in the trees are considered paths.
+var pathTokenRegexp = regexp.MustCompile(`/?[A-Za-z0-9_.\-]+(?:/[A-Za-z0-9_.\-]+)*/?`)
+
+// mentionsExcludedPath checks if the line mentions a path existing in any of the trees but excluded by the filter.
+func mentionsExcludedPath(line string, trees []*object.Tree, filter Filter) bool {
+	for _, loc := range pathTokenRegexp.FindAllStringIndex(line, -1) {
+		// skip urls
+		if strings.HasSuffix(line[:loc[0]], ":/") || strings.HasSuffix(line[:loc[0]], ":") {
+			continue
+		}
+		// the period ending a sentence is not part of the path.
+		p := strings.Trim(strings.TrimRight(line[loc[0]:loc[1]], "."), "/")
+		if p == "" || p == "." || p == ".." {
+			continue
+		}
+		for _, tree := range trees {
+			e, err := tree.FindEntry(p)
+			if err != nil {
+				continue
+			}
+			if AsEntryFilter(filter).FilterEntry(strings.Split(p, "/"), e) == FilterResult_Out {
+				return true
+			}
+			break
+		}
+	}
+
+	return false
+}
+
+// Rewrite rewrites the message. The trees and the filter are used to find the excluded paths.
+// The trees are normally the tree of the commit and the trees of its parents, so paths deleted or renamed by the commit are found too.
+func (r *MessageRewriter) Rewrite(message string, filter Filter, trees ...*object.Tree) string {
+	if r.dropExcludedPaths && len(trees) > 0 && filter != nil {
+		lines := strings.SplitAfter(message, "\n")
+		var b strings.Builder
+		for _, l := range lines {
+			if mentionsExcludedPath(l, trees, filter) {
+				logger.Debug("drop message line mentioning excluded path", "line", strings.TrimRight(l, "\n"))
+				continue
+			}
+			b.WriteString(l)
+		}
+		message = b.String()
+	}
+
+	message = removeTrailers(message, r.removeTrailers...)
+
+	for i, re := range r.substitutions {
+		message = re.ReplaceAllString(message, r.replacements[i])
+	}
+
+	return message
+}
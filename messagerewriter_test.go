@@ -0,0 +1,81 @@
+package permgit_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func TestMessageRewriter(t *testing.T) {
+	s := memory.NewStorage()
+	tree := buildTestTree(t, s, map[string]string{
+		"pub/a.txt":        "a\n",
+		"internal/b/c.txt": "c\n",
+	})
+	filter, err := permgit.NewOrFilterForPatterns("pub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewriter, err := permgit.LoadMessageRewriter([]byte(`{
+	"substitutions": [{"regex": "JIRA-[0-9]+", "replacement": "[redacted]"}],
+	"remove-trailers": ["reviewed-on"],
+	"drop-excluded-paths": true
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := `fix pub/a.txt for JIRA-123
+
+also update internal/b/c.txt
+see https://example.com/internal/b
+
+Change-Id: I1234
+Reviewed-on: https://review.internal/123
+`
+	want := `fix pub/a.txt for [redacted]
+
+see https://example.com/internal/b
+
+Change-Id: I1234
+`
+	if got := rewriter.Rewrite(msg, filter, tree); got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestMessageRewriter_deletedPath(t *testing.T) {
+	s := memory.NewStorage()
+	filter, err := permgit.NewOrFilterForPatterns("pub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent := newTestCommit(t, s, map[string]string{"pub/a.txt": "a\n", "internal/secret.txt": "s\n", "private/x": "x\n", "SECRETS.md": "s\n"}, 0)
+	c := saveTestCommit(t, s, &object.Commit{
+		Author:       parent.Author,
+		Committer:    parent.Committer,
+		Message:      "clean up\n\nremove internal/secret.txt\nmove private/x to private/y\nupdate SECRETS.md.\nsee and/or pub/a.txt\n",
+		TreeHash:     buildTestTree(t, s, map[string]string{"pub/a.txt": "a\n", "private/y": "x\n", "SECRETS.md": "t\n"}).Hash,
+		ParentHashes: []plumbing.Hash{parent.Hash},
+	})
+
+	rewriter, err := permgit.NewMessageRewriter(&permgit.MessageRulesConfig{DropExcludedPaths: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newcommit, err := permgit.FilterCommitWithOptions(context.Background(), c, nil, s, filter, &permgit.FilterOptions{MessageRewriter: rewriter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "clean up\n\nsee and/or pub/a.txt\n"; newcommit.Message != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, newcommit.Message)
+	}
+}
@@ -0,0 +1,108 @@
+package permgit
+
+import (
+	"errors"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// OverlayStorer is a [storer.Storer] layered over a base [storer.Storer].
+// Objects and references are written into an in-memory storage, and reads fall back to the base when they are not found in memory.
+// The base is never modified, which makes it suitable for dry runs.
+// Iterating the references only covers the references written into memory.
+type OverlayStorer struct {
+	*memory.Storage
+
+	base storer.Storer
+}
+
+var _ storer.Storer = (*OverlayStorer)(nil)
+
+// NewOverlayStorer creates a new [OverlayStorer] over base.
+func NewOverlayStorer(base storer.Storer) *OverlayStorer {
+	return &OverlayStorer{
+		Storage: memory.NewStorage(),
+		base:    base,
+	}
+}
+
+func (s *OverlayStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.Storage.EncodedObject(t, h)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return s.base.EncodedObject(t, h)
+	}
+
+	return obj, err
+}
+
+func (s *OverlayStorer) HasEncodedObject(h plumbing.Hash) error {
+	err := s.Storage.HasEncodedObject(h)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return s.base.HasEncodedObject(h)
+	}
+
+	return err
+}
+
+func (s *OverlayStorer) EncodedObjectSize(h plumbing.Hash) (int64, error) {
+	size, err := s.Storage.EncodedObjectSize(h)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return s.base.EncodedObjectSize(h)
+	}
+
+	return size, err
+}
+
+// IterEncodedObjects iterates the objects in memory, and then the objects in the base not written into memory.
+func (s *OverlayStorer) IterEncodedObjects(t plumbing.ObjectType) (storer.EncodedObjectIter, error) {
+	memiter, err := s.Storage.IterEncodedObjects(t)
+	if err != nil {
+		return nil, err
+	}
+	baseiter, err := s.base.IterEncodedObjects(t)
+	if err != nil {
+		memiter.Close()
+		return nil, err
+	}
+
+	return storer.NewMultiEncodedObjectIter([]storer.EncodedObjectIter{memiter, &overlayBaseIter{base: baseiter, mem: s.Storage}}), nil
+}
+
+// overlayBaseIter iterates the objects in the base, skipping the ones also in memory.
+type overlayBaseIter struct {
+	base storer.EncodedObjectIter
+	mem  *memory.Storage
+}
+
+func (iter *overlayBaseIter) Next() (plumbing.EncodedObject, error) {
+	for {
+		obj, err := iter.base.Next()
+		if err != nil {
+			return nil, err
+		}
+		if iter.mem.HasEncodedObject(obj.Hash()) == nil {
+			continue
+		}
+
+		return obj, nil
+	}
+}
+
+func (iter *overlayBaseIter) ForEach(cb func(plumbing.EncodedObject) error) error {
+	return storer.ForEachIterator(iter, cb)
+}
+
+func (iter *overlayBaseIter) Close() {
+	iter.base.Close()
+}
+
+func (s *OverlayStorer) Reference(n plumbing.ReferenceName) (*plumbing.Reference, error) {
+	ref, err := s.Storage.Reference(n)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return s.base.Reference(n)
+	}
+
+	return ref, err
+}
@@ -0,0 +1,134 @@
+package permgit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func TestOverlayStorer(t *testing.T) {
+	base := memory.NewStorage()
+	baseblob := saveTestBlob(t, base, "base\n")
+	basecommit := newTestCommit(t, base, map[string]string{"a": "base\n"}, 0)
+	mainref := plumbing.NewBranchReferenceName("main")
+	if err := base.SetReference(plumbing.NewHashReference(mainref, basecommit.Hash)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := permgit.NewOverlayStorer(base)
+
+	// reads fall back to the base.
+	if _, err := s.EncodedObject(plumbing.BlobObject, baseblob); err != nil {
+		t.Errorf("failed to read base blob: %v", err)
+	}
+	if err := s.HasEncodedObject(baseblob); err != nil {
+		t.Errorf("base blob not found: %v", err)
+	}
+	if size, err := s.EncodedObjectSize(baseblob); err != nil || size != 5 {
+		t.Errorf("want size 5, got %d %v", size, err)
+	}
+	if ref, err := s.Reference(mainref); err != nil || ref.Hash() != basecommit.Hash {
+		t.Errorf("want main at %s, got %v %v", basecommit.Hash, ref, err)
+	}
+	if _, err := s.EncodedObject(plumbing.BlobObject, plumbing.NewHash("0123456789abcdef0123456789abcdef01234567")); !errors.Is(err, plumbing.ErrObjectNotFound) {
+		t.Errorf("want %v, got %v", plumbing.ErrObjectNotFound, err)
+	}
+
+	// writes never reach the base.
+	newblob := saveTestBlob(t, s, "new\n")
+	if again := saveTestBlob(t, s, "base\n"); again != baseblob {
+		t.Errorf("want %s, got %s", baseblob, again)
+	}
+	if err := base.HasEncodedObject(newblob); !errors.Is(err, plumbing.ErrObjectNotFound) {
+		t.Errorf("new blob is written into the base: %v", err)
+	}
+	if err := s.SetReference(plumbing.NewHashReference(mainref, newblob)); err != nil {
+		t.Fatal(err)
+	}
+	if ref, err := s.Reference(mainref); err != nil || ref.Hash() != newblob {
+		t.Errorf("want main at %s, got %v %v", newblob, ref, err)
+	}
+	if ref, err := base.Reference(mainref); err != nil || ref.Hash() != basecommit.Hash {
+		t.Errorf("base main is changed: %v %v", ref, err)
+	}
+
+	// both layers are iterated, and the objects in both are only returned once.
+	iter, err := s.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[plumbing.Hash]int)
+	if err := iter.ForEach(func(o plumbing.EncodedObject) error {
+		seen[o.Hash()]++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []plumbing.Hash{baseblob, newblob, basecommit.Hash, basecommit.TreeHash} {
+		if seen[h] != 1 {
+			t.Errorf("object %s is iterated %d times", h, seen[h])
+		}
+	}
+	if want := len(base.Objects) + 1; len(seen) != want {
+		t.Errorf("want %d objects, got %d", want, len(seen))
+	}
+
+	blobiter, err := s.IterEncodedObjects(plumbing.BlobObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nblobs := 0
+	if err := blobiter.ForEach(func(plumbing.EncodedObject) error {
+		nblobs++
+		return storer.ErrStop
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if nblobs != 1 {
+		t.Errorf("iteration should stop at the first blob, got %d", nblobs)
+	}
+}
+
+// TestOverlayStorer_dryRun expands a commit into an overlay over the target, the same way as the dry run of expand-git-commit.
+func TestOverlayStorer_dryRun(t *testing.T) {
+	ctx := context.Background()
+	target := memory.NewStorage()
+	source := memory.NewStorage()
+
+	filter, err := permgit.NewOrFilterForPatterns("pub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetcommit := newTestCommit(t, target, map[string]string{"pub/a": "a\n", "priv/x": "x\n"}, 0)
+	filteredOrig := newTestCommit(t, source, map[string]string{"pub/a": "a\n"}, 1)
+	filteredNew := newTestCommit(t, source, map[string]string{"pub/a": "a\n", "pub/b": "b\n"}, 2, filteredOrig)
+
+	nobjects := len(target.Objects)
+
+	s := permgit.NewOverlayStorer(target)
+	newcommit, err := permgit.ExpandCommit(ctx, source, filteredOrig, filteredNew, targetcommit, s, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newtree, err := object.GetTree(s, newcommit.TreeHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestTree(t, newtree, map[string]string{"pub/a": "a\n", "pub/b": "b\n", "priv/x": "x\n"})
+
+	if len(target.Objects) != nobjects {
+		t.Errorf("want %d objects in the target, got %d", nobjects, len(target.Objects))
+	}
+	if err := target.HasEncodedObject(newcommit.Hash); !errors.Is(err, plumbing.ErrObjectNotFound) {
+		t.Errorf("generated commit is written into the target: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package permgit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// PathCollision is a set of entries in the same directory whose names are the same after normalization,
+// which cannot be checked out together on case-insensitive or normalizing filesystems.
+type PathCollision struct {
+	// Dir is the directory of the entries, empty for the root.
+	Dir   string
+	Names []string
+}
+
+func (c *PathCollision) String() string {
+	prefix := ""
+	if c.Dir != "" {
+		prefix = c.Dir + "/"
+	}
+	paths := make([]string, 0, len(c.Names))
+	for _, name := range c.Names {
+		paths = append(paths, prefix+name)
+	}
+
+	return fmt.Sprintf("colliding paths: %s", strings.Join(paths, ", "))
+}
+
+// FindPathCollisions walks the tree and finds the entries colliding under the normalization of [PatternFilterOptions].
+// Nil opts checks both case folding and Unicode normalization.
+func FindPathCollisions(ctx context.Context, t *object.Tree, opts *PatternFilterOptions) ([]PathCollision, error) {
+	if opts == nil {
+		opts = &PatternFilterOptions{CaseInsensitive: true, NormalizeUnicode: true}
+	}
+	normalize := opts.normalizer()
+	if normalize == nil {
+		return nil, nil
+	}
+
+	var collisions []PathCollision
+	if err := findPathCollisions(ctx, t, nil, normalize, &collisions); err != nil {
+		return nil, err
+	}
+
+	return collisions, nil
+}
+
+func findPathCollisions(ctx context.Context, t *object.Tree, paths []string, normalize func(string) string, collisions *[]PathCollision) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	names := make(map[string][]string, len(t.Entries))
+	var keys []string
+	for _, e := range t.Entries {
+		key := normalize(e.Name)
+		if _, found := names[key]; !found {
+			keys = append(keys, key)
+		}
+		names[key] = append(names[key], e.Name)
+	}
+	for _, key := range keys {
+		if len(names[key]) > 1 {
+			*collisions = append(*collisions, PathCollision{Dir: pathsToFullPath(paths), Names: names[key]})
+		}
+	}
+
+	for _, e := range t.Entries {
+		if e.Mode != filemode.Dir {
+			continue
+		}
+		subtree, err := t.Tree(e.Name)
+		if err != nil {
+			return fmt.Errorf("failed to obtain tree %s: %w", pathsToFullPath(append(paths, e.Name)), err)
+		}
+		if err := findPathCollisions(ctx, subtree, append(paths[:len(paths):len(paths)], e.Name), normalize, collisions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
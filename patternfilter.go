@@ -14,7 +14,7 @@ type PatternFilterSegment string
 //   - '**' is for multi level directories, and it can only appear once in the match.
 //   - '*' is for match one level of names.
 //   - '!' and escapes are unsupported.
-//   - paths are always relative to the root.
+//   - paths are always relative to the root, so a pattern without a leading '/', such as *.go, only matches at the root unlike .gitignore.
 type PatternFilter struct {
 	inputPattern    string
 	filterSegments  []PatternFilterSegment
@@ -25,11 +25,29 @@ type PatternFilter struct {
 
 	beforefilters []PatternFilterSegment
 	afterfilters  []PatternFilterSegment
+
+	// normalize is applied to the segments of the paths before matching, nil if no option is set.
+	normalize func(string) string
+	options   PatternFilterOptions
 }
 
 var _ Filter = (*PatternFilter)(nil)
 
 func NewPatternFilter(pattern string) (*PatternFilter, error) {
+	return NewPatternFilterWithOptions(pattern, nil)
+}
+
+// NewPatternFilterWithOptions is [NewPatternFilter] with [PatternFilterOptions], nil opts is the same as a zero [PatternFilterOptions].
+// The pattern and the segments of the paths are normalized the same way before matching.
+func NewPatternFilterWithOptions(pattern string, opts *PatternFilterOptions) (*PatternFilter, error) {
+	var normalize func(string) string
+	if opts != nil {
+		normalize = opts.normalizer()
+	}
+	if normalize != nil {
+		pattern = normalize(pattern)
+	}
+
 	trimmedpattern := strings.TrimSpace(pattern)
 	p := &PatternFilter{
 		inputPattern:    trimmedpattern,
@@ -104,10 +122,19 @@ func NewPatternFilter(pattern string) (*PatternFilter, error) {
 		logger.Debug("multi-level-filter", "before", p.beforefilters, "after", p.afterfilters)
 	}
 
+	if normalize != nil {
+		p.normalize = normalize
+		p.options = *opts
+	}
+
 	return p, nil
 }
 
 func (f *PatternFilter) Filter(paths []string, isdir bool) FilterResult {
+	if f.normalize != nil {
+		paths = normalizePaths(paths, f.normalize)
+	}
+
 	if f.multiLevelIndex < 0 {
 		// not multiLevelIndex, use simple filter
 		return nonMultiLevelFilter(isdir, paths, f.filterSegments, f.isDirOnly)
@@ -127,7 +154,11 @@ func (f *PatternFilter) Filter(paths []string, isdir bool) FilterResult {
 
 	remainingpaths := paths[len(predirpaths):]
 
-	beforesult := PatternDirFilter(predirpaths, beforefilters)
+	// a leading ** matches any directories from the root.
+	beforesult := FilterResult_In
+	if len(beforefilters) > 0 {
+		beforesult = PatternDirFilter(predirpaths, beforefilters)
+	}
 	switch beforesult {
 	case FilterResult_In:
 		if len(afterfilters) == 0 {
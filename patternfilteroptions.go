@@ -0,0 +1,43 @@
+package permgit
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// PatternFilterOptions contains the options for [NewPatternFilterWithOptions] to match the paths from case-insensitive or normalizing filesystems.
+// The zero value matches the paths byte by byte.
+type PatternFilterOptions struct {
+	// CaseInsensitive matches the pattern and the paths after Unicode case folding, so README.MD matches README.md.
+	CaseInsensitive bool
+	// NormalizeUnicode matches the pattern and the paths after converting them to Unicode NFC,
+	// so the decomposed names from macOS match the composed ones.
+	NormalizeUnicode bool
+}
+
+// normalizer returns the function normalizing the pattern and the path segments, nil if no option is set.
+// Case folding is applied after NFC, and the result is normalized again since folding may decompose characters.
+func (o *PatternFilterOptions) normalizer() func(string) string {
+	switch {
+	case o.CaseInsensitive && o.NormalizeUnicode:
+		caser := cases.Fold()
+		return func(s string) string { return norm.NFC.String(caser.String(norm.NFC.String(s))) }
+	case o.CaseInsensitive:
+		caser := cases.Fold()
+		return caser.String
+	case o.NormalizeUnicode:
+		return norm.NFC.String
+	default:
+		return nil
+	}
+}
+
+// normalizePaths returns the normalized copy of the paths.
+func normalizePaths(paths []string, normalize func(string) string) []string {
+	r := make([]string, len(paths))
+	for i, v := range paths {
+		r[i] = normalize(v)
+	}
+
+	return r
+}
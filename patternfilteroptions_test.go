@@ -0,0 +1,82 @@
+package permgit_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/storage/memory"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/fardream/permgit"
+)
+
+func TestPatternFilterWithOptions(t *testing.T) {
+	nfc, nfd := norm.NFC.String("café"), norm.NFD.String("café")
+
+	for _, tc := range []struct {
+		pattern string
+		opts    permgit.PatternFilterOptions
+		path    string
+		want    bool
+	}{
+		{"/README.md", permgit.PatternFilterOptions{}, "README.MD", false},
+		{"/README.md", permgit.PatternFilterOptions{CaseInsensitive: true}, "README.MD", true},
+		{"/Docs/*.MD", permgit.PatternFilterOptions{CaseInsensitive: true}, "docs/a.md", true},
+		{"/docs/" + nfc + "/", permgit.PatternFilterOptions{}, "docs/" + nfd + "/a", false},
+		{"/docs/" + nfc + "/", permgit.PatternFilterOptions{NormalizeUnicode: true}, "docs/" + nfd + "/a", true},
+		{"/docs/" + nfc + "/", permgit.PatternFilterOptions{CaseInsensitive: true}, "docs/" + strings.ToUpper(nfd) + "/a", false},
+		{"/docs/" + nfc + "/", permgit.PatternFilterOptions{CaseInsensitive: true, NormalizeUnicode: true}, "DOCS/" + strings.ToUpper(nfd) + "/a", true},
+	} {
+		paths := strings.Split(tc.path, "/")
+
+		f, err := permgit.NewPatternFilterWithOptions(tc.pattern, &tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.Filter(paths, false).IsIn(); got != tc.want {
+			t.Errorf("%s on %q with %+v: want %t, got %t", tc.pattern, tc.path, tc.opts, tc.want, got)
+		}
+
+		trie, err := permgit.NewPatternTrieFilterForPatternsWithOptions([]string{tc.pattern, "/other/"}, &tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := trie.Filter(paths, false).IsIn(); got != tc.want {
+			t.Errorf("trie %s on %q with %+v: want %t, got %t", tc.pattern, tc.path, tc.opts, tc.want, got)
+		}
+	}
+}
+
+func TestFindPathCollisions(t *testing.T) {
+	s := memory.NewStorage()
+	tree := buildTestTree(t, s, map[string]string{
+		"README.md":                       "a\n",
+		"README.MD":                       "b\n",
+		"docs/" + norm.NFC.String("café"): "c\n",
+		"docs/" + norm.NFD.String("café"): "d\n",
+		"docs/other":                      "e\n",
+		"src/a":                           "f\n",
+	})
+
+	collisions, err := permgit.FindPathCollisions(context.Background(), tree, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collisions) != 2 {
+		t.Fatalf("want 2 collisions, got %v", collisions)
+	}
+	for _, c := range collisions {
+		if len(c.Names) != 2 {
+			t.Errorf("unexpected collision: %s", c.String())
+		}
+	}
+
+	collisions, err = permgit.FindPathCollisions(context.Background(), tree, &permgit.PatternFilterOptions{NormalizeUnicode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collisions) != 1 || collisions[0].Dir != "docs" {
+		t.Errorf("want only the collision in docs, got %v", collisions)
+	}
+}
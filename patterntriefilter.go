@@ -0,0 +1,131 @@
+package permgit
+
+import (
+	"strings"
+)
+
+// PatternTrieFilter is a set of [PatternFilter] compiled into a trie over the path segments, it gives the same results as [OrFilter] of the patterns.
+//
+// Patterns are indexed by their leading literal segments, the segments before the first one with wildcards or '**'.
+// Only the patterns whose literal segments match the leading segments of the path are evaluated,
+// so the cost depends on the depth of the path and the patterns sharing its prefix, rather than the number of patterns.
+// Patterns starting with wildcards are grouped at the root and evaluated for every path.
+//
+// The literal segments are indexed with the [PatternFilterOptions] of the first pattern,
+// patterns with different options are grouped at the root.
+type PatternTrieFilter struct {
+	root     *patternTrieNode
+	patterns []*PatternFilter
+
+	options   PatternFilterOptions
+	normalize func(string) string
+}
+
+var _ Filter = (*PatternTrieFilter)(nil)
+
+type patternTrieNode struct {
+	children map[string]*patternTrieNode
+	// patterns are the patterns whose literal segments end at this node.
+	patterns []*PatternFilter
+	// hasDeeper indicates there are patterns in the descendants of this node.
+	hasDeeper bool
+}
+
+// NewPatternTrieFilter compiles the patterns into a [PatternTrieFilter].
+func NewPatternTrieFilter(patterns ...*PatternFilter) *PatternTrieFilter {
+	f := &PatternTrieFilter{
+		root:     &patternTrieNode{},
+		patterns: patterns,
+	}
+	if len(patterns) > 0 {
+		f.options = patterns[0].options
+		f.normalize = patterns[0].normalize
+	}
+	for _, p := range patterns {
+		f.add(p)
+	}
+
+	return f
+}
+
+// NewPatternTrieFilterForPatterns creates a [PatternTrieFilter] for the patterns, like [NewOrFilterForPatterns].
+func NewPatternTrieFilterForPatterns(patterns ...string) (*PatternTrieFilter, error) {
+	return NewPatternTrieFilterForPatternsWithOptions(patterns, nil)
+}
+
+// NewPatternTrieFilterForPatternsWithOptions creates a [PatternTrieFilter] for the patterns with the [PatternFilterOptions].
+func NewPatternTrieFilterForPatternsWithOptions(patterns []string, opts *PatternFilterOptions) (*PatternTrieFilter, error) {
+	filters := make([]*PatternFilter, 0, len(patterns))
+	for _, v := range patterns {
+		p, err := NewPatternFilterWithOptions(v, opts)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, p)
+	}
+
+	return NewPatternTrieFilter(filters...), nil
+}
+
+// isLiteralSegment checks if the segment only matches itself.
+func isLiteralSegment(seg PatternFilterSegment) bool {
+	return !strings.ContainsAny(string(seg), `*?[\`)
+}
+
+func (f *PatternTrieFilter) add(p *PatternFilter) {
+	node := f.root
+	if p.options != f.options {
+		node.patterns = append(node.patterns, p)
+		return
+	}
+	for _, seg := range p.filterSegments {
+		if !isLiteralSegment(seg) {
+			break
+		}
+		node.hasDeeper = true
+		child, found := node.children[string(seg)]
+		if !found {
+			if node.children == nil {
+				node.children = make(map[string]*patternTrieNode)
+			}
+			child = &patternTrieNode{}
+			node.children[string(seg)] = child
+		}
+		node = child
+	}
+
+	node.patterns = append(node.patterns, p)
+}
+
+// Filter walks the trie along the path and evaluates the patterns on the way.
+// Patterns deeper than the path have all their literal segments matching the path, and dive into the directory.
+func (f *PatternTrieFilter) Filter(paths []string, isdir bool) FilterResult {
+	keys := paths
+	if f.normalize != nil {
+		keys = normalizePaths(paths, f.normalize)
+	}
+
+	r := FilterResult_Out
+	node := f.root
+	for depth := 0; ; depth++ {
+		for _, p := range node.patterns {
+			r = max(r, p.Filter(paths, isdir))
+			if r == FilterResult_In {
+				return r
+			}
+		}
+
+		if depth == len(paths) {
+			if isdir && node.hasDeeper {
+				r = max(r, FilterResult_DirDive)
+			}
+			return r
+		}
+
+		child, found := node.children[keys[depth]]
+		if !found {
+			return r
+		}
+		node = child
+	}
+}
@@ -0,0 +1,128 @@
+package permgit_test
+
+import (
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/fardream/permgit"
+)
+
+// testPatternSet generates patterns from the test file names: the files, their directories, and wildcards on the extensions.
+func testPatternSet() []string {
+	var patterns []string
+	seen := make(map[string]bool)
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			patterns = append(patterns, p)
+		}
+	}
+
+	for _, line := range strings.Split(testfilenames, "\n") {
+		if line == "" {
+			continue
+		}
+		add("/" + line)
+		dir, ext := path.Dir(line), path.Ext(line)
+		if dir != "." {
+			add(dir + "/")
+			if ext != "" {
+				add(dir + "/*" + ext)
+				add(strings.SplitN(dir, "/", 2)[0] + "/**/*" + ext)
+			}
+		}
+	}
+	add("*.md")
+	add("**/src/")
+	add("aptos/*/src")
+	add("LICENSE_*")
+
+	return patterns
+}
+
+// testPathSet returns the test file names and all their directories.
+func testPathSet() ([][]string, []bool) {
+	var paths [][]string
+	var isdirs []bool
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(testfilenames, "\n") {
+		if line == "" {
+			continue
+		}
+		segs := strings.Split(line, "/")
+		for i := 1; i < len(segs); i++ {
+			dir := strings.Join(segs[:i], "/")
+			if !seen[dir] {
+				seen[dir] = true
+				paths = append(paths, segs[:i])
+				isdirs = append(isdirs, true)
+			}
+		}
+		paths = append(paths, segs)
+		isdirs = append(isdirs, false)
+	}
+
+	return paths, isdirs
+}
+
+func TestPatternTrieFilter(t *testing.T) {
+	all := testPatternSet()
+	paths, isdirs := testPathSet()
+
+	for _, patterns := range [][]string{
+		{"/aptos/**/*.js", "/aptos/**/src/", "/aptos/**/lib.rs", "/LICENSE", "/LICENSE_*"},
+		{"aptos/api/", "aptos/api/aux-ts/.yarn/", "*.md", "aptos/*/Move.toml"},
+		all[:len(all)/7],
+		all,
+	} {
+		want, err := permgit.NewOrFilterForPatterns(patterns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := permgit.NewPatternTrieFilterForPatterns(patterns...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, p := range paths {
+			if w, g := want.Filter(p, isdirs[i]), got.Filter(p, isdirs[i]); w != g {
+				t.Errorf("%d patterns, path %s (dir: %t): want %s, got %s", len(patterns), strings.Join(p, "/"), isdirs[i], w, g)
+			}
+		}
+	}
+}
+
+func benchmarkFilter(b *testing.B, filter permgit.Filter) {
+	paths, isdirs := testPathSet()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i, p := range paths {
+			filter.Filter(p, isdirs[i])
+		}
+	}
+}
+
+func BenchmarkOrFilter(b *testing.B) {
+	patterns := testPatternSet()
+	filters := make([]permgit.Filter, 0, len(patterns))
+	for _, v := range patterns {
+		f, err := permgit.NewPatternFilter(v)
+		if err != nil {
+			b.Fatal(err)
+		}
+		filters = append(filters, f)
+	}
+
+	benchmarkFilter(b, permgit.NewOrFilter(filters...))
+}
+
+func BenchmarkPatternTrieFilter(b *testing.B) {
+	filter, err := permgit.NewPatternTrieFilterForPatterns(testPatternSet()...)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	benchmarkFilter(b, filter)
+}
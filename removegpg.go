@@ -3,49 +3,129 @@ package permgit
 import (
 	"context"
 	"fmt"
+	"slices"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 )
 
+// RemoveGPGOptions contains the options for [RemoveGPGForLinearHistoryWithOptions].
+type RemoveGPGOptions struct {
+	// Mailmap maps the authors and committers of the commits.
+	Mailmap *Mailmap
+	// Signer re-signs the recreated commits with a new key.
+	Signer Signer
+
+	// Verifier verifies the signatures of the original commits before they are stripped, the results are collected in the verifier.
+	Verifier *SignatureVerifier
+	// AbortOn lists the statuses of the original signatures that abort the rewriting with [UnverifiedSignatureError].
+	// It requires Verifier.
+	AbortOn []SignatureStatus
+	// SignatureTrailer is the key of the trailer recording the status of the original signature in the recreated commit,
+	// for example "Original-Signature" adds "Original-Signature: valid <key id> <signer>". It requires Verifier.
+	SignatureTrailer string
+
+	// RewriteFrom is the first commit in the history to rewrite, the commits before it are kept untouched and returned as is.
+	// Zero value rewrites the whole history.
+	RewriteFrom plumbing.Hash
+}
+
+// signatureTrailer formats the trailer recording the result of the verification.
+func signatureTrailer(key string, v *SignatureVerification) string {
+	r := fmt.Sprintf("%s: %s", key, v.Status)
+	if v.KeyID != "" {
+		r += " " + v.KeyID
+	}
+	if v.Signer != "" {
+		r += " " + v.Signer
+	}
+
+	return r
+}
+
+// RemoveGPGForLinearHistory recreates the commits of a linear history without the gpg signatures.
+// All the parents of the first commit are kept, which has no parent if it is a root commit.
+// Each of the following commits has the previous recreated commit as its first parent,
+// and its other parents are replaced by the recreated commits if they are in the history.
 func RemoveGPGForLinearHistory(ctx context.Context, hist []*object.Commit, s storer.Storer) ([]*object.Commit, error) {
+	return RemoveGPGForLinearHistoryWithOptions(ctx, hist, s, nil)
+}
+
+// RemoveGPGForLinearHistoryWithOptions is [RemoveGPGForLinearHistory] with [RemoveGPGOptions],
+// nil opts is the same as a zero [RemoveGPGOptions].
+// If [RemoveGPGOptions.Verifier] is set, the signatures of the commits are verified before they are stripped.
+func RemoveGPGForLinearHistoryWithOptions(ctx context.Context, hist []*object.Commit, s storer.Storer, opts *RemoveGPGOptions) ([]*object.Commit, error) {
+	if opts == nil {
+		opts = &RemoveGPGOptions{}
+	}
+
+	start := 0
+	if !opts.RewriteFrom.IsZero() {
+		start = slices.IndexFunc(hist, func(c *object.Commit) bool { return c.Hash == opts.RewriteFrom })
+		if start < 0 {
+			return nil, fmt.Errorf("commit to rewrite from %s is not in the history", opts.RewriteFrom)
+		}
+	}
+
 	newhist := make([]*object.Commit, 0, len(hist))
+	newhist = append(newhist, hist[:start]...)
+
+	// rewritten maps the hashes of the original commits to the recreated commits.
+	rewritten := make(map[plumbing.Hash]plumbing.Hash, len(hist)-start)
 
 	var prevcommit *object.Commit
 
-	for i, v := range hist {
+	for i, v := range hist[start:] {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
 		default:
 		}
 
-		var parenthashses []plumbing.Hash
-		if prevcommit == nil {
-			parenthashses = append(parenthashses, v.ParentHashes[0])
-		} else {
-			parenthashses = append(parenthashses, prevcommit.Hash)
+		parenthashses := make([]plumbing.Hash, 0, len(v.ParentHashes))
+		for j, p := range v.ParentHashes {
+			switch newp, found := rewritten[p]; {
+			case j == 0 && prevcommit != nil:
+				parenthashses = append(parenthashses, prevcommit.Hash)
+			case found:
+				parenthashses = append(parenthashses, newp)
+			default:
+				parenthashses = append(parenthashses, p)
+			}
+		}
+		if len(parenthashses) == 0 && prevcommit != nil {
+			return nil, fmt.Errorf("commit %s is a root commit in the middle of the history", v.Hash)
 		}
+		message := v.Message
+		if opts.Verifier != nil {
+			verification := opts.Verifier.Verify(v)
+			if slices.Contains(opts.AbortOn, verification.Status) {
+				return nil, &UnverifiedSignatureError{Verification: verification}
+			}
+			if opts.SignatureTrailer != "" {
+				message = appendTrailers(message, signatureTrailer(opts.SignatureTrailer, &verification))
+			}
+		}
+
 		newcommit := &object.Commit{
-			Author:       v.Author,
-			Committer:    v.Committer,
-			Message:      v.Message,
+			Author:       opts.Mailmap.Map(v.Author),
+			Committer:    opts.Mailmap.Map(v.Committer),
+			Message:      message,
 			TreeHash:     v.TreeHash,
 			ParentHashes: parenthashses,
 		}
 
-		newcommithash, err := GetHash(newcommit)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get hash for new commit: %w", err)
+		if err := signCommit(newcommit, opts.Signer); err != nil {
+			return nil, fmt.Errorf("failed to sign new commit: %w", err)
 		}
-		newcommit.Hash = *newcommithash
-		logger.Debug("remove gpgp", "id", i, "commit", v.Hash, "newcommit", newcommit.Hash)
+		logger.Debug("remove gpgp", "id", start+i, "commit", v.Hash, "newcommit", newcommit.Hash)
 		if err := updateHashAndSave(ctx, newcommit, s); err != nil {
 			return nil, fmt.Errorf("failed to save new commit %s to storage: %w", newcommit.Hash.String(), err)
 		}
 
 		newhist = append(newhist, newcommit)
+		rewritten[v.Hash] = newcommit.Hash
 		prevcommit = newcommit
 	}
 
@@ -0,0 +1,90 @@
+package permgit_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+const testFakeSignature = "-----BEGIN PGP SIGNATURE-----\n\nfake\n-----END PGP SIGNATURE-----\n"
+
+func TestRemoveGPGForLinearHistoryWithOptions_startPoints(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	newCommit := func(files map[string]string, parents ...*object.Commit) *object.Commit {
+		sig := object.Signature{Name: "a", Email: "a@example.com", When: time.Unix(1700000000, 0).UTC()}
+		c := &object.Commit{
+			Author:       sig,
+			Committer:    sig,
+			Message:      "commit\n",
+			TreeHash:     buildTestTree(t, s, files).Hash,
+			PGPSignature: testFakeSignature,
+		}
+		for _, p := range parents {
+			c.ParentHashes = append(c.ParentHashes, p.Hash)
+		}
+		return saveTestCommit(t, s, c)
+	}
+
+	root := newCommit(map[string]string{"a": "1\n"})
+	c1 := newCommit(map[string]string{"a": "2\n"}, root)
+	side := newCommit(map[string]string{"b": "1\n"}, root)
+	merge := newCommit(map[string]string{"a": "2\n", "b": "1\n"}, c1, side)
+	c2 := newCommit(map[string]string{"a": "3\n", "b": "1\n"}, merge)
+
+	for _, c := range []struct {
+		name        string
+		hist        []*object.Commit
+		rewriteFrom plumbing.Hash
+		kept        int
+		wantParents []plumbing.Hash
+	}{
+		{name: "root", hist: []*object.Commit{root, c1}, wantParents: nil},
+		{name: "mid-history", hist: []*object.Commit{c1, merge, c2}, wantParents: []plumbing.Hash{root.Hash}},
+		{name: "merge", hist: []*object.Commit{merge, c2}, wantParents: []plumbing.Hash{c1.Hash, side.Hash}},
+		{name: "subrange", hist: []*object.Commit{root, c1, merge, c2}, rewriteFrom: merge.Hash, kept: 2, wantParents: []plumbing.Hash{c1.Hash, side.Hash}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			newhist, err := permgit.RemoveGPGForLinearHistoryWithOptions(ctx, c.hist, s, &permgit.RemoveGPGOptions{RewriteFrom: c.rewriteFrom})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(newhist) != len(c.hist) {
+				t.Fatalf("want %d commits, got %d", len(c.hist), len(newhist))
+			}
+			for i := 0; i < c.kept; i++ {
+				if newhist[i].Hash != c.hist[i].Hash {
+					t.Errorf("commit %d in the prefix is rewritten", i)
+				}
+			}
+
+			first, err := object.GetCommit(s, newhist[c.kept].Hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(first.ParentHashes, c.wantParents) {
+				t.Errorf("want parents %v, got %v", c.wantParents, first.ParentHashes)
+			}
+			for i := c.kept; i < len(newhist); i++ {
+				if newhist[i].PGPSignature != "" || newhist[i].TreeHash != c.hist[i].TreeHash {
+					t.Errorf("commit %d is not recreated without signature", i)
+				}
+				if i > c.kept && newhist[i].ParentHashes[0] != newhist[i-1].Hash {
+					t.Errorf("commit %d is not on top of the previous recreated commit", i)
+				}
+			}
+		})
+	}
+
+	if _, err := permgit.RemoveGPGForLinearHistoryWithOptions(ctx, []*object.Commit{c1}, s, &permgit.RemoveGPGOptions{RewriteFrom: c2.Hash}); err == nil {
+		t.Errorf("expecting error for rewrite from commit not in the history")
+	}
+}
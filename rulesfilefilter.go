@@ -0,0 +1,197 @@
+package permgit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// DefaultRulesFile is the default path of the rules file in the source repo for [RulesFileFilter].
+const DefaultRulesFile = ".permgit"
+
+// RulesFilePolicy decides if the rules file itself is included in the filtered tree.
+type RulesFilePolicy string
+
+const (
+	RulesFilePolicy_Filter  RulesFilePolicy = "filter"  // the rules file is included only if it matches its own patterns.
+	RulesFilePolicy_Include RulesFilePolicy = "include" // the rules file is always included.
+	RulesFilePolicy_Exclude RulesFilePolicy = "exclude" // the rules file is always excluded.
+)
+
+// RulesFileFilter is a [CommitFilter] filtering each commit by the patterns in the rules file committed in the tree of the commit,
+// so the rules live in the source repo and change with its history.
+// The rules file has the format of the pattern file, see [LoadPatternStringFromString].
+// The fallback filter is used for the commits without the rules file.
+//
+// The compiled filters are cached by the hashes of the rules files, the cache is not concurrent safe.
+type RulesFileFilter struct {
+	path              string
+	fallback          Filter
+	policy            RulesFilePolicy
+	ignoreUnsupported bool
+	options           PatternFilterOptions
+
+	cache map[plumbing.Hash]Filter
+}
+
+var (
+	_ EntryFilter  = (*RulesFileFilter)(nil)
+	_ CommitFilter = (*RulesFileFilter)(nil)
+)
+
+// NewRulesFileFilter creates a new [RulesFileFilter] reading the rules file at the path of the trees, empty path is [DefaultRulesFile].
+// Empty policy is [RulesFilePolicy_Filter], and ignoreUnsupported is passed to [LoadPatternStringFromString].
+// The patterns in the rules file are compiled with opts, and nil opts is the same as a zero [PatternFilterOptions].
+func NewRulesFileFilter(path string, fallback Filter, policy RulesFilePolicy, ignoreUnsupported bool, opts *PatternFilterOptions) (*RulesFileFilter, error) {
+	if opts == nil {
+		opts = &PatternFilterOptions{}
+	}
+
+	if path == "" {
+		path = DefaultRulesFile
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil, fmt.Errorf("invalid rules file path")
+	}
+
+	switch policy {
+	case "":
+		policy = RulesFilePolicy_Filter
+	case RulesFilePolicy_Filter, RulesFilePolicy_Include, RulesFilePolicy_Exclude:
+	default:
+		return nil, fmt.Errorf("unknown rules file policy: %s", policy)
+	}
+
+	return &RulesFileFilter{
+		path:              path,
+		fallback:          fallback,
+		policy:            policy,
+		ignoreUnsupported: ignoreUnsupported,
+		options:           *opts,
+		cache:             make(map[plumbing.Hash]Filter),
+	}, nil
+}
+
+// Filter applies the fallback filter, since the commit is not known.
+func (f *RulesFileFilter) Filter(paths []string, isdir bool) FilterResult {
+	return f.fallback.Filter(paths, isdir)
+}
+
+// FilterEntry applies the fallback filter, since the commit is not known.
+func (f *RulesFileFilter) FilterEntry(paths []string, entry *object.TreeEntry) FilterResult {
+	return AsEntryFilter(f.fallback).FilterEntry(paths, entry)
+}
+
+// FilterForCommit returns the filter compiled from the rules file in the tree of the commit,
+// or the fallback filter, resolved for the commit if it is a [CommitFilter], if the rules file does not exist.
+func (f *RulesFileFilter) FilterForCommit(ctx context.Context, c *object.Commit) (Filter, error) {
+	t, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain tree for commit %s: %w", c.Hash, err)
+	}
+
+	filter, found, err := f.rulesFileFilter(t)
+	if err != nil || found {
+		return filter, err
+	}
+
+	return filterForCommit(ctx, f.fallback, c)
+}
+
+// FilterForTree returns the filter compiled from the rules file in the tree,
+// or the fallback filter, resolved for the tree by [FilterForTree], if the rules file does not exist.
+func (f *RulesFileFilter) FilterForTree(ctx context.Context, t *object.Tree) (Filter, error) {
+	filter, found, err := f.rulesFileFilter(t)
+	if err != nil || found {
+		return filter, err
+	}
+
+	return FilterForTree(ctx, f.fallback, t)
+}
+
+// rulesFileFilter returns the filter compiled from the rules file in the tree, and false if the rules file does not exist.
+func (f *RulesFileFilter) rulesFileFilter(t *object.Tree) (Filter, bool, error) {
+	entry, err := t.FindEntry(f.path)
+	if errors.Is(err, object.ErrEntryNotFound) || errors.Is(err, object.ErrDirectoryNotFound) || (err == nil && !entry.Mode.IsFile()) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to find rules file %s: %w", f.path, err)
+	}
+
+	if filter, found := f.cache[entry.Hash]; found {
+		return filter, true, nil
+	}
+
+	file, err := t.TreeEntryFile(entry)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to obtain rules file %s: %w", f.path, err)
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read rules file %s: %w", f.path, err)
+	}
+	patterns, err := LoadPatternStringFromString(content, f.ignoreUnsupported)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse rules file %s (blob %s): %w", f.path, entry.Hash, err)
+	}
+	var filter Filter
+	filter, err = NewPatternTrieFilterForPatternsWithOptions(patterns, &f.options)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to compile rules file %s (blob %s): %w", f.path, entry.Hash, err)
+	}
+
+	if f.policy != RulesFilePolicy_Filter {
+		filter = &rulesFileOverride{
+			filter:  AsEntryFilter(filter),
+			paths:   strings.Split(f.path, "/"),
+			include: f.policy == RulesFilePolicy_Include,
+		}
+	}
+
+	logger.Debug("compiled rules file", "path", f.path, "blob", entry.Hash, "patterns", len(patterns))
+	f.cache[entry.Hash] = filter
+
+	return filter, true, nil
+}
+
+// rulesFileOverride always includes or excludes the rules file, and applies the filter to the other paths.
+type rulesFileOverride struct {
+	filter  EntryFilter
+	paths   []string
+	include bool
+}
+
+var _ EntryFilter = (*rulesFileOverride)(nil)
+
+func (f *rulesFileOverride) Filter(paths []string, isdir bool) FilterResult {
+	return f.override(paths, isdir, f.filter.Filter(paths, isdir))
+}
+
+func (f *rulesFileOverride) FilterEntry(paths []string, entry *object.TreeEntry) FilterResult {
+	return f.override(paths, entry.Mode == filemode.Dir, f.filter.FilterEntry(paths, entry))
+}
+
+func (f *rulesFileOverride) override(paths []string, isdir bool, r FilterResult) FilterResult {
+	switch {
+	case !isdir && slices.Equal(paths, f.paths):
+		if f.include {
+			return FilterResult_In
+		}
+		return FilterResult_Out
+	case isdir && len(paths) < len(f.paths) && slices.Equal(paths, f.paths[:len(paths)]):
+		// the directories containing the rules file are dived into to reach it.
+		if f.include && r == FilterResult_Out || !f.include && r == FilterResult_In {
+			return FilterResult_DirDive
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,120 @@
+package permgit_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func TestRulesFileFilter(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	trees := []map[string]string{
+		{"pub/a": "a\n", "internal/b": "b\n"},
+		{"pub/a": "a\n", "internal/b": "b\n", ".permgit": "pub/\n"},
+		{"pub/a": "a\n", "internal/b": "b\n", ".permgit": "pub/\n# internal is public now\ninternal/\n"},
+	}
+	var hist []*object.Commit
+	for i, files := range trees {
+		sig := object.Signature{Name: "a", Email: "a@example.com", When: time.Unix(1700000000+int64(i), 0).UTC()}
+		c := &object.Commit{Author: sig, Committer: sig, Message: "commit\n", TreeHash: buildTestTree(t, s, files).Hash}
+		if i > 0 {
+			c.ParentHashes = []plumbing.Hash{hist[i-1].Hash}
+		}
+		hist = append(hist, saveTestCommit(t, s, c))
+	}
+
+	fallback, err := permgit.NewOrFilterForPatterns("pub/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		policy permgit.RulesFilePolicy
+		want   [][]string
+	}{
+		{
+			policy: permgit.RulesFilePolicy_Filter,
+			want:   [][]string{{"pub/a"}, {"internal/b", "pub/a"}},
+		},
+		{
+			policy: permgit.RulesFilePolicy_Include,
+			want:   [][]string{{"pub/a"}, {".permgit", "pub/a"}, {".permgit", "internal/b", "pub/a"}},
+		},
+		{
+			policy: permgit.RulesFilePolicy_Exclude,
+			want:   [][]string{{"pub/a"}, {"internal/b", "pub/a"}},
+		},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			filter, err := permgit.NewRulesFileFilter("", fallback, tc.policy, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			newhist, err := permgit.FilterLinearHistory(ctx, hist, s, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][]string
+			for _, c := range newhist {
+				tree, err := object.GetTree(s, c.TreeHash)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var paths []string
+				for p := range readTestTree(t, tree) {
+					paths = append(paths, p)
+				}
+				slices.Sort(paths)
+				got = append(got, paths)
+			}
+			if !slices.EqualFunc(got, tc.want, slices.Equal) {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	if _, err := permgit.NewRulesFileFilter("", fallback, "unknown", false, nil); err == nil {
+		t.Errorf("unknown policy should fail")
+	}
+}
+
+func TestRulesFileFilter_options(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	c := newTestCommit(t, s, map[string]string{"pub/a": "a\n", "internal/b": "b\n", ".permgit": "PUB/\n"}, 0)
+	fallback, err := permgit.NewOrFilterForPatterns("internal/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		opts *permgit.PatternFilterOptions
+		want permgit.FilterResult
+	}{
+		{nil, permgit.FilterResult_Out},
+		{&permgit.PatternFilterOptions{CaseInsensitive: true}, permgit.FilterResult_In},
+	} {
+		rulesfile, err := permgit.NewRulesFileFilter("", fallback, permgit.RulesFilePolicy_Exclude, false, tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := rulesfile.FilterForCommit(ctx, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := filter.Filter([]string{"pub", "a"}, false); got != tc.want {
+			t.Errorf("pub/a with %+v: want %s, got %s", tc.opts, tc.want, got)
+		}
+	}
+}
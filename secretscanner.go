@@ -0,0 +1,329 @@
+package permgit
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// SecretRule detects a kind of secret in the content of a file.
+// The content matches if Regexp matches, and the Shannon entropy in bits per byte of the match is no less than MinEntropy.
+// If the regular expression has a capture group, the entropy is computed on the first group instead of the whole match.
+type SecretRule struct {
+	Name       string
+	Regexp     *regexp.Regexp
+	MinEntropy float64
+}
+
+// DefaultSecretRules returns the built-in rules for common secret formats:
+// AWS access keys, GCP service account keys and api keys, private keys, and generic high entropy secrets assigned to secret-like names.
+func DefaultSecretRules() []SecretRule {
+	return []SecretRule{
+		{
+			Name:   "aws-access-key-id",
+			Regexp: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|ANPA|ANVA)[0-9A-Z]{16}\b`),
+		},
+		{
+			Name:       "aws-secret-access-key",
+			Regexp:     regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`),
+			MinEntropy: 4,
+		},
+		{
+			Name:   "gcp-service-account-key",
+			Regexp: regexp.MustCompile(`"type"\s*:\s*"service_account"`),
+		},
+		{
+			Name:   "gcp-api-key",
+			Regexp: regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}\b`),
+		},
+		{
+			Name:   "private-key",
+			Regexp: regexp.MustCompile(`-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY(?: BLOCK)?-----`),
+		},
+		{
+			Name:       "generic-secret",
+			Regexp:     regexp.MustCompile(`(?i)(?:secret|token|passwd|password|api_?key)["']?\s*[:=]\s*["']?([A-Za-z0-9/+=_\-]{20,})`),
+			MinEntropy: 4.5,
+		},
+	}
+}
+
+// shannonEntropy computes the Shannon entropy in bits per byte.
+func shannonEntropy(b []byte) float64 {
+	if len(b) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, c := range b {
+		counts[c]++
+	}
+
+	n := float64(len(b))
+	r := 0.0
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		r -= p * math.Log2(p)
+	}
+
+	return r
+}
+
+// SecretRuleConfig is the json representation of a [SecretRule].
+type SecretRuleConfig struct {
+	Name       string  `json:"name"`
+	Regex      string  `json:"regex"`
+	MinEntropy float64 `json:"min-entropy,omitempty"`
+}
+
+// LoadSecretRules parses a json array of [SecretRuleConfig].
+func LoadSecretRules(content []byte) ([]SecretRule, error) {
+	var configs []SecretRuleConfig
+	if err := json.Unmarshal(content, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse secret rules: %w", err)
+	}
+
+	rules := make([]SecretRule, 0, len(configs))
+	for i, c := range configs {
+		if c.Name == "" {
+			return nil, fmt.Errorf("secret rule %d has no name", i)
+		}
+		r, err := regexp.Compile(c.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile regex of secret rule %s: %w", c.Name, err)
+		}
+		rules = append(rules, SecretRule{
+			Name:       c.Name,
+			Regexp:     r,
+			MinEntropy: c.MinEntropy,
+		})
+	}
+
+	return rules, nil
+}
+
+// SecretAllowlist suppresses false positive findings of [SecretScanner].
+type SecretAllowlist struct {
+	blobs   map[plumbing.Hash]struct{}
+	paths   []Filter
+	matches []*regexp.Regexp
+}
+
+// LoadSecretAllowlist parses the allowlist, each line is one of
+//
+//	blob <hash>      all findings in the blob
+//	path <pattern>   all findings in files matching the pattern, see [NewPatternFilter]
+//	match <regex>    findings whose matched text matches the regex
+//
+// '#' and blank lines are ignored.
+func LoadSecretAllowlist(content string) (*SecretAllowlist, error) {
+	r := &SecretAllowlist{
+		blobs: make(map[plumbing.Hash]struct{}),
+	}
+
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kind, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return nil, fmt.Errorf("line %d of allowlist has no value: %s", i+1, line)
+		}
+
+		switch kind {
+		case "blob":
+			if len(value) != 40 {
+				return nil, fmt.Errorf("line %d of allowlist has invalid hash: %s", i+1, value)
+			}
+			if _, err := hex.DecodeString(value); err != nil {
+				return nil, fmt.Errorf("line %d of allowlist has invalid hash %s: %w", i+1, value, err)
+			}
+			r.blobs[plumbing.NewHash(value)] = struct{}{}
+		case "path":
+			f, err := NewPatternFilter(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d of allowlist has invalid pattern: %w", i+1, err)
+			}
+			r.paths = append(r.paths, f)
+		case "match":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d of allowlist has invalid regex: %w", i+1, err)
+			}
+			r.matches = append(r.matches, re)
+		default:
+			return nil, fmt.Errorf("line %d of allowlist has unknown kind: %s", i+1, kind)
+		}
+	}
+
+	return r, nil
+}
+
+func (a *SecretAllowlist) allowsBlob(paths []string, hash plumbing.Hash) bool {
+	if a == nil {
+		return false
+	}
+	if _, found := a.blobs[hash]; found {
+		return true
+	}
+	for _, f := range a.paths {
+		if f.Filter(paths, false).IsIn() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *SecretAllowlist) allowsMatch(match []byte) bool {
+	if a == nil {
+		return false
+	}
+	for _, re := range a.matches {
+		if re.Match(match) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SecretFinding is a secret found by [SecretScanner]. The secret itself is not recorded.
+type SecretFinding struct {
+	// Commit is the commit in the source repo where the blob is first included.
+	Commit plumbing.Hash
+	Path   string
+	Blob   plumbing.Hash
+	Rule   string
+	// Line is the 1-based line number of the start of the match.
+	Line int
+}
+
+func (f *SecretFinding) String() string {
+	return fmt.Sprintf("%s %s:%d (blob %s) matches %s", f.Commit, f.Path, f.Line, f.Blob, f.Rule)
+}
+
+// SecretsFoundError is returned when [SecretScanner] finds secrets.
+type SecretsFoundError struct {
+	Findings []SecretFinding
+}
+
+func (e *SecretsFoundError) Error() string {
+	lines := make([]string, 0, len(e.Findings)+1)
+	lines = append(lines, fmt.Sprintf("found %d secrets:", len(e.Findings)))
+	for i := range e.Findings {
+		lines = append(lines, e.Findings[i].String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// SecretScanner scans the blobs included in the filtered repo for secrets, whether or not they are already in the storer.
+// Each blob passing the scan is only scanned once, and the commit being filtered is recorded in the findings.
+// The scanner is not concurrent safe.
+type SecretScanner struct {
+	rules     []SecretRule
+	allowlist *SecretAllowlist
+
+	commit   plumbing.Hash
+	scanned  map[plumbing.Hash]struct{}
+	findings []SecretFinding
+}
+
+// NewSecretScanner creates a new [SecretScanner] with the rules, the allowlist can be nil.
+func NewSecretScanner(rules []SecretRule, allowlist *SecretAllowlist) *SecretScanner {
+	return &SecretScanner{
+		rules:     rules,
+		allowlist: allowlist,
+		scanned:   make(map[plumbing.Hash]struct{}),
+	}
+}
+
+// Findings returns the secrets found so far.
+func (s *SecretScanner) Findings() []SecretFinding {
+	return s.findings
+}
+
+// Err returns [SecretsFoundError] if any secret is found, otherwise nil.
+func (s *SecretScanner) Err() error {
+	if s == nil || len(s.findings) == 0 {
+		return nil
+	}
+
+	return &SecretsFoundError{Findings: s.findings}
+}
+
+// setCommit sets the commit recorded in the findings.
+func (s *SecretScanner) setCommit(commit plumbing.Hash) {
+	if s != nil {
+		s.commit = commit
+	}
+}
+
+// scan scans the blob with the hash at the path, the content of the blob is obtained by read.
+func (s *SecretScanner) scan(paths []string, hash plumbing.Hash, read func() ([]byte, error)) error {
+	if s == nil {
+		return nil
+	}
+	if _, found := s.scanned[hash]; found {
+		return nil
+	}
+	// the blob is not marked as scanned when allowed by path, it can appear at other paths.
+	if s.allowlist.allowsBlob(paths, hash) {
+		return nil
+	}
+
+	content, err := read()
+	if err != nil {
+		return fmt.Errorf("failed to read %s for scanning: %w", pathsToFullPath(paths), err)
+	}
+	if isBinary(content) {
+		s.scanned[hash] = struct{}{}
+		return nil
+	}
+
+	nfindings := len(s.findings)
+	for _, rule := range s.rules {
+		for _, loc := range rule.Regexp.FindAllSubmatchIndex(content, -1) {
+			match := content[loc[0]:loc[1]]
+			measured := match
+			if len(loc) >= 4 && loc[2] >= 0 {
+				measured = content[loc[2]:loc[3]]
+			}
+			if rule.MinEntropy > 0 && shannonEntropy(measured) < rule.MinEntropy {
+				continue
+			}
+			if s.allowlist.allowsMatch(match) {
+				continue
+			}
+
+			finding := SecretFinding{
+				Commit: s.commit,
+				Path:   pathsToFullPath(paths),
+				Blob:   hash,
+				Rule:   rule.Name,
+				Line:   bytes.Count(content[:loc[0]], []byte("\n")) + 1,
+			}
+			logger.Warn("found secret", "commit", finding.Commit, "path", finding.Path, "line", finding.Line, "rule", finding.Rule)
+			s.findings = append(s.findings, finding)
+		}
+	}
+	if len(s.findings) == nfindings {
+		// only the blobs passing the scan are skipped later, the others are reported at every path and commit.
+		s.scanned[hash] = struct{}{}
+	}
+
+	return nil
+}
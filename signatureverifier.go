@@ -0,0 +1,204 @@
+package permgit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// SignatureStatus is the result of verifying the signature of a commit.
+type SignatureStatus string
+
+const (
+	SignatureStatus_Unsigned   SignatureStatus = "unsigned"    // the commit has no signature.
+	SignatureStatus_Valid      SignatureStatus = "valid"       // the signature is made by a key in the keyring, and the key is neither expired nor revoked.
+	SignatureStatus_Invalid    SignatureStatus = "invalid"     // the signature is malformed, doesn't match the commit, or the key is expired or revoked.
+	SignatureStatus_UnknownKey SignatureStatus = "unknown-key" // the key of the signature is not in the keyring, or the signature is not an OpenPGP signature.
+)
+
+// SignatureVerification is the result of verifying the signature of a commit by [SignatureVerifier].
+type SignatureVerification struct {
+	Commit plumbing.Hash
+	Status SignatureStatus
+	// KeyID is the hex id of the key making the signature, empty if unknown.
+	KeyID string
+	// Signer is the primary identity of the key for valid signatures, like "Name <email>".
+	Signer string
+	// Err is the reason the signature is invalid or unknown.
+	Err error
+}
+
+func (v *SignatureVerification) String() string {
+	r := fmt.Sprintf("%s %s", v.Commit, v.Status)
+	if v.KeyID != "" {
+		r += " " + v.KeyID
+	}
+	if v.Signer != "" {
+		r += " " + v.Signer
+	}
+	if v.Err != nil {
+		r += ": " + v.Err.Error()
+	}
+
+	return r
+}
+
+// UnverifiedSignatureError is returned when the signature of a commit has a status that aborts the rewriting.
+type UnverifiedSignatureError struct {
+	Verification SignatureVerification
+}
+
+func (e *UnverifiedSignatureError) Error() string {
+	return fmt.Sprintf("signature of commit is not accepted: %s", e.Verification.String())
+}
+
+// LoadOpenPGPKeyring reads the public keys from the content, which is either binary or one or more concatenated armored key blocks,
+// such as the output of gpg --export --armor.
+func LoadOpenPGPKeyring(content []byte) (openpgp.EntityList, error) {
+	if !bytes.Contains(content, []byte("-----BEGIN PGP")) {
+		r, err := openpgp.ReadKeyRing(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keyring: %w", err)
+		}
+		return r, nil
+	}
+
+	var r openpgp.EntityList
+	in := bytes.NewReader(content)
+	for {
+		block, err := armor.Decode(in)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode armored keyring: %w", err)
+		}
+		if block.Type != openpgp.PublicKeyType && block.Type != openpgp.PrivateKeyType {
+			return nil, fmt.Errorf("unexpected armored block in keyring: %s", block.Type)
+		}
+		entities, err := openpgp.ReadKeyRing(block.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keyring: %w", err)
+		}
+		r = append(r, entities...)
+	}
+
+	if len(r) == 0 {
+		return nil, fmt.Errorf("no key found in keyring")
+	}
+
+	return r, nil
+}
+
+// SignatureVerifier verifies the OpenPGP signatures of commits against a keyring, and collects the results.
+// The verifier is not concurrent safe.
+type SignatureVerifier struct {
+	keyring openpgp.KeyRing
+
+	results []SignatureVerification
+}
+
+// NewSignatureVerifier creates a new [SignatureVerifier] with the trusted keys.
+func NewSignatureVerifier(keyring openpgp.KeyRing) *SignatureVerifier {
+	return &SignatureVerifier{
+		keyring: keyring,
+	}
+}
+
+// Results returns the results of the commits verified so far.
+func (v *SignatureVerifier) Results() []SignatureVerification {
+	return v.results
+}
+
+// Verify verifies the signature of the commit and records the result.
+// The key is checked for expiry and revocation at the current time rather than the creation time of the signature,
+// which is set by the signer, so a backdated signature made by an expired or revoked key is invalid.
+func (v *SignatureVerifier) Verify(c *object.Commit) SignatureVerification {
+	r := v.verify(c)
+	logger.Debug("verify signature", "commit", r.Commit, "status", r.Status, "key", r.KeyID)
+	v.results = append(v.results, r)
+
+	return r
+}
+
+func (v *SignatureVerifier) verify(c *object.Commit) SignatureVerification {
+	r := SignatureVerification{Commit: c.Hash}
+
+	if c.PGPSignature == "" {
+		r.Status = SignatureStatus_Unsigned
+		return r
+	}
+	if !strings.Contains(c.PGPSignature, "-----BEGIN PGP SIGNATURE-----") {
+		r.Status = SignatureStatus_UnknownKey
+		r.Err = fmt.Errorf("not an openpgp signature")
+		return r
+	}
+
+	sig, err := parseOpenPGPSignature(c.PGPSignature)
+	if err != nil {
+		r.Status = SignatureStatus_Invalid
+		r.Err = err
+		return r
+	}
+	if sig.IssuerKeyId != nil {
+		r.KeyID = fmt.Sprintf("%016X", *sig.IssuerKeyId)
+	}
+
+	payload := &plumbing.MemoryObject{}
+	if err := c.EncodeWithoutSignature(payload); err != nil {
+		r.Status = SignatureStatus_Invalid
+		r.Err = fmt.Errorf("failed to encode commit: %w", err)
+		return r
+	}
+	signed, err := payload.Reader()
+	if err != nil {
+		r.Status = SignatureStatus_Invalid
+		r.Err = fmt.Errorf("failed to read encoded commit: %w", err)
+		return r
+	}
+	defer signed.Close()
+
+	entity, err := openpgp.CheckArmoredDetachedSignature(v.keyring, signed, strings.NewReader(c.PGPSignature), nil)
+	switch {
+	case errors.Is(err, pgperrors.ErrUnknownIssuer):
+		r.Status = SignatureStatus_UnknownKey
+		r.Err = err
+	case err != nil:
+		r.Status = SignatureStatus_Invalid
+		r.Err = err
+	default:
+		r.Status = SignatureStatus_Valid
+		if i := entity.PrimaryIdentity(); i != nil {
+			r.Signer = i.Name
+		}
+	}
+
+	return r
+}
+
+// parseOpenPGPSignature reads the first signature packet of the armored signature.
+func parseOpenPGPSignature(armored string) (*packet.Signature, error) {
+	block, err := armor.Decode(strings.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode armored signature: %w", err)
+	}
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature packet: %w", err)
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return nil, fmt.Errorf("unexpected packet %T in signature", p)
+	}
+
+	return sig, nil
+}
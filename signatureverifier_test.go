@@ -0,0 +1,164 @@
+package permgit_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func TestRemoveGPGForLinearHistoryWithOptions_verify(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	trustedPrivate, trustedPublic := newTestOpenPGPKey(t, "trusted")
+	otherPrivate, _ := newTestOpenPGPKey(t, "other")
+	trusted, err := permgit.LoadSigner([]byte(trustedPrivate), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := permgit.LoadSigner([]byte(otherPrivate), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := buildTestTree(t, s, map[string]string{"a": "a\n"})
+	newCommit := func(parent plumbing.Hash, message string, signer permgit.Signer) *object.Commit {
+		sig := object.Signature{Name: "a", Email: "a@example.com", When: time.Unix(1700000000, 0).UTC()}
+		c := &object.Commit{Author: sig, Committer: sig, Message: message, TreeHash: tree.Hash}
+		if !parent.IsZero() {
+			c.ParentHashes = []plumbing.Hash{parent}
+		}
+		if signer != nil {
+			payload := &plumbing.MemoryObject{}
+			if err := c.EncodeWithoutSignature(payload); err != nil {
+				t.Fatal(err)
+			}
+			r, err := payload.Reader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signature, err := signer.Sign(content, sig.When)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.PGPSignature = signature
+		}
+		return saveTestCommit(t, s, c)
+	}
+
+	root := newCommit(plumbing.ZeroHash, "root\n", nil)
+	valid := newCommit(root.Hash, "valid\n", trusted)
+	unknown := newCommit(valid.Hash, "unknown\n", other)
+	tampered := *newCommit(unknown.Hash, "tampered\n", trusted)
+	tampered.Message = "changed\n"
+	tampered.Hash = plumbing.ZeroHash
+	invalid := saveTestCommit(t, s, &tampered)
+	unsigned := newCommit(invalid.Hash, "unsigned\n", nil)
+	hist := []*object.Commit{valid, unknown, invalid, unsigned}
+
+	keyring, err := permgit.LoadOpenPGPKeyring([]byte(trustedPublic))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &permgit.RemoveGPGOptions{
+		Verifier:         permgit.NewSignatureVerifier(keyring),
+		SignatureTrailer: "Original-Signature",
+	}
+	newhist, err := permgit.RemoveGPGForLinearHistoryWithOptions(ctx, hist, s, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []permgit.SignatureStatus{
+		permgit.SignatureStatus_Valid,
+		permgit.SignatureStatus_UnknownKey,
+		permgit.SignatureStatus_Invalid,
+		permgit.SignatureStatus_Unsigned,
+	}
+	results := opts.Verifier.Results()
+	if len(results) != len(want) {
+		t.Fatalf("want %d results, got %d", len(want), len(results))
+	}
+	for i, r := range results {
+		if r.Status != want[i] {
+			t.Errorf("commit %d: want %s, got %s", i, want[i], r.Status)
+		}
+		if trailer := "Original-Signature: " + string(want[i]); !strings.Contains(newhist[i].Message, trailer) {
+			t.Errorf("commit %d: message %q has no trailer %q", i, newhist[i].Message, trailer)
+		}
+		if newhist[i].PGPSignature != "" {
+			t.Errorf("commit %d is not stripped", i)
+		}
+	}
+	if !strings.Contains(newhist[0].Message, "trusted <trusted@example.com>") {
+		t.Errorf("signer is not recorded: %q", newhist[0].Message)
+	}
+
+	opts = &permgit.RemoveGPGOptions{
+		Verifier: permgit.NewSignatureVerifier(keyring),
+		AbortOn:  []permgit.SignatureStatus{permgit.SignatureStatus_Invalid, permgit.SignatureStatus_UnknownKey},
+	}
+	_, err = permgit.RemoveGPGForLinearHistoryWithOptions(ctx, hist, s, opts)
+	var unverifiedErr *permgit.UnverifiedSignatureError
+	if !errors.As(err, &unverifiedErr) || unverifiedErr.Verification.Commit != unknown.Hash {
+		t.Errorf("expecting unverified signature error for %s, got %v", unknown.Hash, err)
+	}
+}
+
+// TestSignatureVerifier_expiredKey checks a signature made before the key expired is invalid once the key is expired.
+func TestSignatureVerifier_expiredKey(t *testing.T) {
+	s := memory.NewStorage()
+
+	when := time.Unix(1700000000, 0).UTC()
+	private, public := newTestOpenPGPKeyWithConfig(t, "expired", &packet.Config{
+		Algorithm:       packet.PubKeyAlgoEdDSA,
+		Time:            func() time.Time { return when.Add(-time.Hour) },
+		KeyLifetimeSecs: 86400,
+	})
+	signer, err := permgit.LoadSigner([]byte(private), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := object.Signature{Name: "a", Email: "a@example.com", When: when}
+	c := &object.Commit{Author: sig, Committer: sig, Message: "signed\n", TreeHash: buildTestTree(t, s, map[string]string{"a": "a\n"}).Hash}
+	payload := &plumbing.MemoryObject{}
+	if err := c.EncodeWithoutSignature(payload); err != nil {
+		t.Fatal(err)
+	}
+	r, err := payload.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.PGPSignature, err = signer.Sign(content, when); err != nil {
+		t.Fatal(err)
+	}
+	c = saveTestCommit(t, s, c)
+
+	keyring, err := permgit.LoadOpenPGPKeyring([]byte(public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := permgit.NewSignatureVerifier(keyring).Verify(c); r.Status != permgit.SignatureStatus_Invalid {
+		t.Errorf("want %s for expired key, got %s", permgit.SignatureStatus_Invalid, r.String())
+	}
+}
@@ -0,0 +1,145 @@
+package permgit_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func saveTestCommit(t testing.TB, s storer.EncodedObjectStorer, c *object.Commit) *object.Commit {
+	t.Helper()
+
+	o := s.NewEncodedObject()
+	if err := c.Encode(o); err != nil {
+		t.Fatal(err)
+	}
+	h, err := s.SetEncodedObject(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := object.GetCommit(s, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func newTestSignedCommit(t *testing.T, signer permgit.Signer) *object.Commit {
+	t.Helper()
+
+	s := memory.NewStorage()
+	sig := object.Signature{Name: "a", Email: "a@example.com", When: time.Unix(1700000000, 0).UTC()}
+	c := saveTestCommit(t, s, &object.Commit{
+		Author:    sig,
+		Committer: sig,
+		Message:   "init\n",
+		TreeHash:  buildTestTree(t, s, map[string]string{"a": "a\n"}).Hash,
+	})
+
+	filter, err := permgit.NewPatternFilter("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newcommit, err := permgit.FilterCommitWithOptions(context.Background(), c, nil, s, filter, &permgit.FilterOptions{Signer: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := object.GetCommit(s, newcommit.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return saved
+}
+
+// newTestOpenPGPKey creates a new OpenPGP key, and returns the armored private key and public key.
+func newTestOpenPGPKey(t testing.TB, name string) (string, string) {
+	t.Helper()
+
+	return newTestOpenPGPKeyWithConfig(t, name, &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA})
+}
+
+// newTestOpenPGPKeyWithConfig creates a new OpenPGP key with the config, for example to set the creation time and the lifetime.
+func newTestOpenPGPKeyWithConfig(t testing.TB, name string, config *packet.Config) (string, string) {
+	t.Helper()
+
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var private, public strings.Builder
+	w, err := armor.Encode(&private, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SerializePrivate(w, nil); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	w, err = armor.Encode(&public, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	return private.String(), public.String()
+}
+
+func TestOpenPGPSigner(t *testing.T) {
+	private, public := newTestOpenPGPKey(t, "test")
+
+	signer, err := permgit.LoadSigner([]byte(private), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestSignedCommit(t, signer)
+	if _, err := c.Verify(public); err != nil {
+		t.Errorf("failed to verify signed commit: %v", err)
+	}
+	if again := newTestSignedCommit(t, signer); again.Hash != c.Hash {
+		t.Errorf("signed commit is not deterministic: %s and %s", c.Hash, again.Hash)
+	}
+}
+
+func TestSSHSigner(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := permgit.LoadSigner(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestSignedCommit(t, signer)
+	if !strings.HasPrefix(c.PGPSignature, "-----BEGIN SSH SIGNATURE-----\n") {
+		t.Errorf("unexpected signature: %s", c.PGPSignature)
+	}
+	if again := newTestSignedCommit(t, signer); again.Hash != c.Hash {
+		t.Errorf("signed commit is not deterministic: %s and %s", c.Hash, again.Hash)
+	}
+}
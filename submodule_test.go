@@ -0,0 +1,228 @@
+package permgit_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+const testGitModules = `[submodule "vendor/a"]
+	path = vendor/a
+	url = https://example.com/a.git
+[submodule "vendor/b"]
+	path = vendor/b
+	url = https://example.com/b.git
+`
+
+func TestFilterTreeWithOptions_keepSubmodules(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	editor := permgit.NewTreeEditor(s, nil)
+	acommit := plumbing.NewHash("1111111111111111111111111111111111111111")
+	bcommit := plumbing.NewHash("2222222222222222222222222222222222222222")
+	if err := editor.Apply(
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: ".gitmodules", Content: []byte(testGitModules)},
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: "vendor/a", Mode: filemode.Submodule, Hash: acommit},
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: "vendor/b", Mode: filemode.Submodule, Hash: bcommit},
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: "src/main.go", Content: []byte("package main\n")},
+	); err != nil {
+		t.Fatal(err)
+	}
+	orig, err := editor.Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := permgit.NewOrFilterForPatterns("vendor/a", "src/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dropped, err := permgit.FilterTree(ctx, orig, nil, s, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped, err = object.GetTree(s, dropped.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dropped.FindEntry("vendor/a"); err == nil {
+		t.Errorf("submodule is kept without keep submodules")
+	}
+
+	filtered, err := permgit.FilterTreeWithOptions(ctx, orig, nil, s, filter, &permgit.FilterOptions{
+		KeepSubmodules:  true,
+		SubmoduleURLMap: map[string]string{"https://example.com/a.git": "https://mirror.example.com/a.git"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err = object.GetTree(s, filtered.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e, err := filtered.FindEntry("vendor/a"); err != nil || e.Mode != filemode.Submodule || e.Hash != acommit {
+		t.Errorf("submodule vendor/a is not kept: %v %v", e, err)
+	}
+	if _, err := filtered.FindEntry("vendor/b"); err == nil {
+		t.Errorf("submodule vendor/b is not filtered out")
+	}
+
+	got := readTestTree(t, filtered)
+	wantmodules := "[submodule \"vendor/a\"]\n\tpath = vendor/a\n\turl = https://mirror.example.com/a.git\n"
+	if got[".gitmodules"] != wantmodules {
+		t.Errorf("want .gitmodules %q, got %q", wantmodules, got[".gitmodules"])
+	}
+
+	// update the submodule in the filtered repo, and expand it back.
+	newcommit := plumbing.NewHash("3333333333333333333333333333333333333333")
+	filteredEditor := permgit.NewTreeEditor(s, filtered)
+	if err := filteredEditor.PutObject("vendor/a", filemode.Submodule, newcommit); err != nil {
+		t.Fatal(err)
+	}
+	filteredNew, err := filteredEditor.Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expanded, err := permgit.ExpandTreeWithOptions(ctx, s, filtered, filteredNew, orig, s, filter, &permgit.ExpandOptions{KeepSubmodules: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for p, want := range map[string]plumbing.Hash{"vendor/a": newcommit, "vendor/b": bcommit} {
+		e, err := expanded.FindEntry(p)
+		if err != nil || e.Mode != filemode.Submodule || e.Hash != want {
+			t.Errorf("submodule %s: want %s, got %v %v", p, want, e, err)
+		}
+	}
+	if modules, err := expanded.File(".gitmodules"); err != nil {
+		t.Error(err)
+	} else if content, _ := modules.Contents(); content != testGitModules {
+		t.Errorf("want .gitmodules %q, got %q", testGitModules, content)
+	}
+}
+
+func TestExpandTreeWithOptions_gitModules(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	acommit := plumbing.NewHash("1111111111111111111111111111111111111111")
+	bcommit := plumbing.NewHash("2222222222222222222222222222222222222222")
+	ccommit := plumbing.NewHash("4444444444444444444444444444444444444444")
+	const cmodule = "[submodule \"vendor/c\"]\n\tpath = vendor/c\n\turl = https://example.com/c.git\n"
+
+	build := func(t *testing.T, base *object.Tree, edits ...permgit.TreeEdit) *object.Tree {
+		t.Helper()
+
+		editor := permgit.NewTreeEditor(s, base)
+		if err := editor.Apply(edits...); err != nil {
+			t.Fatal(err)
+		}
+		tree, err := editor.Build(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tree
+	}
+	orig := build(t, nil,
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: ".gitmodules", Content: []byte(testGitModules)},
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: "vendor/a", Mode: filemode.Submodule, Hash: acommit},
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: "vendor/b", Mode: filemode.Submodule, Hash: bcommit},
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: "src/main.go", Content: []byte("package main\n")},
+	)
+
+	expand := func(t *testing.T, filter permgit.Filter, edits ...permgit.TreeEdit) map[string]string {
+		t.Helper()
+
+		opts := &permgit.FilterOptions{
+			KeepSubmodules:  true,
+			SubmoduleURLMap: map[string]string{"https://example.com/a.git": "https://mirror.example.com/a.git"},
+		}
+		filtered, err := permgit.FilterTreeWithOptions(ctx, orig, nil, s, filter, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filtered, err = object.GetTree(s, filtered.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expanded, err := permgit.ExpandTreeWithOptions(ctx, s, filtered, build(t, filtered, edits...), orig, s, filter, &permgit.ExpandOptions{KeepSubmodules: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return readTestTree(t, expanded)
+	}
+
+	t.Run("add", func(t *testing.T) {
+		filter, err := permgit.NewOrFilterForPatterns("src/", "vendor/c")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := expand(t, filter,
+			permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: ".gitmodules", Content: []byte(cmodule)},
+			permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: "vendor/c", Mode: filemode.Submodule, Hash: ccommit},
+		)
+		if want := testGitModules + cmodule; got[".gitmodules"] != want {
+			t.Errorf("want .gitmodules %q, got %q", want, got[".gitmodules"])
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		filter, err := permgit.NewOrFilterForPatterns("src/", "vendor/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := expand(t, filter,
+			permgit.TreeEdit{Op: permgit.TreeEditOp_Delete, Path: ".gitmodules"},
+			permgit.TreeEdit{Op: permgit.TreeEditOp_Delete, Path: "vendor/a"},
+		)
+		if want := "[submodule \"vendor/b\"]\n\tpath = vendor/b\n\turl = https://example.com/b.git\n"; got[".gitmodules"] != want {
+			t.Errorf("want .gitmodules %q, got %q", want, got[".gitmodules"])
+		}
+	})
+
+	t.Run("delete all", func(t *testing.T) {
+		filter, err := permgit.NewOrFilterForPatterns("src/", "vendor/")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := expand(t, filter,
+			permgit.TreeEdit{Op: permgit.TreeEditOp_Delete, Path: ".gitmodules"},
+			permgit.TreeEdit{Op: permgit.TreeEditOp_Delete, Path: "vendor/a"},
+			permgit.TreeEdit{Op: permgit.TreeEditOp_Delete, Path: "vendor/b"},
+		)
+		if content, found := got[".gitmodules"]; found {
+			t.Errorf(".gitmodules is kept without submodules: %q", content)
+		}
+	})
+
+	t.Run("modify", func(t *testing.T) {
+		filter, err := permgit.NewOrFilterForPatterns("src/", "vendor/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := expand(t, filter,
+			permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: ".gitmodules", Content: []byte("[submodule \"vendor/a\"]\n\tpath = vendor/a\n\turl = https://example.com/new-a.git\n")},
+		)
+		want := "[submodule \"vendor/a\"]\n\tpath = vendor/a\n\turl = https://example.com/new-a.git\n[submodule \"vendor/b\"]\n\tpath = vendor/b\n\turl = https://example.com/b.git\n"
+		if got[".gitmodules"] != want {
+			t.Errorf("want .gitmodules %q, got %q", want, got[".gitmodules"])
+		}
+	})
+}
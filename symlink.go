@@ -0,0 +1,167 @@
+package permgit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// SymlinkPolicy decides what to do with the symlinks in the filtered tree pointing outside the repo or to excluded paths.
+type SymlinkPolicy string
+
+const (
+	SymlinkPolicy_Warn SymlinkPolicy = "warn" // log a warning and keep the symlink.
+	SymlinkPolicy_Drop SymlinkPolicy = "drop" // drop the symlink from the filtered tree.
+	SymlinkPolicy_Fail SymlinkPolicy = "fail" // fail with [SymlinkEscapeError].
+)
+
+// SymlinkIssue is a symlink in the filtered tree whose target escapes the repo or is excluded by the filter.
+type SymlinkIssue struct {
+	Path   string
+	Target string
+	// Reason is either "outside the repo" or "excluded by the filter".
+	Reason string
+}
+
+func (i *SymlinkIssue) String() string {
+	return fmt.Sprintf("symlink %s -> %s points %s", i.Path, i.Target, i.Reason)
+}
+
+// SymlinkEscapeError is returned for the symlinks escaping the filtered tree with [SymlinkPolicy_Fail].
+type SymlinkEscapeError struct {
+	Issues []SymlinkIssue
+}
+
+func (e *SymlinkEscapeError) Error() string {
+	lines := make([]string, 0, len(e.Issues))
+	for i := range e.Issues {
+		lines = append(lines, e.Issues[i].String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// resolveSymlink resolves the target of the symlink at the path lexically, false is returned if it escapes the repo.
+// The resolved path is "." if the target is the root of the repo.
+func resolveSymlink(linkpath string, target string) (string, bool) {
+	if path.IsAbs(target) {
+		return "", false
+	}
+
+	resolved := path.Join(path.Dir(linkpath), target)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", false
+	}
+
+	return resolved, true
+}
+
+// treeHasPath checks if the path exists in the tree.
+func treeHasPath(t *object.Tree, p string) bool {
+	if p == "." {
+		return true
+	}
+	_, err := t.FindEntry(p)
+
+	return err == nil
+}
+
+// ValidateSymlinks resolves the targets of the symlinks in the filtered tree relative to their locations,
+// and applies the policy to the symlinks escaping the repo, or pointing at paths that exist in the original tree but not in the filtered tree.
+// Targets are resolved lexically, symlinks in the middle of the targets are not followed, and targets missing in the original tree are ignored.
+//
+// The filtered tree, with the symlinks dropped for [SymlinkPolicy_Drop], is returned with the issues found.
+// Nil is returned for the tree if it becomes empty.
+func ValidateSymlinks(
+	ctx context.Context,
+	s storer.Storer,
+	orig *object.Tree,
+	filtered *object.Tree,
+	policy SymlinkPolicy,
+) (*object.Tree, []SymlinkIssue, error) {
+	switch policy {
+	case SymlinkPolicy_Warn, SymlinkPolicy_Drop, SymlinkPolicy_Fail:
+	default:
+		return nil, nil, fmt.Errorf("unknown symlink policy: %s", policy)
+	}
+
+	// the filtered tree may be created without the storer.
+	stored, err := object.GetTree(s, filtered.Hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to obtain filtered tree %s: %w", filtered.Hash, err)
+	}
+	filtered = stored
+
+	var issues []SymlinkIssue
+
+	walker := object.NewTreeWalker(filtered, true, nil)
+	defer walker.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		default:
+		}
+
+		name, e, err := walker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to walk filtered tree: %w", err)
+		}
+		if e.Mode != filemode.Symlink {
+			continue
+		}
+
+		content, err := readBlobContent(s, e.Hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read symlink %s: %w", name, err)
+		}
+		target := string(content)
+
+		resolved, inside := resolveSymlink(name, target)
+		switch {
+		case !inside:
+			issues = append(issues, SymlinkIssue{Path: name, Target: target, Reason: "outside the repo"})
+		case !treeHasPath(filtered, resolved) && treeHasPath(orig, resolved):
+			issues = append(issues, SymlinkIssue{Path: name, Target: target, Reason: "excluded by the filter"})
+		}
+	}
+
+	if len(issues) == 0 {
+		return filtered, nil, nil
+	}
+
+	switch policy {
+	case SymlinkPolicy_Fail:
+		return nil, issues, &SymlinkEscapeError{Issues: issues}
+	case SymlinkPolicy_Warn:
+		for _, i := range issues {
+			logger.Warn("symlink escapes the filtered tree", "path", i.Path, "target", i.Target, "reason", i.Reason)
+		}
+		return filtered, issues, nil
+	}
+
+	editor := NewTreeEditor(s, filtered)
+	for _, i := range issues {
+		logger.Info("drop symlink escaping the filtered tree", "path", i.Path, "target", i.Target, "reason", i.Reason)
+		if err := editor.Delete(i.Path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	newtree, err := editor.Build(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newtree, issues, nil
+}
@@ -0,0 +1,94 @@
+package permgit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func TestValidateSymlinks(t *testing.T) {
+	ctx := context.Background()
+	s := memory.NewStorage()
+
+	editor := permgit.NewTreeEditor(s, nil)
+	for _, f := range []struct {
+		path    string
+		mode    filemode.FileMode
+		content string
+	}{
+		{"src/a", filemode.Regular, "a\n"},
+		{"src/ok", filemode.Symlink, "a"},
+		{"docs/ok", filemode.Symlink, "../src"},
+		{"src/escape", filemode.Symlink, "../../etc/passwd"},
+		{"src/abs", filemode.Symlink, "/etc/passwd"},
+		{"src/private", filemode.Symlink, "../private/key"},
+		{"src/broken", filemode.Symlink, "missing"},
+		{"private/key", filemode.Regular, "key\n"},
+	} {
+		if _, err := editor.Put(f.path, f.mode, []byte(f.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orig, err := editor.Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := permgit.NewOrFilterForPatterns("src/", "docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err := permgit.FilterTree(ctx, orig, nil, s, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantIssues := map[string]string{
+		"src/abs":     "outside the repo",
+		"src/escape":  "outside the repo",
+		"src/private": "excluded by the filter",
+	}
+
+	validated, issues, err := permgit.ValidateSymlinks(ctx, s, orig, filtered, permgit.SymlinkPolicy_Warn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validated.Hash != filtered.Hash {
+		t.Errorf("tree is changed with warn policy")
+	}
+	if len(issues) != len(wantIssues) {
+		t.Errorf("want %d issues, got %v", len(wantIssues), issues)
+	}
+	for _, i := range issues {
+		if wantIssues[i.Path] != i.Reason {
+			t.Errorf("unexpected issue: %s", i.String())
+		}
+	}
+
+	validated, _, err = permgit.ValidateSymlinks(ctx, s, orig, filtered, permgit.SymlinkPolicy_Drop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readTestTree(t, validated)
+	for _, p := range []string{"src/a", "src/ok", "docs/ok", "src/broken"} {
+		if _, found := got[p]; !found {
+			t.Errorf("%s is dropped", p)
+		}
+	}
+	for p := range wantIssues {
+		if _, found := got[p]; found {
+			t.Errorf("%s is not dropped", p)
+		}
+	}
+
+	_, _, err = permgit.ValidateSymlinks(ctx, s, orig, filtered, permgit.SymlinkPolicy_Fail)
+	var escapeErr *permgit.SymlinkEscapeError
+	if !errors.As(err, &escapeErr) || len(escapeErr.Issues) != len(wantIssues) {
+		t.Errorf("expecting symlink escape error, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package permgit
+
+import (
+	"slices"
+	"strings"
+)
+
+// isTrailerLine checks if the line is a git trailer like "Signed-off-by: Name <email>".
+func isTrailerLine(line string) bool {
+	key, _, found := strings.Cut(line, ":")
+	if !found || key == "" {
+		return false
+	}
+
+	for _, c := range key {
+		if !(c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// trailerBlockStart returns the index of the first line of the trailer block, which is the last paragraph of the lines
+// if all of its lines are trailers and it is not the only paragraph. -1 is returned if there is no trailer block.
+// The lines must not end with blank lines.
+func trailerBlockStart(lines []string) int {
+	start := 0
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) == "" {
+			start = i + 1
+			break
+		}
+	}
+
+	if start == 0 || start >= len(lines) {
+		return -1
+	}
+	for _, l := range lines[start:] {
+		if !isTrailerLine(l) {
+			return -1
+		}
+	}
+
+	return start
+}
+
+// appendTrailers adds the trailers to the end of the message.
+// If the last paragraph of the message is not a trailer block, a blank line is inserted before the trailers.
+func appendTrailers(msg string, trailers ...string) string {
+	if len(trailers) == 0 {
+		return msg
+	}
+
+	msg = strings.TrimRight(msg, "\n")
+
+	istrailerblock := trailerBlockStart(strings.Split(msg, "\n")) >= 0
+
+	var b strings.Builder
+	b.WriteString(msg)
+	switch {
+	case msg == "":
+	case istrailerblock:
+		b.WriteString("\n")
+	default:
+		b.WriteString("\n\n")
+	}
+	for _, t := range trailers {
+		b.WriteString(strings.TrimRight(t, "\n"))
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// removeTrailers removes the trailers with the keys, case insensitive, from the trailer block of the message.
+// The trailer block is removed with the blank line before it if all of its trailers are removed.
+func removeTrailers(msg string, keys ...string) string {
+	if len(keys) == 0 {
+		return msg
+	}
+
+	trimmed := strings.TrimRight(msg, "\n")
+	lines := strings.Split(trimmed, "\n")
+	start := trailerBlockStart(lines)
+	if start < 0 {
+		return msg
+	}
+
+	kept := slices.Clone(lines[:start])
+	removed := false
+	for _, l := range lines[start:] {
+		key, _, _ := strings.Cut(l, ":")
+		if slices.ContainsFunc(keys, func(k string) bool { return strings.EqualFold(strings.TrimSpace(key), k) }) {
+			removed = true
+			continue
+		}
+		kept = append(kept, l)
+	}
+
+	if !removed {
+		return msg
+	}
+	if len(kept) == start {
+		// drop the blank lines before the removed trailer block.
+		for len(kept) > 0 && strings.TrimSpace(kept[len(kept)-1]) == "" {
+			kept = kept[:len(kept)-1]
+		}
+	}
+
+	return strings.Join(kept, "\n") + msg[len(trimmed):]
+}
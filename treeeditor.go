@@ -0,0 +1,322 @@
+package permgit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// TreeEditOp is the operation of a [TreeEdit].
+type TreeEditOp string
+
+const (
+	// TreeEditOp_Put writes a file at the path, from either content or an object hash.
+	TreeEditOp_Put TreeEditOp = "put"
+	// TreeEditOp_Delete deletes a file or a directory at the path.
+	TreeEditOp_Delete TreeEditOp = "delete"
+	// TreeEditOp_Move moves a file or a directory from the path to another path.
+	TreeEditOp_Move TreeEditOp = "move"
+	// TreeEditOp_Mkdir creates a directory at the path.
+	TreeEditOp_Mkdir TreeEditOp = "mkdir"
+)
+
+// TreeEdit is a single edit applied by [TreeEditor.Apply].
+type TreeEdit struct {
+	Op   TreeEditOp
+	Path string
+	// To is the destination of [TreeEditOp_Move].
+	To string
+	// Mode is the file mode of [TreeEditOp_Put], default to [filemode.Regular].
+	Mode filemode.FileMode
+	// Hash is the object of [TreeEditOp_Put], the object must already be in the storer. Content is used if Hash is zero.
+	// For [filemode.Submodule], Hash is the commit of the submodule and is required.
+	Hash plumbing.Hash
+	// Content is the content of the blob of [TreeEditOp_Put].
+	Content []byte
+}
+
+// TreeEditor edits a git tree by paths without a worktree, and saves the changed trees into the storer.
+// Sub trees that are not touched keep their hashes.
+//
+// Directories in git only exist by the files they contain, so directories that become empty are dropped when the tree is built.
+type TreeEditor struct {
+	s    storer.Storer
+	root *inflightTree
+}
+
+// NewTreeEditor creates a new [TreeEditor] on top of the base tree, a nil base starts with an empty tree.
+// New objects are saved into the storer, which must contain the base tree.
+func NewTreeEditor(s storer.Storer, base *object.Tree) *TreeEditor {
+	return &TreeEditor{
+		s:    s,
+		root: newInflightTree(base),
+	}
+}
+
+// splitEditPath splits the path into segments, and rejects empty, relative, or .git segments.
+func splitEditPath(path string) ([]string, error) {
+	segs := strings.Split(path, "/")
+	for _, seg := range segs {
+		switch seg {
+		case "", ".", "..":
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+		if strings.EqualFold(seg, ".git") {
+			return nil, fmt.Errorf("invalid path %q: .git is not allowed", path)
+		}
+	}
+
+	return segs, nil
+}
+
+// validateMove checks the destination is not inside the source.
+func validateMove(from string, to string) error {
+	if from == to || strings.HasPrefix(to, from+"/") {
+		return fmt.Errorf("cannot move %s into itself at %s", from, to)
+	}
+
+	return nil
+}
+
+// validateFileMode checks the mode is allowed for a file entry.
+func validateFileMode(mode filemode.FileMode) error {
+	switch mode {
+	case filemode.Regular, filemode.Executable, filemode.Symlink, filemode.Submodule:
+		return nil
+	default:
+		return fmt.Errorf("invalid file mode %s", mode)
+	}
+}
+
+// Put writes the content as a blob at the path with the mode, and returns the hash of the blob.
+// Parent directories are created if they don't exist, and existing file at the path is overwritten.
+// [filemode.Submodule] is rejected, since a submodule points at a commit, see [TreeEditor.PutObject].
+func (e *TreeEditor) Put(path string, mode filemode.FileMode, content []byte) (plumbing.Hash, error) {
+	segs, err := splitEditPath(path)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if err := validateFileMode(mode); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if mode == filemode.Submodule {
+		return plumbing.ZeroHash, fmt.Errorf("cannot put content at submodule %s, put the commit hash instead", path)
+	}
+
+	hash, err := saveBlob(e.s, content)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := e.root.Set(hash, mode, segs); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to put %s: %w", path, err)
+	}
+
+	return hash, nil
+}
+
+// PutObject sets the file at the path to the object with the hash, which must already be in the storer.
+// For [filemode.Submodule], the hash is the commit of the submodule and doesn't need to be in the storer.
+func (e *TreeEditor) PutObject(path string, mode filemode.FileMode, hash plumbing.Hash) error {
+	segs, err := splitEditPath(path)
+	if err != nil {
+		return err
+	}
+	if err := validateFileMode(mode); err != nil {
+		return err
+	}
+	if mode != filemode.Submodule {
+		if err := e.s.HasEncodedObject(hash); err != nil {
+			return fmt.Errorf("object %s for %s is not in storer: %w", hash, path, err)
+		}
+	}
+
+	if err := e.root.Set(hash, mode, segs); err != nil {
+		return fmt.Errorf("failed to put %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Delete deletes the file or the directory at the path.
+func (e *TreeEditor) Delete(path string) error {
+	segs, err := splitEditPath(path)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := e.root.Remove(segs); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Move moves the file or the directory at from to to, and to must not exist.
+func (e *TreeEditor) Move(from string, to string) error {
+	fromsegs, err := splitEditPath(from)
+	if err != nil {
+		return err
+	}
+	tosegs, err := splitEditPath(to)
+	if err != nil {
+		return err
+	}
+	if err := validateMove(from, to); err != nil {
+		return err
+	}
+	if _, found := e.root.Entry(tosegs); found {
+		return fmt.Errorf("cannot move %s to %s: destination exists", from, to)
+	}
+	// check the parents of the destination before removing the source, so a failed move leaves the tree intact.
+	for i := 1; i < len(tosegs); i++ {
+		if parent, found := e.root.Entry(tosegs[:i]); found && parent.Mode != filemode.Dir {
+			return fmt.Errorf("cannot move %s to %s: %s is not a directory", from, to, strings.Join(tosegs[:i], "/"))
+		}
+	}
+
+	entry, subtree, err := e.root.Remove(fromsegs)
+	if err != nil {
+		return fmt.Errorf("failed to move %s: %w", from, err)
+	}
+
+	if err := e.root.Insert(tosegs, entry, subtree); err != nil {
+		return fmt.Errorf("failed to move %s to %s: %w", from, to, err)
+	}
+
+	return nil
+}
+
+// Mkdir creates the directory at the path and its parents.
+// The directory is dropped when the tree is built unless files are added to it.
+func (e *TreeEditor) Mkdir(path string) error {
+	segs, err := splitEditPath(path)
+	if err != nil {
+		return err
+	}
+
+	if err := e.root.Mkdir(segs); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Entry returns the entry at the path, which can be a file or a directory.
+// Hashes of changed directories are only updated by [TreeEditor.Build].
+func (e *TreeEditor) Entry(path string) (object.TreeEntry, bool) {
+	segs, err := splitEditPath(path)
+	if err != nil {
+		return object.TreeEntry{}, false
+	}
+
+	return e.root.Entry(segs)
+}
+
+// validate checks the paths and modes of the edit.
+func (edit *TreeEdit) validate() error {
+	if _, err := splitEditPath(edit.Path); err != nil {
+		return err
+	}
+
+	switch edit.Op {
+	case TreeEditOp_Put:
+		if edit.Mode == filemode.Submodule && edit.Hash.IsZero() {
+			return fmt.Errorf("submodule %s requires the commit hash", edit.Path)
+		}
+		if edit.Mode != 0 {
+			return validateFileMode(edit.Mode)
+		}
+	case TreeEditOp_Move:
+		if _, err := splitEditPath(edit.To); err != nil {
+			return err
+		}
+		return validateMove(edit.Path, edit.To)
+	case TreeEditOp_Delete, TreeEditOp_Mkdir:
+	default:
+		return fmt.Errorf("unknown tree edit operation %q", edit.Op)
+	}
+
+	return nil
+}
+
+// Apply applies the edits in order. All the edits are validated before any of them is applied,
+// however, an edit failing in the middle leaves the previous edits applied.
+func (e *TreeEditor) Apply(edits ...TreeEdit) error {
+	for i := range edits {
+		if err := edits[i].validate(); err != nil {
+			return fmt.Errorf("invalid edit #%d: %w", i, err)
+		}
+	}
+
+	for i, edit := range edits {
+		var err error
+		switch edit.Op {
+		case TreeEditOp_Put:
+			mode := edit.Mode
+			if mode == 0 {
+				mode = filemode.Regular
+			}
+			if edit.Hash.IsZero() {
+				_, err = e.Put(edit.Path, mode, edit.Content)
+			} else {
+				err = e.PutObject(edit.Path, mode, edit.Hash)
+			}
+		case TreeEditOp_Delete:
+			err = e.Delete(edit.Path)
+		case TreeEditOp_Move:
+			err = e.Move(edit.Path, edit.To)
+		case TreeEditOp_Mkdir:
+			err = e.Mkdir(edit.Path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply edit #%d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Build saves the changed trees into the storer and returns the new root tree.
+// The editor can continue to be used after build.
+func (e *TreeEditor) Build(ctx context.Context) (*object.Tree, error) {
+	tree, err := e.root.BuildTree(ctx, e.s)
+	if err != nil {
+		return nil, errorf(err, "failed to build tree: %w", err)
+	}
+
+	return tree, nil
+}
+
+// Commit builds the tree, and saves a new commit with the tree, parents, signatures, and message into the storer.
+func (e *TreeEditor) Commit(
+	ctx context.Context,
+	parents []plumbing.Hash,
+	author object.Signature,
+	committer object.Signature,
+	message string,
+) (*object.Commit, error) {
+	tree, err := e.Build(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	newcommit := &object.Commit{
+		Author:       author,
+		Committer:    committer,
+		Message:      message,
+		TreeHash:     tree.Hash,
+		ParentHashes: append([]plumbing.Hash{}, parents...),
+	}
+
+	if err := updateHashAndSave(ctx, newcommit, e.s); err != nil {
+		return nil, errorf(err, "failed to save commit: %w", err)
+	}
+
+	return newcommit, nil
+}
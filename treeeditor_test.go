@@ -0,0 +1,129 @@
+package permgit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/fardream/permgit"
+)
+
+func TestTreeEditor(t *testing.T) {
+	s := memory.NewStorage()
+	base := buildTestTree(t, s, map[string]string{
+		"a/b/c.txt": "c\n",
+		"a/d.txt":   "d\n",
+		"e/f.txt":   "f\n",
+		"g.txt":     "g\n",
+	})
+
+	editor := permgit.NewTreeEditor(s, base)
+	err := editor.Apply(
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Put, Path: "gen/out.txt", Content: []byte("out\n")},
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Move, Path: "a/b", To: "x/y"},
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Delete, Path: "e"},
+		permgit.TreeEdit{Op: permgit.TreeEditOp_Mkdir, Path: "empty/dir"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := object.Signature{Name: "a", Email: "a@example.com", When: time.Unix(0, 0).UTC()}
+	commit, err := editor.Commit(context.Background(), []plumbing.Hash{plumbing.ZeroHash}, sig, sig, "edit\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := object.GetTree(s, commit.TreeHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := readTestTree(t, tree)
+	want := map[string]string{
+		"a/d.txt":     "d\n",
+		"x/y/c.txt":   "c\n",
+		"g.txt":       "g\n",
+		"gen/out.txt": "out\n",
+	}
+	if len(got) != len(want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("file %s: want %q, got %q", k, v, got[k])
+		}
+	}
+
+	wanttree := buildTestTree(t, s, want)
+	if tree.Hash != wanttree.Hash {
+		t.Errorf("want tree %s, got %s", wanttree.Hash, tree.Hash)
+	}
+}
+
+func TestTreeEditor_invalid(t *testing.T) {
+	s := memory.NewStorage()
+	base := buildTestTree(t, s, map[string]string{
+		"a/b.txt": "b\n",
+	})
+
+	editor := permgit.NewTreeEditor(s, base)
+	for _, edit := range []permgit.TreeEdit{
+		{Op: permgit.TreeEditOp_Put, Path: "../x"},
+		{Op: permgit.TreeEditOp_Put, Path: ".git/config"},
+		{Op: permgit.TreeEditOp_Put, Path: "a//x"},
+		{Op: permgit.TreeEditOp_Put, Path: "x", Mode: filemode.Dir},
+		{Op: permgit.TreeEditOp_Put, Path: "x", Mode: filemode.Submodule, Content: []byte("x\n")},
+		{Op: permgit.TreeEditOp_Move, Path: "a", To: "a/c"},
+		{Op: "copy", Path: "a"},
+	} {
+		// the valid edit at front must not be applied if any edit is invalid.
+		if err := editor.Apply(permgit.TreeEdit{Op: permgit.TreeEditOp_Delete, Path: "a"}, edit); err == nil {
+			t.Errorf("expecting error for %v", edit)
+		}
+	}
+
+	if _, found := editor.Entry("a/b.txt"); !found {
+		t.Errorf("a/b.txt is deleted by invalid edits")
+	}
+	if _, err := editor.Put("a", filemode.Regular, nil); err == nil {
+		t.Errorf("expecting error overwriting directory with a file")
+	}
+	if _, err := editor.Put("x", filemode.Submodule, []byte("x\n")); err == nil {
+		t.Errorf("expecting error putting content at a submodule")
+	}
+	if _, found := editor.Entry("x"); found {
+		t.Errorf("submodule x is created from content")
+	}
+}
+
+func TestTreeEditor_moveUnderFile(t *testing.T) {
+	s := memory.NewStorage()
+	base := buildTestTree(t, s, map[string]string{
+		"a/b.txt": "b\n",
+		"g.txt":   "g\n",
+	})
+
+	editor := permgit.NewTreeEditor(s, base)
+	for _, to := range []string{"g.txt/x", "g.txt/y/z"} {
+		if err := editor.Move("a/b.txt", to); err == nil {
+			t.Errorf("expecting error moving under a file to %s", to)
+		}
+	}
+	if err := editor.Move("a", "g.txt/a"); err == nil {
+		t.Errorf("expecting error moving a directory under a file")
+	}
+
+	tree, err := editor.Build(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.Hash != base.Hash {
+		t.Errorf("failed moves changed the tree: %v", readTestTree(t, tree))
+	}
+}